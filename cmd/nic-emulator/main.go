@@ -0,0 +1,90 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+// Command nic-emulator emulates a SmartNIC or bare-metal server so that the
+// SmartNIC path of the provider runs without the hardware. Several
+// emulators can run on one machine on addresses such as 127.0.0.2.
+//
+//	nic-emulator -address 127.0.0.2 -etcd 127.0.0.1:2379 -latency 200us -loss 0.01
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/emulator"
+	"github.com/Lambda-NIC/faas-netes/handlers"
+	"github.com/Lambda-NIC/faas-netes/logging"
+)
+
+func main() {
+	address := flag.String("address", "127.0.0.1", "IP to listen on and register as")
+	ports := flag.String("ports", fmt.Sprintf("%d,%d", handlers.DefaultSmartNICPort,
+		handlers.DefaultBareMetalPort), "UDP ports to listen on")
+	latency := flag.Duration("latency", 0, "delay of every reply")
+	jitter := flag.Duration("jitter", 0, "random delay added to every reply, up to this")
+	loss := flag.Float64("loss", 0, "share of requests dropped")
+	reorder := flag.Float64("reorder", 0, "share of replies sent after the next one")
+	reply := flag.String("reply", "", "reply to every request, the job ID is replied when empty")
+	etcd := flag.String("etcd", "", "host:port of etcd to register in, not registered when empty")
+	capacity := flag.Uint64("capacity", 0, "replica capacity registered in etcd, none when 0")
+	heartbeat := flag.Duration("heartbeat", 2*time.Second, "interval of the heartbeats and acknowledgements written to etcd")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "seed of the latency, loss and reordering")
+	logLevel := flag.String("log-level", "info", "lowest level logged, debug logs every request")
+	flag.Parse()
+
+	level, err := logging.ParseLevel(*logLevel)
+	exitOnError(err)
+	logging.Configure(level, logging.FormatLogfmt, os.Stderr)
+
+	config := emulator.Config{
+		Address:     *address,
+		Latency:     *latency,
+		Jitter:      *jitter,
+		LossRate:    *loss,
+		ReorderRate: *reorder,
+		Seed:        *seed,
+	}
+	for _, port := range strings.Split(*ports, ",") {
+		value, convErr := strconv.Atoi(strings.TrimSpace(port))
+		exitOnError(convErr)
+		config.Ports = append(config.Ports, value)
+	}
+
+	nic := emulator.New(config)
+	if len(*reply) > 0 {
+		nic.HandleDefault(func(emulator.Request) ([]byte, error) {
+			return []byte(*reply), nil
+		})
+	}
+	exitOnError(nic.Start())
+	for _, addr := range nic.Addrs() {
+		logging.Info("Listening", "address", addr)
+	}
+
+	if len(*etcd) > 0 {
+		host, port, splitErr := net.SplitHostPort(*etcd)
+		exitOnError(splitErr)
+		keysAPI := handlers.CreateEtcdClient(host, port)
+		exitOnError(nic.Register(keysAPI, *capacity, *heartbeat))
+		logging.Info("Registered in etcd", "smartnic", *address)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	nic.Close()
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
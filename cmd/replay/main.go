@@ -0,0 +1,151 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+// Command replay sends the invocations of a trace recorded by the proxy to a
+// provider, at the original or a scaled speed, and reports the latency
+// percentiles.
+//
+//	replay -provider http://127.0.0.1:8080 -speed 2 trace.jsonl.1 trace.jsonl
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/recording"
+)
+
+var percentiles = []float64{50, 90, 95, 99, 100}
+
+func main() {
+	provider := flag.String("provider", "http://127.0.0.1:8080", "URL of the provider")
+	speed := flag.Float64("speed", 1, "speed-up of the replay, 0 sends every invocation at once")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of each invocation")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] trace.jsonl...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 || *speed < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	records := []recording.Record{}
+	for _, path := range flag.Args() {
+		fileRecords, err := readTrace(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		records = append(records, fileRecords...)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	base := strings.TrimRight(*provider, "/")
+	results := recording.Replay(records, *speed, func(record recording.Record) (int, error) {
+		return invoke(client, base, record)
+	})
+	report(os.Stdout, results)
+}
+
+func readTrace(path string) ([]recording.Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return recording.ReadRecords(file)
+}
+
+// invoke sends a recorded invocation. SmartNIC and bare-metal functions get
+// the recorded job ID, other functions a body of the recorded size.
+func invoke(client *http.Client, base string,
+	record recording.Record) (int, error) {
+	body := bytes.Repeat([]byte("x"), int(record.PayloadBytes))
+	if record.JobID != nil {
+		body = []byte(strconv.Itoa(*record.JobID))
+	}
+	method := record.Method
+	if len(method) == 0 {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequest(method, base+"/function/"+record.Function,
+		bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	return res.StatusCode, nil
+}
+
+// report writes the errors and latency percentiles of the replay, overall
+// and for every function, next to those of the recorded trace
+func report(writer io.Writer, results []recording.Result) {
+	replayed := map[string][]time.Duration{}
+	recorded := map[string][]time.Duration{}
+	errors := map[string]int{}
+	recordedErrors := map[string]int{}
+	functions := []string{}
+	for _, result := range results {
+		name := result.Record.Function
+		if _, ok := replayed[name]; !ok {
+			functions = append(functions, name)
+		}
+		replayed[name] = append(replayed[name], result.Latency)
+		recorded[name] = append(recorded[name],
+			time.Duration(result.Record.Latency*float64(time.Second)))
+		if result.Err != nil || result.Status >= http.StatusBadRequest {
+			errors[name]++
+		}
+		if result.Record.Status >= http.StatusBadRequest {
+			recordedErrors[name]++
+		}
+	}
+	sort.Strings(functions)
+
+	w := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	header := "FUNCTION\tSOURCE\tINVOCATIONS\tERRORS"
+	for _, p := range percentiles {
+		header += fmt.Sprintf("\tP%g", p)
+	}
+	fmt.Fprintln(w, strings.Replace(header, "P100", "MAX", 1))
+
+	all := map[string][]time.Duration{}
+	totalErrors, totalRecordedErrors := 0, 0
+	for _, name := range functions {
+		writeRow(w, name, "replayed", replayed[name], errors[name])
+		writeRow(w, name, "recorded", recorded[name], recordedErrors[name])
+		all["replayed"] = append(all["replayed"], replayed[name]...)
+		all["recorded"] = append(all["recorded"], recorded[name]...)
+		totalErrors += errors[name]
+		totalRecordedErrors += recordedErrors[name]
+	}
+	writeRow(w, "all", "replayed", all["replayed"], totalErrors)
+	writeRow(w, "all", "recorded", all["recorded"], totalRecordedErrors)
+	w.Flush()
+}
+
+func writeRow(w io.Writer, name string, kind string,
+	latencies []time.Duration, errors int) {
+	row := fmt.Sprintf("%s\t%s\t%d\t%d", name, kind, len(latencies), errors)
+	for _, p := range percentiles {
+		row += "\t" + recording.Percentile(latencies, p).String()
+	}
+	fmt.Fprintln(w, row)
+}
@@ -0,0 +1,170 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+// Command simulator replays invocation traces recorded by the proxy on a
+// simulated SmartNIC inventory for every placement strategy and routing
+// mode, and reports the utilization, rejections and latencies of each.
+//
+//	simulator -nics nics.json -functions functions.json trace.jsonl
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/handlers"
+	"github.com/Lambda-NIC/faas-netes/logging"
+	"github.com/Lambda-NIC/faas-netes/recording"
+	"github.com/Lambda-NIC/faas-netes/simulator"
+)
+
+var percentiles = []float64{50, 90, 99, 100}
+
+func main() {
+	nicsPath := flag.String("nics", "", "JSON file with the SmartNIC inventory")
+	functionsPath := flag.String("functions", "", "JSON file with the function set")
+	placements := flag.String("placement", strings.Join(handlers.PlacementStrategies, ","),
+		"placement strategies to simulate")
+	routings := flag.String("routing", handlers.RoutingRandom+","+handlers.RoutingAdaptive,
+		"routing modes to simulate")
+	maxInflight := flag.Int("max-inflight", 0, "invocations outstanding on a SmartNIC before it is saturated")
+	timeout := flag.Duration("timeout", 2*time.Second, "how long the proxy waits for a dropped invocation")
+	breakerFailures := flag.Int("breaker-failures", 5, "consecutive failures which open a circuit, 0 disables")
+	breakerErrorRate := flag.Float64("breaker-error-rate", 0.5, "error rate which opens a circuit, 0 disables")
+	breakerOpen := flag.Duration("breaker-open-duration", 5*time.Second, "how long a circuit stays open")
+	seed := flag.Int64("seed", 1, "seed of the random choices")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -nics nics.json -functions functions.json [flags] trace.jsonl...\n",
+			os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if len(*nicsPath) == 0 || len(*functionsPath) == 0 || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	// Circuit changes would be logged on every simulated transition.
+	logging.Configure(logging.LevelError, logging.FormatLogfmt, os.Stderr)
+
+	config := simulator.Config{
+		MaxInflight: *maxInflight,
+		Timeout:     *timeout,
+		Seed:        *seed,
+		Breaker: &handlers.CircuitBreakerConfig{
+			ConsecutiveFailures: *breakerFailures,
+			ErrorRate:           *breakerErrorRate,
+			OpenDuration:        *breakerOpen,
+		},
+	}
+	exitOnError(readJSON(*nicsPath, &config.NICs))
+	exitOnError(readJSON(*functionsPath, &config.Functions))
+
+	records := []recording.Record{}
+	for _, path := range flag.Args() {
+		file, err := os.Open(path)
+		exitOnError(err)
+		fileRecords, err := recording.ReadRecords(file)
+		file.Close()
+		if err != nil {
+			exitOnError(fmt.Errorf("%s: %v", path, err))
+		}
+		records = append(records, fileRecords...)
+	}
+
+	reports := []*simulator.Report{}
+	for _, placement := range strings.Split(*placements, ",") {
+		for _, routing := range strings.Split(*routings, ",") {
+			config.Placement = strings.TrimSpace(placement)
+			config.Routing = strings.TrimSpace(routing)
+			report, err := simulator.Run(config, records)
+			exitOnError(err)
+			reports = append(reports, report)
+		}
+	}
+	writeReports(os.Stdout, reports)
+}
+
+func readJSON(path string, value interface{}) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = json.NewDecoder(file).Decode(value); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// writeReports writes the outcomes and latencies of every strategy, then
+// those of every function and SmartNIC
+func writeReports(writer io.Writer, reports []*simulator.Report) {
+	w := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PLACEMENT\tROUTING\t"+countsHeader())
+	for _, report := range reports {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", report.Placement, report.Routing,
+			countsRow(&report.Counts))
+	}
+	if len(reports) > 0 && reports[0].Skipped > 0 {
+		fmt.Fprintf(w, "\n%d invocations of functions missing from the function set were skipped\n",
+			reports[0].Skipped)
+	}
+
+	fmt.Fprintln(w, "\nPLACEMENT\tROUTING\tFUNCTION\t"+countsHeader())
+	for _, report := range reports {
+		names := []string{}
+		for name := range report.Functions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", report.Placement, report.Routing,
+				name, countsRow(report.Functions[name]))
+		}
+	}
+
+	fmt.Fprintln(w, "\nPLACEMENT\tROUTING\tSMARTNIC\tREPLICAS\tUTILIZATION\t"+countsHeader())
+	for _, report := range reports {
+		for _, nic := range report.NICs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%.1f%%\t%s\n", report.Placement,
+				report.Routing, nic.Address, nic.Replicas, nic.Utilization*100,
+				countsRow(&nic.Counts))
+		}
+	}
+	w.Flush()
+}
+
+func countsHeader() string {
+	header := "INVOCATIONS\tSERVED\tREJECTED\tFAILED\tUNROUTED\tOVERFLOWED"
+	for _, p := range percentiles {
+		if p == 100 {
+			header += "\tMAX"
+		} else {
+			header += fmt.Sprintf("\tP%g", p)
+		}
+	}
+	return header
+}
+
+func countsRow(counts *simulator.Counts) string {
+	row := fmt.Sprintf("%d\t%d\t%d\t%d\t%d\t%d", counts.Invocations,
+		counts.Served, counts.Rejected, counts.Failed, counts.Unrouted,
+		counts.Overflowed)
+	for _, p := range percentiles {
+		row += "\t" + counts.Latency(p).String()
+	}
+	return row
+}
@@ -0,0 +1,258 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+// Package emulator emulates a SmartNIC or bare-metal server speaking the UDP
+// protocol of the proxy, so that the SmartNIC path can run without the
+// hardware. Requests are a 4 byte big endian job ID followed by a 16 byte
+// data field, and the reply is sent back as is to the sender.
+package emulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/handlers"
+	"github.com/Lambda-NIC/faas-netes/logging"
+	"github.com/Lambda-NIC/faas-netes/tracing"
+)
+
+// Request is an invocation received by the emulator
+type Request struct {
+	JobID uint32
+	// Data is the data field, blank or the trace ID of the invocation
+	Data []byte
+	// Port is the port the request was received on
+	Port   int
+	Remote *net.UDPAddr
+}
+
+// TraceID returns the trace ID sent with a traced invocation
+func (r Request) TraceID() (tracing.TraceID, bool) {
+	traceID := tracing.TraceID{}
+	if len(r.Data) != len(traceID) || len(bytes.TrimSpace(r.Data)) == 0 {
+		return traceID, false
+	}
+	copy(traceID[:], r.Data)
+	return traceID, traceID.IsValid()
+}
+
+// Handler serves a request and returns the reply, no reply is sent when it
+// returns an error. The proxy reads up to 32 bytes of the reply.
+type Handler func(request Request) ([]byte, error)
+
+// JobIDHandler replies with the job ID in decimal
+func JobIDHandler(request Request) ([]byte, error) {
+	return []byte(strconv.FormatUint(uint64(request.JobID), 10)), nil
+}
+
+// Config specify where the emulator listens and how it misbehaves
+type Config struct {
+	// Address is the IP the emulator listens on and registers as
+	Address string
+	// Ports are the UDP ports to listen on, the SmartNIC and bare-metal
+	// ports by default. Zero picks a free port.
+	Ports []int
+	// Latency delays every reply, with up to Jitter added at random
+	Latency time.Duration
+	Jitter  time.Duration
+	// LossRate is the share of requests which are dropped
+	LossRate float64
+	// ReorderRate is the share of replies held back and sent after the next
+	// one, or after ReorderDelay when no other reply comes
+	ReorderRate  float64
+	ReorderDelay time.Duration
+	// Seed makes the random latency, loss and reordering repeatable
+	Seed int64
+}
+
+// Emulator serves invocations on UDP ports with a handler per job ID
+type Emulator struct {
+	config Config
+
+	mutex    sync.Mutex
+	rnd      *rand.Rand
+	handlers map[uint32]Handler
+	fallback Handler
+	conns    []*net.UDPConn
+	held     map[*net.UDPConn]*heldReply
+	closed   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// heldReply is a reply held back to be sent out of order
+type heldReply struct {
+	reply  []byte
+	remote *net.UDPAddr
+	timer  *time.Timer
+}
+
+// New creates an emulator which replies with the job ID until handlers are
+// set, call Start to listen.
+func New(config Config) *Emulator {
+	if len(config.Ports) == 0 {
+		config.Ports = []int{handlers.DefaultSmartNICPort, handlers.DefaultBareMetalPort}
+	}
+	if config.ReorderDelay <= 0 {
+		config.ReorderDelay = 10 * time.Millisecond
+	}
+	return &Emulator{
+		config:   config,
+		rnd:      rand.New(rand.NewSource(config.Seed)),
+		handlers: map[uint32]Handler{},
+		fallback: JobIDHandler,
+		held:     map[*net.UDPConn]*heldReply{},
+		closed:   make(chan struct{}),
+	}
+}
+
+// Handle serves the requests of a job ID with handler
+func (e *Emulator) Handle(jobID uint32, handler Handler) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.handlers[jobID] = handler
+}
+
+// HandleDefault serves the requests of job IDs without a handler
+func (e *Emulator) HandleDefault(handler Handler) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.fallback = handler
+}
+
+// Start listens on every port of the config
+func (e *Emulator) Start() error {
+	for _, port := range e.config.Ports {
+		addr := &net.UDPAddr{IP: net.ParseIP(e.config.Address), Port: port}
+		conn, err := net.ListenUDP("udp4", addr)
+		if err != nil {
+			e.Close()
+			return fmt.Errorf("could not listen on %s: %v", addr, err)
+		}
+		e.mutex.Lock()
+		e.conns = append(e.conns, conn)
+		e.mutex.Unlock()
+		e.wg.Add(1)
+		go e.serve(conn)
+	}
+	return nil
+}
+
+// Addrs returns the addresses the emulator listens on
+func (e *Emulator) Addrs() []*net.UDPAddr {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	addrs := []*net.UDPAddr{}
+	for _, conn := range e.conns {
+		addrs = append(addrs, conn.LocalAddr().(*net.UDPAddr))
+	}
+	return addrs
+}
+
+// Close stops listening, replies which are outstanding are not sent
+func (e *Emulator) Close() error {
+	e.mutex.Lock()
+	select {
+	case <-e.closed:
+		e.mutex.Unlock()
+		return nil
+	default:
+	}
+	close(e.closed)
+	for _, conn := range e.conns {
+		conn.Close()
+	}
+	e.mutex.Unlock()
+	e.wg.Wait()
+	return nil
+}
+
+func (e *Emulator) serve(conn *net.UDPConn) {
+	defer e.wg.Done()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	buf := make([]byte, 64)
+	for {
+		n, remote, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if n < 4 {
+			logging.Debug("Short request", "remote", remote, "bytes", n)
+			continue
+		}
+		request := Request{
+			JobID:  binary.BigEndian.Uint32(buf[:4]),
+			Data:   append([]byte{}, buf[4:n]...),
+			Port:   port,
+			Remote: remote,
+		}
+		go e.reply(conn, request)
+	}
+}
+
+// reply serves a request after the configured latency, unless it is lost
+func (e *Emulator) reply(conn *net.UDPConn, request Request) {
+	e.mutex.Lock()
+	lost := e.config.LossRate > 0 && e.rnd.Float64() < e.config.LossRate
+	delay := e.config.Latency
+	if e.config.Jitter > 0 {
+		delay += time.Duration(e.rnd.Int63n(int64(e.config.Jitter)))
+	}
+	handler, ok := e.handlers[request.JobID]
+	if !ok {
+		handler = e.fallback
+	}
+	e.mutex.Unlock()
+
+	if lost {
+		logging.Debug("Dropped request", "job_id", request.JobID)
+		return
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-e.closed:
+			return
+		}
+	}
+
+	reply, err := handler(request)
+	if err != nil {
+		logging.Warn("Handler failed", "job_id", request.JobID, "error", err)
+		return
+	}
+	e.send(conn, reply, request.Remote)
+}
+
+// send writes a reply, holding it back or releasing a held one to reorder
+// replies
+func (e *Emulator) send(conn *net.UDPConn, reply []byte, remote *net.UDPAddr) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if held, ok := e.held[conn]; ok {
+		held.timer.Stop()
+		delete(e.held, conn)
+		conn.WriteToUDP(reply, remote)
+		conn.WriteToUDP(held.reply, held.remote)
+		return
+	}
+	if e.config.ReorderRate > 0 && e.rnd.Float64() < e.config.ReorderRate {
+		held := &heldReply{reply: reply, remote: remote}
+		held.timer = time.AfterFunc(e.config.ReorderDelay, func() {
+			e.mutex.Lock()
+			defer e.mutex.Unlock()
+			if e.held[conn] == held {
+				delete(e.held, conn)
+				conn.WriteToUDP(held.reply, held.remote)
+			}
+		})
+		e.held[conn] = held
+		return
+	}
+	conn.WriteToUDP(reply, remote)
+}
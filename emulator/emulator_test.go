@@ -0,0 +1,222 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/handlers"
+	"github.com/Lambda-NIC/faas-netes/tracing"
+	"github.com/Lambda-NIC/faas-netes/types"
+	"github.com/gorilla/mux"
+)
+
+func startEmulator(t *testing.T, config Config) (*Emulator, *net.UDPAddr) {
+	config.Address = "127.0.0.1"
+	config.Ports = []int{0}
+	nic := New(config)
+	if err := nic.Start(); err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	return nic, nic.Addrs()[0]
+}
+
+// dial returns a client socket sending frames to addr
+func dial(t *testing.T, addr *net.UDPAddr) *net.UDPConn {
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func send(t *testing.T, conn *net.UDPConn, jobID uint32) {
+	frame := make([]byte, 4)
+	binary.BigEndian.PutUint32(frame, jobID)
+	frame = append(frame, []byte("                ")...)
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receive(conn *net.UDPConn, timeout time.Duration) (string, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 32)
+	n, err := conn.Read(buf)
+	return string(buf[:n]), err
+}
+
+func Test_Emulator_HandlersPerJobID(t *testing.T) {
+	nic, addr := startEmulator(t, Config{})
+	defer nic.Close()
+	nic.Handle(7, func(request Request) ([]byte, error) {
+		if request.Port != addr.Port || len(request.Data) != 16 {
+			return nil, fmt.Errorf("unexpected request: %+v", request)
+		}
+		return []byte("seven"), nil
+	})
+
+	conn := dial(t, addr)
+	defer conn.Close()
+	send(t, conn, 7)
+	if reply, err := receive(conn, time.Second); err != nil || reply != "seven" {
+		t.Errorf("want the handler of job 7, got: %q %v", reply, err)
+	}
+	send(t, conn, 42)
+	if reply, err := receive(conn, time.Second); err != nil || reply != "42" {
+		t.Errorf("want the job ID replied by default, got: %q %v", reply, err)
+	}
+}
+
+func Test_Emulator_LatencyAndLoss(t *testing.T) {
+	nic, addr := startEmulator(t, Config{Latency: 50 * time.Millisecond})
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	started := time.Now()
+	send(t, conn, 1)
+	if _, err := receive(conn, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(started); took < 50*time.Millisecond {
+		t.Errorf("want the reply delayed by 50ms, took: %s", took)
+	}
+	nic.Close()
+
+	lossy, addr := startEmulator(t, Config{LossRate: 1})
+	defer lossy.Close()
+	conn = dial(t, addr)
+	defer conn.Close()
+	send(t, conn, 1)
+	if reply, err := receive(conn, 100*time.Millisecond); err == nil {
+		t.Errorf("want the request lost, got: %q", reply)
+	}
+}
+
+func Test_Emulator_Reorders(t *testing.T) {
+	nic, addr := startEmulator(t, Config{ReorderRate: 1, ReorderDelay: time.Second})
+	defer nic.Close()
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	send(t, conn, 1)
+	// Wait for the first reply to be held back.
+	time.Sleep(50 * time.Millisecond)
+	send(t, conn, 2)
+	first, _ := receive(conn, 500*time.Millisecond)
+	second, _ := receive(conn, 500*time.Millisecond)
+	if first != "2" || second != "1" {
+		t.Errorf("want the replies reordered, got: %q then %q", first, second)
+	}
+}
+
+func Test_Request_TraceID(t *testing.T) {
+	if _, ok := (Request{Data: []byte("                ")}).TraceID(); ok {
+		t.Errorf("want no trace ID in a blank data field")
+	}
+	data := bytes.Repeat([]byte{0xab}, 16)
+	traceID, ok := (Request{Data: data}).TraceID()
+	if !ok || !bytes.Equal(traceID[:], data) {
+		t.Errorf("want the trace ID of the data field, got: %s", traceID)
+	}
+}
+
+// Test_Emulator_ProxyInvocation sends an invocation through the proxy to the
+// emulator, along the path taken to a SmartNIC.
+func Test_Emulator_ProxyInvocation(t *testing.T) {
+	nic, addr := startEmulator(t, Config{})
+	defer nic.Close()
+	traced := make(chan tracing.TraceID, 1)
+	nic.Handle(42, func(request Request) ([]byte, error) {
+		traceID, _ := request.TraceID()
+		traced <- traceID
+		return []byte("emulated"), nil
+	})
+
+	tracing.SetExporter(tracing.NewMemoryExporter(16))
+	defer tracing.SetExporter(nil)
+
+	routingTable := handlers.NewRoutingTable(nil)
+	routingTable.Load([]string{"127.0.0.1"},
+		map[string]string{"127.0.0.1": handlers.SmartNICHealthy},
+		map[string]handlers.Placement{"echo-lambdanic": {"127.0.0.1": 1}},
+		map[string]types.FunctionMeta{})
+	proxy := handlers.MakeProxy("default", routingTable,
+		handlers.NewSmartNICRouter(handlers.RoutingRandom, nil), time.Second,
+		&handlers.ProxyConfig{NICTimeout: time.Second, SmartNICPort: addr.Port})
+
+	req := httptest.NewRequest(http.MethodPost, "/function/echo-lambdanic",
+		bytes.NewBufferString("42"))
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	req = mux.SetURLVars(req, map[string]string{"name": "echo-lambdanic"})
+	rr := httptest.NewRecorder()
+	proxy(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Body.String() != "emulated" {
+		t.Errorf("want the reply of the emulator, got: %d %q", rr.Code, rr.Body.String())
+	}
+	if traceID := <-traced; traceID.String() != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("want the trace ID sent to the emulator, got: %s", traceID)
+	}
+}
+
+// get returns the value of key or an empty string
+func get(etcd handlers.Store, key string) string {
+	resp, err := etcd.Get(context.Background(), key, nil)
+	if err != nil {
+		return ""
+	}
+	return resp.Node.Value
+}
+
+func Test_Emulator_Register(t *testing.T) {
+	nic, _ := startEmulator(t, Config{})
+	defer nic.Close()
+	etcd := handlers.NewMemoryStore()
+
+	if err := nic.Register(etcd, 8, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if get(etcd, "/smartnics/127.0.0.1") != "127.0.0.1" ||
+		get(etcd, handlers.CreateCapacityKey("127.0.0.1")) != "8" {
+		t.Errorf("want the emulator registered with its capacity, got: %q", get(etcd, "/smartnics/127.0.0.1"))
+	}
+	first := get(etcd, handlers.CreateHeartbeatKey("127.0.0.1"))
+	if _, err := time.Parse(time.RFC3339Nano, first); err != nil {
+		t.Fatalf("want a heartbeat, got: %q", first)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if next := get(etcd, handlers.CreateHeartbeatKey("127.0.0.1")); next == first {
+		t.Errorf("want the heartbeat written again")
+	}
+}
+
+func Test_Emulator_AcknowledgesDeployments(t *testing.T) {
+	nic, _ := startEmulator(t, Config{})
+	defer nic.Close()
+	etcd := handlers.NewMemoryStore()
+
+	if err := nic.Register(etcd, 0, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	etcd.Set(context.Background(), handlers.CreateDepKey("127.0.0.1", "echo-lambdanic"), "2", nil)
+	time.Sleep(50 * time.Millisecond)
+	if ack := get(etcd, handlers.CreateAckKey("127.0.0.1", "echo-lambdanic")); ack != "2" {
+		t.Errorf("want the replicas acknowledged, got: %q", ack)
+	}
+
+	etcd.Delete(context.Background(), handlers.CreateDepKey("127.0.0.1", "echo-lambdanic"), nil)
+	time.Sleep(50 * time.Millisecond)
+	if acks, _ := handlers.GetSmartNICAcks(etcd, "127.0.0.1"); len(acks) != 0 {
+		t.Errorf("want the acknowledgement dropped, got: %v", acks)
+	}
+}
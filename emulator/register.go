@@ -0,0 +1,106 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package emulator
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/handlers"
+	"github.com/Lambda-NIC/faas-netes/logging"
+	"go.etcd.io/etcd/client"
+)
+
+// Register adds the emulator to the SmartNICs in etcd with its replica
+// capacity, zero meaning none is set, and writes its heartbeat and
+// acknowledges the replicas deployed on it until it is closed. The registration is written again with every heartbeat as the
+// provider clears the SmartNICs when it starts. After Close the emulator
+// turns unhealthy once its last heartbeat is too old.
+func (e *Emulator) Register(keysAPI handlers.Store, capacity uint64,
+	interval time.Duration) error {
+	if err := e.register(keysAPI, capacity); err != nil {
+		return err
+	}
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := e.register(keysAPI, capacity); err != nil {
+					logging.Warn("Could not write heartbeat",
+						"smartnic", e.config.Address, "error", err)
+				}
+			case <-e.closed:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (e *Emulator) register(keysAPI handlers.Store, capacity uint64) error {
+	address := e.config.Address
+	ctx := context.Background()
+	if _, err := keysAPI.Set(ctx, "/smartnics/"+address, address, nil); err != nil {
+		return err
+	}
+	// The deployments of the SmartNIC are kept when it already has some.
+	_, err := keysAPI.Set(ctx, "/deployments/smartnic/"+address, "",
+		&client.SetOptions{Dir: true, PrevExist: client.PrevNoExist})
+	if err != nil && !isNodeExist(err) {
+		return err
+	}
+	if capacity > 0 {
+		_, err = keysAPI.Set(ctx, handlers.CreateCapacityKey(address),
+			strconv.FormatUint(capacity, 10), nil)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = keysAPI.Set(ctx, handlers.CreateHeartbeatKey(address),
+		time.Now().UTC().Format(time.RFC3339Nano), nil)
+	if err != nil {
+		return err
+	}
+	return e.acknowledge(keysAPI)
+}
+
+// acknowledge writes the replicas of every function deployed on the
+// emulator as loaded, and drops the acknowledgements of those removed
+func (e *Emulator) acknowledge(keysAPI handlers.Store) error {
+	address := e.config.Address
+	ctx := context.Background()
+	deployments, err := handlers.GetSmartNICDeployments(keysAPI, address)
+	if err != nil {
+		return err
+	}
+	acks, err := handlers.GetSmartNICAcks(keysAPI, address)
+	if err != nil {
+		return err
+	}
+	for funcName, numDeps := range deployments {
+		if ack, ok := acks[funcName]; ok && ack == numDeps {
+			continue
+		}
+		_, err = keysAPI.Set(ctx, handlers.CreateAckKey(address, funcName),
+			strconv.FormatUint(numDeps, 10), nil)
+		if err != nil {
+			return err
+		}
+	}
+	for funcName := range acks {
+		if _, ok := deployments[funcName]; !ok {
+			_, _ = keysAPI.Delete(ctx, handlers.CreateAckKey(address, funcName), nil)
+		}
+	}
+	return nil
+}
+
+func isNodeExist(err error) bool {
+	etcdErr, ok := err.(client.Error)
+	return ok && etcdErr.Code == client.ErrorCodeNodeExist
+}
@@ -0,0 +1,195 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Lambda-NIC/faas-netes/logging"
+	"github.com/Lambda-NIC/faas/gateway/requests"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+// Alertmanager posts the alerts of a receiver to /system/alert. Every
+// function named by the function_name label of an alert is scaled up by the
+// alert step while one of its alerts fires and down by the step once they
+// are all resolved, between its com.openfaas.scale.min and
+// com.openfaas.scale.max labels. Container functions are scaled through
+// their Deployment and SmartNIC functions are placed again by
+// UpdateReplicas.
+
+// MakeAlertHandler scales the functions named by the Alertmanager alerts
+// posted to it by step replicas
+func MakeAlertHandler(functionNamespace string, keysAPI Store,
+	clientset kubernetes.Interface, step uint64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keysAPI := traceKeysAPI(keysAPI, r.Context())
+		logger := logging.FromContext(r.Context())
+
+		alert := requests.PrometheusAlert{}
+		if r.Body != nil {
+			defer r.Body.Close()
+			bytesIn, _ := ioutil.ReadAll(r.Body)
+			if err := json.Unmarshal(bytesIn, &alert); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				msg := "Cannot parse alert. Please pass valid JSON."
+				w.Write([]byte(msg))
+				logger.Warn(msg, "error", err)
+				return
+			}
+		}
+
+		var failed []string
+		statuses := alertStatuses(alert)
+		for _, functionName := range sortedKeys(statuses) {
+			firing := statuses[functionName]
+			err := scaleFromAlert(r.Context(), functionNamespace, keysAPI, clientset,
+				functionName, firing, step)
+			if err != nil {
+				logger.Error("Could not scale function from alert",
+					"function_name", functionName, "firing", firing, "error", err)
+				failed = append(failed, fmt.Sprintf("%s: %s", functionName, err))
+			}
+		}
+		if len(failed) > 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(strings.Join(failed, "\n")))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// alertStatuses maps the functions named by the alerts to whether one of
+// their alerts fires. Alerts which name no function are ignored.
+func alertStatuses(alert requests.PrometheusAlert) map[string]bool {
+	statuses := map[string]bool{}
+	for _, inner := range alert.Alerts {
+		functionName := inner.Labels.FunctionName
+		if len(functionName) == 0 {
+			continue
+		}
+		status := inner.Status
+		if len(status) == 0 {
+			status = alert.Status
+		}
+		switch status {
+		case alertFiring:
+			statuses[functionName] = true
+		case alertResolved:
+			if _, ok := statuses[functionName]; !ok {
+				statuses[functionName] = false
+			}
+		}
+	}
+	return statuses
+}
+
+func sortedKeys(statuses map[string]bool) []string {
+	keys := make([]string, 0, len(statuses))
+	for key := range statuses {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// alertReplicas returns the replicas a function is scaled to by an alert.
+// Firing alerts only scale functions up and resolved ones only down, so
+// that a function scaled to zero or beyond its bounds is left alone.
+func alertReplicas(current uint64, firing bool, step uint64,
+	minReplicas uint64, maxReplicas uint64) uint64 {
+	if firing {
+		desired := clampReplicas(current+step, minReplicas, maxReplicas)
+		if desired < current {
+			return current
+		}
+		return desired
+	}
+	desired := minReplicas
+	if current > minReplicas+step {
+		desired = current - step
+	}
+	if desired > current {
+		return current
+	}
+	return desired
+}
+
+// scaleFromAlert scales a function by step replicas, functions which do
+// not exist are skipped
+func scaleFromAlert(ctx context.Context, functionNamespace string,
+	keysAPI Store, clientset kubernetes.Interface, functionName string,
+	firing bool, step uint64) error {
+	logger := logging.FromContext(ctx).With("function_name", functionName)
+
+	if isSmartNICFunction(functionName) {
+		if !EtcdFunctionExists(keysAPI, functionName) {
+			logger.Warn("Alert for a function which does not exist")
+			return nil
+		}
+		current, err := GetNumDeployments(keysAPI, functionName)
+		if err != nil {
+			return err
+		}
+		labels := map[string]string{}
+		if meta, metaErr := EtcdGetFunctionMeta(keysAPI, functionName); metaErr == nil {
+			labels = meta.Labels
+		}
+		minReplicas, maxReplicas := scaleBounds(functionName, labels, defaultScaleMax)
+		desired := alertReplicas(current, firing, step, minReplicas, maxReplicas)
+		if desired == current {
+			return nil
+		}
+		if err = UpdateReplicas(keysAPI, desired, functionName); err != nil {
+			return err
+		}
+		observeAlertScale(logger, functionName, current, desired)
+		return nil
+	}
+
+	deployment, legacy, err := getDeployment(clientset, functionNamespace, functionName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			logger.Warn("Alert for a function which does not exist")
+			return nil
+		}
+		return err
+	}
+	current := uint64(replicaCount(deployment))
+	minReplicas, maxReplicas := scaleBounds(functionName, deployment.Labels,
+		defaultScaleMax)
+	desired := alertReplicas(current, firing, step, minReplicas, maxReplicas)
+	if desired == current {
+		return nil
+	}
+	replicas := int32(desired)
+	deployment.Spec.Replicas = &replicas
+	if err = updateDeployment(clientset, functionNamespace, deployment, legacy); err != nil {
+		return err
+	}
+	observeAlertScale(logger, functionName, current, desired)
+	return nil
+}
+
+func observeAlertScale(logger *logging.Logger, functionName string,
+	current uint64, desired uint64) {
+	direction := scaleUp
+	if desired < current {
+		direction = scaleDown
+	}
+	scaleEvents.WithLabelValues(functionName, direction).Inc()
+	logger.Info("Scaled function from alert", "replicas", desired, "previous", current)
+}
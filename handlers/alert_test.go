@@ -0,0 +1,51 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Lambda-NIC/faas/gateway/requests"
+)
+
+func Test_alertStatuses(t *testing.T) {
+	alert := requests.PrometheusAlert{
+		Status: alertFiring,
+		Alerts: []requests.PrometheusInnerAlert{
+			{Status: alertResolved, Labels: requests.PrometheusInnerAlertLabel{FunctionName: "echo"}},
+			{Status: alertFiring, Labels: requests.PrometheusInnerAlertLabel{FunctionName: "echo"}},
+			{Status: alertResolved, Labels: requests.PrometheusInnerAlertLabel{FunctionName: "echo"}},
+			{Status: alertResolved, Labels: requests.PrometheusInnerAlertLabel{FunctionName: "echo-lambdanic"}},
+			{Labels: requests.PrometheusInnerAlertLabel{FunctionName: "inherited"}},
+			{Status: "pending", Labels: requests.PrometheusInnerAlertLabel{FunctionName: "pending"}},
+			{Status: alertFiring, Labels: requests.PrometheusInnerAlertLabel{AlertName: "APIHighInvocationRate"}},
+		},
+	}
+	want := map[string]bool{"echo": true, "echo-lambdanic": false, "inherited": true}
+	if got := alertStatuses(alert); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got: %v", want, got)
+	}
+}
+
+func Test_alertReplicas(t *testing.T) {
+	tests := []struct {
+		name    string
+		current uint64
+		firing  bool
+		want    uint64
+	}{
+		{name: "firing scales up by the step", current: 2, firing: true, want: 4},
+		{name: "firing stops at the maximum", current: 5, firing: true, want: 6},
+		{name: "firing leaves replicas beyond the maximum", current: 8, firing: true, want: 8},
+		{name: "firing scales up from zero", current: 0, firing: true, want: 2},
+		{name: "resolved scales down by the step", current: 6, want: 4},
+		{name: "resolved stops at the minimum", current: 2, want: 1},
+		{name: "resolved leaves functions scaled to zero", current: 0, want: 0},
+	}
+	for _, test := range tests {
+		if got := alertReplicas(test.current, test.firing, 2, 1, 6); got != test.want {
+			t.Errorf("%s: want %d replicas, got: %d", test.name, test.want, got)
+		}
+	}
+}
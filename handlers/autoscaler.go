@@ -0,0 +1,316 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/logging"
+)
+
+const (
+	// scaleMaxLabel is the most replicas a SmartNIC function is scaled to
+	scaleMaxLabel = "com.openfaas.scale.max"
+	// autoscaleTolerance is how far the latency of a function may be off
+	// its target before its replicas are changed
+	autoscaleTolerance = 0.1
+)
+
+// NICAutoscalerConfig specify the targets and pace of the SmartNIC
+// autoscaler
+type NICAutoscalerConfig struct {
+	// TargetRPS is the invocations per second each replica should serve,
+	// zero ignores the request rate
+	TargetRPS float64
+	// TargetLatency is the latency percentile invocations should stay
+	// under, zero ignores the latency
+	TargetLatency time.Duration
+	// LatencyPercentile is the percentile compared to TargetLatency
+	LatencyPercentile float64
+	// MaxReplicas bounds the replicas of functions without a
+	// com.openfaas.scale.max label
+	MaxReplicas uint64
+	// ScaleUpCooldown and ScaleDownCooldown are how long a function is
+	// left alone after it was scaled before it is scaled up or down again
+	ScaleUpCooldown   time.Duration
+	ScaleDownCooldown time.Duration
+	// ScaleUpWindow and ScaleDownWindow are how far back recommendations
+	// are kept. A function is scaled up to the lowest and down to the
+	// highest recommendation of the window so that bursts and lulls shorter
+	// than the window do not scale it back and forth.
+	ScaleUpWindow   time.Duration
+	ScaleDownWindow time.Duration
+}
+
+// NICAutoscaler scales SmartNIC and bare-metal functions between their
+// com.openfaas.scale.min and com.openfaas.scale.max labels. The replicas
+// needed are recommended from the invocation rate counted by every provider
+// replica and from the latency percentile seen by the router of this one,
+// and are placed by UpdateReplicas.
+type NICAutoscaler struct {
+	keysAPI Store
+	router  *SmartNICRouter
+	counter *InvocationCounter
+	config  NICAutoscalerConfig
+	now     func() time.Time
+
+	mutex  sync.Mutex
+	states map[string]*autoscaleState
+}
+
+// autoscaleState is what the autoscaler remembers of a function between
+// runs
+type autoscaleState struct {
+	count           float64
+	countedAt       time.Time
+	scaledAt        time.Time
+	recommendations []recommendation
+}
+
+// recommendation is the number of replicas a function needed at a time
+type recommendation struct {
+	at       time.Time
+	replicas uint64
+}
+
+// NewNICAutoscaler creates an autoscaler of the functions in etcd
+func NewNICAutoscaler(keysAPI Store, router *SmartNICRouter,
+	counter *InvocationCounter, config NICAutoscalerConfig) *NICAutoscaler {
+	if config.LatencyPercentile <= 0 || config.LatencyPercentile > 100 {
+		config.LatencyPercentile = defaultHedgePercentile
+	}
+	return &NICAutoscaler{
+		keysAPI: keysAPI,
+		router:  router,
+		counter: counter,
+		config:  config,
+		now:     time.Now,
+		states:  map[string]*autoscaleState{},
+	}
+}
+
+// SetClock replaces the clock of the autoscaler, the rates and windows are
+// measured with it.
+func (a *NICAutoscaler) SetClock(now func() time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.now = now
+}
+
+// Run scales the functions at every interval. This function is blocking.
+func (a *NICAutoscaler) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := a.Autoscale(); err != nil {
+			logging.Warn("Could not autoscale SmartNIC functions", "error", err)
+		}
+	}
+}
+
+// Autoscale scales every SmartNIC function to the replicas recommended for
+// it. The rate of a function is only known from its second run on.
+func (a *NICAutoscaler) Autoscale() error {
+	functions, err := GetFunctions(a.keysAPI)
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	seen := map[string]bool{}
+	var lastErr error
+	for _, functionName := range functions {
+		seen[functionName] = true
+		if err = a.autoscale(functionName); err != nil {
+			logging.Error("Could not autoscale function",
+				"function_name", functionName, "error", err)
+			lastErr = err
+		}
+	}
+	for functionName := range a.states {
+		if !seen[functionName] {
+			delete(a.states, functionName)
+		}
+	}
+	return lastErr
+}
+
+func (a *NICAutoscaler) autoscale(functionName string) error {
+	now := a.now()
+	count := a.counter.Count(functionName)
+	state, ok := a.states[functionName]
+	if !ok {
+		a.states[functionName] = &autoscaleState{count: count, countedAt: now}
+		return nil
+	}
+	elapsed := now.Sub(state.countedAt).Seconds()
+	if elapsed <= 0 {
+		return nil
+	}
+	// The count drops when the shards of other provider replicas cannot be
+	// read or were deleted. It is taken as the new baseline and the rate is
+	// measured again from the next run.
+	if count < state.count {
+		logging.Warn("Invocation count went down, skipping autoscale",
+			"function_name", functionName, "count", count, "previous", state.count)
+		state.count, state.countedAt = count, now
+		return nil
+	}
+	rate := (count - state.count) / elapsed
+	state.count, state.countedAt = count, now
+
+	current, err := GetNumDeployments(a.keysAPI, functionName)
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{}
+	if meta, metaErr := EtcdGetFunctionMeta(a.keysAPI, functionName); metaErr == nil {
+		labels = meta.Labels
+	}
+	minReplicas, maxReplicas := a.bounds(functionName, labels)
+
+	desired := clampReplicas(a.recommend(functionName, current, rate),
+		minReplicas, maxReplicas)
+	state.recommendations = append(state.recommendations,
+		recommendation{at: now, replicas: desired})
+	desired = a.stabilize(state, current, desired, now)
+	if desired == current {
+		return nil
+	}
+
+	direction, cooldown := scaleUp, a.config.ScaleUpCooldown
+	if desired < current {
+		direction, cooldown = scaleDown, a.config.ScaleDownCooldown
+	}
+	if !state.scaledAt.IsZero() && now.Sub(state.scaledAt) < cooldown {
+		return nil
+	}
+	if err = UpdateReplicas(a.keysAPI, desired, functionName); err != nil {
+		return err
+	}
+	state.scaledAt = now
+	scaleEvents.WithLabelValues(functionName, direction).Inc()
+	logging.Info("Autoscaled SmartNIC function", "function_name", functionName,
+		"replicas", desired, "previous", current, "rate", rate)
+	return nil
+}
+
+// recommend returns the replicas a function needs to serve its rate and to
+// meet its latency target, the most of the two. The current replicas are
+// kept when neither target is set or known.
+func (a *NICAutoscaler) recommend(functionName string, current uint64,
+	rate float64) uint64 {
+	recommended, known := uint64(0), false
+	if rate < 0 {
+		rate = 0
+	}
+	if a.config.TargetRPS > 0 {
+		recommended = uint64(math.Ceil(rate / a.config.TargetRPS))
+		known = true
+	}
+	// The latencies of a function are not aged, so they only count while it
+	// is invoked.
+	if a.config.TargetLatency > 0 && current > 0 && rate > 0 {
+		latency, ok := a.router.latencyPercentile(functionName,
+			a.config.LatencyPercentile)
+		if ok {
+			ratio := latency.Seconds() / a.config.TargetLatency.Seconds()
+			byLatency := current
+			if math.Abs(ratio-1) > autoscaleTolerance {
+				byLatency = uint64(math.Ceil(float64(current) * ratio))
+			}
+			if byLatency > recommended {
+				recommended = byLatency
+			}
+			known = true
+		}
+	}
+	if !known {
+		return current
+	}
+	return recommended
+}
+
+// stabilize drops the recommendations older than the longest window and
+// returns the replicas to scale to: up to the lowest recommendation of the
+// scale up window or down to the highest of the scale down window.
+func (a *NICAutoscaler) stabilize(state *autoscaleState, current uint64,
+	desired uint64, now time.Time) uint64 {
+	keep := a.config.ScaleUpWindow
+	if a.config.ScaleDownWindow > keep {
+		keep = a.config.ScaleDownWindow
+	}
+	kept := state.recommendations[:0]
+	for _, r := range state.recommendations {
+		if now.Sub(r.at) <= keep {
+			kept = append(kept, r)
+		}
+	}
+	state.recommendations = kept
+
+	switch {
+	case desired > current:
+		for _, r := range kept {
+			if now.Sub(r.at) <= a.config.ScaleUpWindow && r.replicas < desired {
+				desired = r.replicas
+			}
+		}
+		if desired < current {
+			desired = current
+		}
+	case desired < current:
+		for _, r := range kept {
+			if now.Sub(r.at) <= a.config.ScaleDownWindow && r.replicas > desired {
+				desired = r.replicas
+			}
+		}
+		if desired > current {
+			desired = current
+		}
+	}
+	return desired
+}
+
+// bounds returns the replicas a function is scaled between, from its labels
+// or one and the configured maximum
+func (a *NICAutoscaler) bounds(functionName string,
+	labels map[string]string) (uint64, uint64) {
+	return scaleBounds(functionName, labels, a.config.MaxReplicas)
+}
+
+// scaleBounds returns the replicas a function is scaled between, from its
+// com.openfaas.scale.min and com.openfaas.scale.max labels or one and
+// maxReplicas
+func scaleBounds(functionName string, labels map[string]string,
+	maxReplicas uint64) (uint64, uint64) {
+	minReplicas := uint64(1)
+	if value := getMinReplicaCount(labels); value != nil {
+		minReplicas = uint64(*value)
+	}
+	if value, ok := labels[scaleMaxLabel]; ok {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err == nil && parsed > 0 {
+			maxReplicas = parsed
+		} else {
+			logging.Warn("Invalid maximum replica count", "function_name", functionName,
+				"value", value, "error", err)
+		}
+	}
+	if maxReplicas < minReplicas {
+		maxReplicas = minReplicas
+	}
+	return minReplicas, maxReplicas
+}
+
+func clampReplicas(replicas uint64, minReplicas uint64, maxReplicas uint64) uint64 {
+	if replicas < minReplicas {
+		return minReplicas
+	}
+	if replicas > maxReplicas {
+		return maxReplicas
+	}
+	return replicas
+}
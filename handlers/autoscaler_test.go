@@ -0,0 +1,174 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/types"
+)
+
+// newAutoscaled creates a function on two SmartNICs and an autoscaler of it
+// running on a clock advanced by the returned function
+func newAutoscaled(t *testing.T, functionName string, replicas uint64,
+	labels map[string]string, config NICAutoscalerConfig) (*MemoryStore, *NICAutoscaler, func(time.Duration)) {
+	keysAPI := NewMemoryStore()
+	keysAPI.values["/smartnics/10.0.0.1"] = "10.0.0.1"
+	keysAPI.values["/smartnics/10.0.0.2"] = "10.0.0.2"
+	keysAPI.values[CreateFuncKey(functionName)] = "1"
+	if err := UpdateReplicas(keysAPI, replicas, functionName); err != nil {
+		t.Fatal(err)
+	}
+	if err := EtcdSetFunctionMeta(keysAPI, functionName, types.FunctionMeta{Labels: labels}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(0, 0)
+	autoscaler := NewNICAutoscaler(keysAPI, NewSmartNICRouter(RoutingRandom, nil),
+		NewInvocationCounter(keysAPI, "self"), config)
+	autoscaler.SetClock(func() time.Time { return now })
+	if err := autoscaler.Autoscale(); err != nil {
+		t.Fatal(err)
+	}
+	return keysAPI, autoscaler, func(d time.Duration) { now = now.Add(d) }
+}
+
+func invoke(functionName string, invocations int) {
+	for i := 0; i < invocations; i++ {
+		observeInvocation(functionName, backendSmartNIC, "10.0.0.1", time.Now(), false)
+	}
+}
+
+func autoscaleTo(t *testing.T, keysAPI Store, autoscaler *NICAutoscaler,
+	functionName string, want uint64) {
+	if err := autoscaler.Autoscale(); err != nil {
+		t.Fatal(err)
+	}
+	if replicas, _ := GetNumDeployments(keysAPI, functionName); replicas != want {
+		t.Errorf("want %d replicas, got: %d", want, replicas)
+	}
+}
+
+func Test_NICAutoscaler_FollowsRate(t *testing.T) {
+	name := "rate-lambdanic"
+	keysAPI, autoscaler, advance := newAutoscaled(t, name, 1, nil,
+		NICAutoscalerConfig{TargetRPS: 10, MaxReplicas: 20})
+
+	invoke(name, 50)
+	advance(time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 5)
+	placement, _ := GetFunctionPlacement(keysAPI, name)
+	if placement["10.0.0.1"] != 3 || placement["10.0.0.2"] != 2 {
+		t.Errorf("want the replicas spread, got: %v", placement)
+	}
+
+	advance(time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 1)
+}
+
+func Test_NICAutoscaler_SkipsWhenCountGoesDown(t *testing.T) {
+	name := "reset-lambdanic"
+	keysAPI, autoscaler, advance := newAutoscaled(t, name, 2, nil,
+		NICAutoscalerConfig{TargetRPS: 10, MaxReplicas: 20})
+
+	// The shards of another provider replica were counted before and cannot
+	// be read anymore.
+	autoscaler.states[name].count = 1000
+	advance(time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 2)
+	if count := autoscaler.states[name].count; count != 0 {
+		t.Errorf("want the count taken as the new baseline, got: %v", count)
+	}
+
+	invoke(name, 30)
+	advance(time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 3)
+
+	if replicas := autoscaler.recommend(name, 2, -1.5); replicas != 0 {
+		t.Errorf("want no replicas recommended for a negative rate, got: %d", replicas)
+	}
+}
+
+func Test_NICAutoscaler_BoundedByLabels(t *testing.T) {
+	name := "bounded-lambdanic"
+	keysAPI, autoscaler, advance := newAutoscaled(t, name, 2,
+		map[string]string{"com.openfaas.scale.min": "2", "com.openfaas.scale.max": "3"},
+		NICAutoscalerConfig{TargetRPS: 10, MaxReplicas: 20})
+
+	invoke(name, 100)
+	advance(time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 3)
+
+	advance(time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 2)
+}
+
+func Test_NICAutoscaler_Cooldown(t *testing.T) {
+	name := "cooldown-lambdanic"
+	keysAPI, autoscaler, advance := newAutoscaled(t, name, 1, nil,
+		NICAutoscalerConfig{TargetRPS: 10, MaxReplicas: 20,
+			ScaleUpCooldown: 30 * time.Second})
+
+	invoke(name, 20)
+	advance(time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 2)
+
+	invoke(name, 40)
+	advance(time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 2)
+
+	invoke(name, 1200)
+	advance(30 * time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 4)
+}
+
+func Test_NICAutoscaler_StabilizesScaleDown(t *testing.T) {
+	name := "stable-lambdanic"
+	keysAPI, autoscaler, advance := newAutoscaled(t, name, 1, nil,
+		NICAutoscalerConfig{TargetRPS: 10, MaxReplicas: 20,
+			ScaleDownWindow: time.Minute})
+
+	invoke(name, 50)
+	advance(time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 5)
+
+	// A lull shorter than the window keeps the replicas.
+	advance(30 * time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 5)
+
+	invoke(name, 600)
+	advance(30 * time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 5)
+
+	advance(31 * time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 2)
+
+	advance(time.Minute)
+	autoscaleTo(t, keysAPI, autoscaler, name, 1)
+}
+
+func Test_NICAutoscaler_FollowsLatency(t *testing.T) {
+	name := "latency-lambdanic"
+	keysAPI, autoscaler, advance := newAutoscaled(t, name, 2, nil,
+		NICAutoscalerConfig{TargetLatency: 10 * time.Millisecond, MaxReplicas: 20})
+
+	invoke(name, 1)
+	autoscaler.router.mutex.Lock()
+	for i := 0; i < minLatencySamples; i++ {
+		autoscaler.router.recordLatency(name, 30*time.Millisecond)
+	}
+	autoscaler.router.mutex.Unlock()
+	advance(time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 6)
+
+	// Latencies within the tolerance of the target keep the replicas.
+	autoscaler.router.mutex.Lock()
+	for i := 0; i < len(latencySamples{}.values); i++ {
+		autoscaler.router.recordLatency(name, 10500*time.Microsecond)
+	}
+	autoscaler.router.mutex.Unlock()
+	invoke(name, 1)
+	advance(time.Second)
+	autoscaleTo(t, keysAPI, autoscaler, name, 6)
+}
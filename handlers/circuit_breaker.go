@@ -0,0 +1,192 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/logging"
+)
+
+const (
+	// CircuitClosed lets invocations through to the SmartNIC
+	CircuitClosed = "closed"
+	// CircuitOpen fails invocations to the SmartNIC fast
+	CircuitOpen = "open"
+	// CircuitHalfOpen lets probe invocations through to find out whether
+	// the SmartNIC recovered
+	CircuitHalfOpen = "half-open"
+)
+
+// circuitStates are all states of a circuit breaker, in the order used by
+// the metrics
+var circuitStates = []string{CircuitClosed, CircuitOpen, CircuitHalfOpen}
+
+// breakerWindow is how many recent invocations the error rate is taken over
+const breakerWindow = 20
+
+// CircuitBreakerConfig specify when the circuit breaker of a SmartNIC opens
+type CircuitBreakerConfig struct {
+	// ConsecutiveFailures opens the circuit after that many failures in a
+	// row, zero disables it
+	ConsecutiveFailures int
+	// ErrorRate opens the circuit when the share of failures among the
+	// recent invocations exceeds it, zero disables it
+	ErrorRate float64
+	// OpenDuration is how long the circuit stays open before probing
+	OpenDuration time.Duration
+	// HalfOpenProbes is how many probe invocations may be outstanding while
+	// the circuit is half-open
+	HalfOpenProbes int
+}
+
+// circuitBreaker tracks the failures of the invocations sent to a SmartNIC
+type circuitBreaker struct {
+	smartNIC string
+	config   *CircuitBreakerConfig
+
+	state               string
+	consecutiveFailures int
+	outcomes            [breakerWindow]bool
+	next                int
+	count               int
+	openedAt            time.Time
+	probes              int
+}
+
+func newCircuitBreaker(smartNIC string, config *CircuitBreakerConfig) *circuitBreaker {
+	b := &circuitBreaker{smartNIC: smartNIC, config: config}
+	b.setState(CircuitClosed)
+	return b
+}
+
+// available returns true when an invocation may be sent to the SmartNIC,
+// which is the case of an open circuit once it has been open for long
+// enough. It does not change the circuit, selected does.
+func (b *circuitBreaker) available(now time.Time) bool {
+	switch b.state {
+	case CircuitOpen:
+		return now.Sub(b.openedAt) >= b.config.OpenDuration
+	case CircuitHalfOpen:
+		return b.probes < b.halfOpenProbes()
+	}
+	return true
+}
+
+// selected must be called when an invocation is sent to the SmartNIC, it
+// returns true when the invocation is a probe of a half-open circuit. An
+// open circuit turns half-open when the invocation is its first probe.
+func (b *circuitBreaker) selected(now time.Time) bool {
+	if b.state == CircuitOpen && now.Sub(b.openedAt) >= b.config.OpenDuration {
+		b.setState(CircuitHalfOpen)
+		b.probes = 0
+	}
+	if b.state == CircuitHalfOpen {
+		b.probes++
+		return true
+	}
+	return false
+}
+
+// cancelled must be called when an invocation was abandoned before the
+// SmartNIC replied
+func (b *circuitBreaker) cancelled(probe bool) {
+	if probe && b.state == CircuitHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// record updates the circuit with the outcome of an invocation. While the
+// circuit is half-open only the outcomes of probes count, invocations sent
+// before the circuit opened say nothing about whether the SmartNIC
+// recovered.
+func (b *circuitBreaker) record(failed bool, probe bool, now time.Time) {
+	if b.state == CircuitHalfOpen {
+		if !probe {
+			return
+		}
+		b.cancelled(probe)
+		if failed {
+			b.open(now)
+		} else {
+			b.reset()
+		}
+		return
+	}
+
+	b.outcomes[b.next] = failed
+	b.next = (b.next + 1) % breakerWindow
+	if b.count < breakerWindow {
+		b.count++
+	}
+	if !failed {
+		b.consecutiveFailures = 0
+		return
+	}
+	b.consecutiveFailures++
+
+	if b.config.ConsecutiveFailures > 0 &&
+		b.consecutiveFailures >= b.config.ConsecutiveFailures {
+		b.open(now)
+		return
+	}
+	if b.config.ErrorRate > 0 && b.count == breakerWindow &&
+		b.errorRate() > b.config.ErrorRate {
+		b.open(now)
+	}
+}
+
+func (b *circuitBreaker) errorRate() float64 {
+	failures := 0
+	for i := 0; i < b.count; i++ {
+		if b.outcomes[i] {
+			failures++
+		}
+	}
+	return float64(failures) / float64(b.count)
+}
+
+func (b *circuitBreaker) halfOpenProbes() int {
+	if b.config.HalfOpenProbes > 0 {
+		return b.config.HalfOpenProbes
+	}
+	return 1
+}
+
+func (b *circuitBreaker) open(now time.Time) {
+	b.setState(CircuitOpen)
+	b.openedAt = now
+	b.probes = 0
+}
+
+func (b *circuitBreaker) reset() {
+	b.setState(CircuitClosed)
+	b.consecutiveFailures = 0
+	b.outcomes = [breakerWindow]bool{}
+	b.next = 0
+	b.count = 0
+}
+
+// stateAt returns the state of the circuit, reporting an open circuit which
+// would let a probe through as half-open
+func (b *circuitBreaker) stateAt(now time.Time) string {
+	if b.state == CircuitOpen && now.Sub(b.openedAt) >= b.config.OpenDuration {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+func (b *circuitBreaker) setState(state string) {
+	if b.state != state && len(b.state) > 0 {
+		logging.Warn("SmartNIC circuit changed", "smartnic", b.smartNIC,
+			"state", state)
+	}
+	b.state = state
+	for _, s := range circuitStates {
+		value := 0.0
+		if s == state {
+			value = 1.0
+		}
+		circuitState.WithLabelValues(b.smartNIC, s).Set(value)
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/types"
+)
+
+func Test_circuitBreaker_OpensAfterConsecutiveFailures(t *testing.T) {
+	now := time.Now()
+	breaker := newCircuitBreaker("10.0.0.1", &CircuitBreakerConfig{
+		ConsecutiveFailures: 3,
+		OpenDuration:        time.Second,
+	})
+
+	breaker.record(true, false, now)
+	breaker.record(true, false, now)
+	breaker.record(false, false, now)
+	breaker.record(true, false, now)
+	breaker.record(true, false, now)
+	if !breaker.available(now) {
+		t.Fatalf("want circuit closed after a success reset the failures, got: %s", breaker.state)
+	}
+
+	breaker.record(true, false, now)
+	if breaker.available(now) || breaker.state != CircuitOpen {
+		t.Errorf("want circuit open after 3 failures in a row, got: %s", breaker.state)
+	}
+}
+
+func Test_circuitBreaker_OpensOnErrorRate(t *testing.T) {
+	now := time.Now()
+	breaker := newCircuitBreaker("10.0.0.1", &CircuitBreakerConfig{
+		ErrorRate:    0.5,
+		OpenDuration: time.Second,
+	})
+
+	for i := 0; i < breakerWindow-1; i++ {
+		breaker.record(i%3 != 0, false, now)
+	}
+	if breaker.state != CircuitClosed {
+		t.Fatalf("want circuit closed until the window is full, got: %s", breaker.state)
+	}
+
+	breaker.record(true, false, now)
+	if breaker.state != CircuitOpen {
+		t.Errorf("want circuit open once the error rate exceeds 0.5, got: %s", breaker.state)
+	}
+}
+
+func Test_circuitBreaker_HalfOpenProbe(t *testing.T) {
+	now := time.Now()
+	breaker := newCircuitBreaker("10.0.0.1", &CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenDuration:        time.Second,
+	})
+	breaker.record(true, false, now)
+
+	now = now.Add(time.Second)
+	if !breaker.available(now) || breaker.state != CircuitOpen {
+		t.Fatalf("want a probe let through after the open duration, got: %s", breaker.state)
+	}
+	if !breaker.selected(now) || breaker.state != CircuitHalfOpen {
+		t.Fatalf("want the circuit half-open with the invocation as its probe, got: %s", breaker.state)
+	}
+	if breaker.available(now) {
+		t.Fatalf("want a single probe while half-open")
+	}
+
+	breaker.record(true, true, now)
+	if breaker.state != CircuitOpen {
+		t.Fatalf("want circuit open after a failed probe, got: %s", breaker.state)
+	}
+
+	now = now.Add(time.Second)
+	breaker.selected(now)
+	breaker.record(false, true, now)
+	if breaker.state != CircuitClosed {
+		t.Errorf("want circuit closed after a successful probe, got: %s", breaker.state)
+	}
+}
+
+func Test_circuitBreaker_IgnoresStaleOutcomesWhileHalfOpen(t *testing.T) {
+	now := time.Now()
+	breaker := newCircuitBreaker("10.0.0.1", &CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenDuration:        time.Second,
+	})
+	// An invocation is in flight when another one opens the circuit.
+	if breaker.selected(now) {
+		t.Fatalf("want no probe while closed")
+	}
+	breaker.record(true, false, now)
+
+	now = now.Add(time.Second)
+	probe := breaker.selected(now)
+	breaker.record(true, false, now)
+	if breaker.state != CircuitHalfOpen || breaker.available(now) {
+		t.Fatalf("want the old failure ignored and the probe outstanding, got: %s", breaker.state)
+	}
+	breaker.cancelled(false)
+	if breaker.available(now) {
+		t.Fatalf("want the probe outstanding after an old invocation was cancelled")
+	}
+
+	breaker.record(false, probe, now)
+	if breaker.state != CircuitClosed {
+		t.Errorf("want circuit closed after a successful probe, got: %s", breaker.state)
+	}
+}
+
+func Test_selectSmartNIC_SkipsOpenCircuit(t *testing.T) {
+	routingTable := newTestRoutingTable(map[string]string{
+		"10.0.0.1": SmartNICHealthy,
+		"10.0.0.2": SmartNICHealthy,
+	})
+	route := functionRoute{smartNICs: []string{"10.0.0.1", "10.0.0.2"}}
+	router := NewSmartNICRouter(RoutingRandom, &CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenDuration:        time.Minute,
+	})
+	router.inflight["10.0.0.1"]++
+	router.release("echo-lambdanic", "10.0.0.1", false, time.Second, errTest)
+
+	if state := router.circuitState("10.0.0.1"); state != CircuitOpen {
+		t.Fatalf("want circuit open, got: %s", state)
+	}
+	for i := 0; i < 10; i++ {
+		smartNIC, _, _ := router.selectSmartNIC("echo-lambdanic", route, routingTable, 0, false)
+		if smartNIC != "10.0.0.2" {
+			t.Fatalf("want reroute to 10.0.0.2, got: %s", smartNIC)
+		}
+		router.inflight[smartNIC]--
+	}
+}
+
+func Test_selectSmartNIC_HalfOpensOnlyTheSelectedSmartNIC(t *testing.T) {
+	routingTable := newTestRoutingTable(map[string]string{
+		"10.0.0.1": SmartNICHealthy,
+		"10.0.0.2": SmartNICHealthy,
+	})
+	route := functionRoute{smartNICs: []string{"10.0.0.1", "10.0.0.2"}}
+	now := time.Now()
+	router := NewSmartNICRouter(RoutingRandom, &CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenDuration:        time.Second,
+	})
+	router.SetClock(func() time.Time { return now })
+	router.inflight["10.0.0.1"]++
+	router.release("echo-lambdanic", "10.0.0.1", false, time.Second, errTest)
+	router.inflight["10.0.0.2"] = 1
+
+	// 10.0.0.1 is saturated when its open duration ends, so it is not
+	// selected and its circuit stays open.
+	now = now.Add(time.Second)
+	router.inflight["10.0.0.1"] = 1
+	smartNIC, reason, _ := router.selectSmartNIC("echo-lambdanic", route, routingTable, 1, true)
+	if len(smartNIC) > 0 || reason != overflowSaturated {
+		t.Fatalf("want overflow: %s, got: %s reason: %s", overflowSaturated, smartNIC, reason)
+	}
+	if state := router.breakerOf("10.0.0.1").state; state != CircuitOpen {
+		t.Errorf("want the circuit left open, got: %s", state)
+	}
+
+	router.inflight["10.0.0.1"] = 0
+	smartNIC, _, probe := router.selectSmartNIC("echo-lambdanic", route, routingTable, 1, true)
+	if smartNIC != "10.0.0.1" || !probe || router.breakerOf("10.0.0.1").state != CircuitHalfOpen {
+		t.Errorf("want a probe sent to the half-open circuit, got: %s probe: %v", smartNIC, probe)
+	}
+}
+
+func Test_selectSmartNIC_FailsFastWhenAllCircuitsOpen(t *testing.T) {
+	routingTable := newTestRoutingTable(map[string]string{
+		"10.0.0.1": SmartNICHealthy,
+	})
+	route := functionRoute{smartNICs: []string{"10.0.0.1"}}
+	hybridRoute := functionRoute{
+		smartNICs: []string{"10.0.0.1"},
+		meta: types.FunctionMeta{
+			Annotations: map[string]string{hybridAnnotation: "true"},
+		},
+	}
+	router := NewSmartNICRouter(RoutingRandom, &CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenDuration:        time.Minute,
+	})
+	router.inflight["10.0.0.1"]++
+	router.release("echo-lambdanic", "10.0.0.1", false, time.Second, errTest)
+
+	smartNIC, reason, _ := router.selectSmartNIC("echo-lambdanic", route, routingTable, 0, false)
+	if len(smartNIC) > 0 || reason != overflowCircuitOpen {
+		t.Errorf("want fail fast: %s, got: %s reason: %s", overflowCircuitOpen, smartNIC, reason)
+	}
+	smartNIC, reason, _ = router.selectSmartNIC("echo-lambdanic", hybridRoute, routingTable, 0, true)
+	if len(smartNIC) > 0 || reason != overflowCircuitOpen {
+		t.Errorf("want overflow: %s, got: %s reason: %s", overflowCircuitOpen, smartNIC, reason)
+	}
+}
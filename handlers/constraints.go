@@ -0,0 +1,406 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Lambda-NIC/faas-netes/logging"
+	"github.com/Lambda-NIC/faas/gateway/requests"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// tolerationPrefix marks the constraints which are tolerations rather
+	// than node label requirements
+	tolerationPrefix = "toleration:"
+	// tolerationsAnnotation lists tolerations of a function, separated by
+	// commas, in the syntax of toleration constraints
+	tolerationsAnnotation = "com.lambdanic.tolerations"
+	// spreadAnnotation asks for the replicas of a function to be spread
+	// across nodes, either required or preferred
+	spreadAnnotation = "com.lambdanic.spread"
+	spreadRequired   = "required"
+	spreadPreferred  = "preferred"
+	// spreadTopologyKey is the node label replicas are spread across
+	spreadTopologyKey = "kubernetes.io/hostname"
+	// constraintsAnnotation shows the constraints a container was deployed
+	// with, those of the node-pool policy first, as a JSON list
+	constraintsAnnotation = "com.lambdanic.constraints"
+)
+
+// podScheduling is where the pods of a function may be scheduled
+type podScheduling struct {
+	nodeSelector map[string]string
+	affinity     *apiv1.Affinity
+	tolerations  []apiv1.Toleration
+}
+
+// makeScheduling reads the scheduling of a function from its constraints
+// and annotations. An error is returned for those which are not valid.
+//
+// Constraints are Kubernetes label selector requirements on the labels of
+// nodes: key=value, key==value, key!=value, key in (a,b), key notin (a,b),
+// key, key exists, !key, key>1 and key<1. Equality is kept as a node
+// selector and every other requirement becomes a required node affinity
+// expression. Constraints starting with toleration: are tolerations, as
+// key=value:Effect, key:Effect, key=value or key.
+func makeScheduling(request requests.CreateFunctionRequest) (*podScheduling, error) {
+	logging.Debug("Creating pod scheduling",
+		"constraints", strings.Join(request.Constraints, ","))
+	scheduling := &podScheduling{nodeSelector: map[string]string{}}
+	var expressions []apiv1.NodeSelectorRequirement
+
+	for _, constraint := range request.Constraints {
+		constraint = strings.TrimSpace(constraint)
+		if len(constraint) == 0 {
+			continue
+		}
+		if strings.HasPrefix(constraint, tolerationPrefix) {
+			toleration, err := parseToleration(strings.TrimPrefix(constraint, tolerationPrefix))
+			if err != nil {
+				return nil, err
+			}
+			scheduling.tolerations = append(scheduling.tolerations, *toleration)
+			continue
+		}
+		requirements, err := parseConstraint(constraint)
+		if err != nil {
+			return nil, err
+		}
+		for _, requirement := range requirements {
+			values := requirement.Values().List()
+			switch requirement.Operator() {
+			case selection.Equals, selection.DoubleEquals:
+				scheduling.nodeSelector[requirement.Key()] = values[0]
+			default:
+				expressions = append(expressions, apiv1.NodeSelectorRequirement{
+					Key:      requirement.Key(),
+					Operator: nodeSelectorOperators[requirement.Operator()],
+					Values:   values,
+				})
+			}
+		}
+	}
+
+	var annotations map[string]string
+	if request.Annotations != nil {
+		annotations = *request.Annotations
+	}
+	if value := annotations[tolerationsAnnotation]; len(strings.TrimSpace(value)) > 0 {
+		for _, item := range strings.Split(value, ",") {
+			toleration, err := parseToleration(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s annotation: %s", tolerationsAnnotation, err)
+			}
+			scheduling.tolerations = append(scheduling.tolerations, *toleration)
+		}
+	}
+
+	antiAffinity, err := makeAntiAffinity(request.Service, annotations[spreadAnnotation])
+	if err != nil {
+		return nil, err
+	}
+	if len(expressions) > 0 || antiAffinity != nil {
+		scheduling.affinity = &apiv1.Affinity{PodAntiAffinity: antiAffinity}
+	}
+	if len(expressions) > 0 {
+		scheduling.affinity.NodeAffinity = &apiv1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &apiv1.NodeSelector{
+				NodeSelectorTerms: []apiv1.NodeSelectorTerm{
+					{MatchExpressions: expressions},
+				},
+			},
+		}
+	}
+	return scheduling, nil
+}
+
+// apply replaces the scheduling of a pod
+func (s *podScheduling) apply(spec *apiv1.PodSpec) {
+	spec.NodeSelector = s.nodeSelector
+	spec.Affinity = s.affinity
+	spec.Tolerations = s.tolerations
+}
+
+// nodeSelectorOperators maps the operators of label selectors to those of
+// node affinity, equality is kept out as a node selector
+var nodeSelectorOperators = map[selection.Operator]apiv1.NodeSelectorOperator{
+	selection.NotEquals:    apiv1.NodeSelectorOpNotIn,
+	selection.In:           apiv1.NodeSelectorOpIn,
+	selection.NotIn:        apiv1.NodeSelectorOpNotIn,
+	selection.Exists:       apiv1.NodeSelectorOpExists,
+	selection.DoesNotExist: apiv1.NodeSelectorOpDoesNotExist,
+	selection.GreaterThan:  apiv1.NodeSelectorOpGt,
+	selection.LessThan:     apiv1.NodeSelectorOpLt,
+}
+
+// parseConstraint parses a constraint as a label selector, key exists
+// being read as key
+func parseConstraint(constraint string) ([]labels.Requirement, error) {
+	selector := constraint
+	if fields := strings.Fields(constraint); len(fields) == 2 && fields[1] == "exists" {
+		selector = fields[0]
+	}
+	requirements, err := labels.ParseToRequirements(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid constraint %q: %s", constraint, err)
+	}
+	return requirements, nil
+}
+
+// parseToleration parses a toleration as key=value:Effect, key:Effect,
+// key=value or key. Tolerations without a value tolerate every value of
+// the taint and those without an effect every effect.
+func parseToleration(value string) (*apiv1.Toleration, error) {
+	value = strings.TrimSpace(value)
+	toleration := &apiv1.Toleration{Operator: apiv1.TolerationOpExists}
+	if i := strings.LastIndex(value, ":"); i >= 0 {
+		toleration.Effect = apiv1.TaintEffect(value[i+1:])
+		value = value[:i]
+		switch toleration.Effect {
+		case apiv1.TaintEffectNoSchedule, apiv1.TaintEffectPreferNoSchedule,
+			apiv1.TaintEffectNoExecute:
+		default:
+			return nil, fmt.Errorf("invalid toleration effect %q", toleration.Effect)
+		}
+	}
+	toleration.Key = value
+	if i := strings.Index(value, "="); i >= 0 {
+		toleration.Key, toleration.Value = value[:i], value[i+1:]
+		toleration.Operator = apiv1.TolerationOpEqual
+		if errs := validation.IsValidLabelValue(toleration.Value); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid toleration value %q: %s",
+				toleration.Value, strings.Join(errs, "; "))
+		}
+	}
+	if errs := validation.IsQualifiedName(toleration.Key); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid toleration key %q: %s",
+			toleration.Key, strings.Join(errs, "; "))
+	}
+	return toleration, nil
+}
+
+// makeAntiAffinity returns the pod anti-affinity spreading the replicas of
+// a function across nodes, or nil when it does not ask for it
+func makeAntiAffinity(functionName string, spread string) (*apiv1.PodAntiAffinity, error) {
+	term := apiv1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"faas_function": functionName},
+		},
+		TopologyKey: spreadTopologyKey,
+	}
+	switch spread {
+	case "":
+		return nil, nil
+	case spreadRequired:
+		return &apiv1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []apiv1.PodAffinityTerm{term},
+		}, nil
+	case spreadPreferred:
+		return &apiv1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []apiv1.WeightedPodAffinityTerm{
+				{Weight: 100, PodAffinityTerm: term},
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid %s annotation %q, want %s or %s",
+			spreadAnnotation, spread, spreadRequired, spreadPreferred)
+	}
+}
+
+// NodePoolPolicy decides the nodes the containers of each class of function
+// are scheduled on. Its constraints come before those of a function, which
+// may narrow down the nodes it allows but not leave none of them.
+type NodePoolPolicy struct {
+	// SmartNIC are the node constraints of the containers of SmartNIC and
+	// bare-metal functions, those of hybrid functions
+	SmartNIC []string
+	// Container are the node constraints of container functions
+	Container []string
+}
+
+// NewNodePoolPolicy creates a policy from the label selectors of both
+// classes of functions, empty selectors leaving them unconstrained
+func NewNodePoolPolicy(smartNIC string, container string) (*NodePoolPolicy, error) {
+	policy := &NodePoolPolicy{}
+	for _, class := range []struct {
+		selector    string
+		constraints *[]string
+	}{
+		{selector: smartNIC, constraints: &policy.SmartNIC},
+		{selector: container, constraints: &policy.Container},
+	} {
+		selector := strings.TrimSpace(class.selector)
+		if len(selector) == 0 {
+			continue
+		}
+		if _, err := parseConstraint(selector); err != nil {
+			return nil, err
+		}
+		*class.constraints = []string{selector}
+	}
+	return policy, nil
+}
+
+// constraints returns the policy constraints of a function, none when
+// there is no policy
+func (p *NodePoolPolicy) constraints(functionName string) []string {
+	if p == nil {
+		return nil
+	}
+	if isSmartNICFunction(functionName) {
+		return p.SmartNIC
+	}
+	return p.Container
+}
+
+// mergeConstraints returns the constraints of the policy followed by those
+// of a function. Constraints on node labels the policy constrains are
+// dropped when every node the policy allows satisfies them, kept when they
+// narrow the nodes down and rejected when no node the policy allows
+// satisfies them.
+func mergeConstraints(policy []string, constraints []string) ([]string, error) {
+	merged := append([]string{}, policy...)
+	keys := map[string][]labels.Requirement{}
+	for _, constraint := range policy {
+		requirements, err := parseConstraint(constraint)
+		if err != nil {
+			return nil, err
+		}
+		for _, requirement := range requirements {
+			keys[requirement.Key()] = append(keys[requirement.Key()], requirement)
+		}
+	}
+
+	for _, constraint := range constraints {
+		constraint = strings.TrimSpace(constraint)
+		if len(constraint) == 0 {
+			continue
+		}
+		if strings.HasPrefix(constraint, tolerationPrefix) {
+			merged = append(merged, constraint)
+			continue
+		}
+		requirements, err := parseConstraint(constraint)
+		if err != nil {
+			return nil, err
+		}
+		repeated := true
+		for _, requirement := range requirements {
+			required, ok := keys[requirement.Key()]
+			if ok && implies(required, requirement) {
+				continue
+			}
+			if ok && !intersects(required, requirement) {
+				return nil, fmt.Errorf("constraint %q conflicts with the node-pool policy %q, "+
+					"no node the policy allows satisfies it",
+					constraint, strings.Join(policy, ","))
+			}
+			repeated = false
+		}
+		if !repeated {
+			merged = append(merged, constraint)
+		}
+	}
+	return merged, nil
+}
+
+// implies returns true when every node the required requirements on a label
+// allow satisfies the requirement
+func implies(required []labels.Requirement, requirement labels.Requirement) bool {
+	allowed := false
+	for _, node := range candidateNodes(required, requirement) {
+		if !matchesAll(required, node) {
+			continue
+		}
+		if !requirement.Matches(node) {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+// intersects returns true when some node the required requirements on a
+// label allow satisfies the requirement
+func intersects(required []labels.Requirement, requirement labels.Requirement) bool {
+	for _, node := range candidateNodes(required, requirement) {
+		if matchesAll(required, node) && requirement.Matches(node) {
+			return true
+		}
+	}
+	return false
+}
+
+// candidateNodes returns the labels of nodes which cover every way the
+// requirements on a label can match: the label missing, each value they
+// list, the integers past their bounds and a value none of them lists.
+func candidateNodes(required []labels.Requirement,
+	requirement labels.Requirement) []labels.Set {
+	key := requirement.Key()
+	listed := map[string]bool{}
+	var bounds []int64
+	for _, r := range append([]labels.Requirement{requirement}, required...) {
+		for _, value := range r.Values().List() {
+			switch r.Operator() {
+			case selection.GreaterThan, selection.LessThan:
+				if bound, err := strconv.ParseInt(value, 10, 64); err == nil {
+					bounds = append(bounds, bound)
+				}
+			default:
+				listed[value] = true
+			}
+		}
+	}
+
+	nodes := []labels.Set{{}}
+	for value := range listed {
+		nodes = append(nodes, labels.Set{key: value})
+	}
+	// Every listed value may exclude one of the integers past a bound.
+	for _, bound := range bounds {
+		for offset := int64(1); offset <= int64(len(listed))+1; offset++ {
+			nodes = append(nodes,
+				labels.Set{key: strconv.FormatInt(bound+offset, 10)},
+				labels.Set{key: strconv.FormatInt(bound-offset, 10)})
+		}
+	}
+	other := "other"
+	for listed[other] {
+		other += "-other"
+	}
+	return append(nodes, labels.Set{key: other})
+}
+
+func matchesAll(requirements []labels.Requirement, node labels.Set) bool {
+	for _, r := range requirements {
+		if !r.Matches(node) {
+			return false
+		}
+	}
+	return true
+}
+
+// withConstraints returns a copy of the annotations of a container showing
+// the constraints it is deployed with
+func withConstraints(annotations map[string]string,
+	constraints []string) map[string]string {
+	copied := map[string]string{}
+	for k, v := range annotations {
+		copied[k] = v
+	}
+	delete(copied, constraintsAnnotation)
+	if len(constraints) > 0 {
+		value, _ := json.Marshal(constraints)
+		copied[constraintsAnnotation] = string(value)
+	}
+	return copied
+}
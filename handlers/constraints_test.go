@@ -0,0 +1,233 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Lambda-NIC/faas/gateway/requests"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+func Test_makeScheduling_Constraints(t *testing.T) {
+	request := requests.CreateFunctionRequest{
+		Service: "echo",
+		Constraints: []string{
+			"smartnic=enabled",
+			"zone == west",
+			"arch!=arm64",
+			"tier in (fast, gpu)",
+			"pool notin (spot)",
+			"nic.model exists",
+			"disk",
+			"!draining",
+			"cores>8",
+			"toleration:dedicated=nic:NoSchedule",
+			"toleration:maintenance",
+		},
+	}
+	scheduling, err := makeScheduling(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantSelector := map[string]string{"smartnic": "enabled", "zone": "west"}
+	if !reflect.DeepEqual(scheduling.nodeSelector, wantSelector) {
+		t.Errorf("want node selector %v, got: %v", wantSelector, scheduling.nodeSelector)
+	}
+	wantExpressions := []apiv1.NodeSelectorRequirement{
+		{Key: "arch", Operator: apiv1.NodeSelectorOpNotIn, Values: []string{"arm64"}},
+		{Key: "tier", Operator: apiv1.NodeSelectorOpIn, Values: []string{"fast", "gpu"}},
+		{Key: "pool", Operator: apiv1.NodeSelectorOpNotIn, Values: []string{"spot"}},
+		{Key: "nic.model", Operator: apiv1.NodeSelectorOpExists, Values: []string{}},
+		{Key: "disk", Operator: apiv1.NodeSelectorOpExists, Values: []string{}},
+		{Key: "draining", Operator: apiv1.NodeSelectorOpDoesNotExist, Values: []string{}},
+		{Key: "cores", Operator: apiv1.NodeSelectorOpGt, Values: []string{"8"}},
+	}
+	if scheduling.affinity == nil || scheduling.affinity.NodeAffinity == nil {
+		t.Fatalf("want a node affinity")
+	}
+	terms := scheduling.affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 1 || !reflect.DeepEqual(terms[0].MatchExpressions, wantExpressions) {
+		t.Errorf("want expressions %v, got: %v", wantExpressions, terms)
+	}
+	if scheduling.affinity.PodAntiAffinity != nil {
+		t.Errorf("want no anti-affinity, got: %+v", scheduling.affinity.PodAntiAffinity)
+	}
+	wantTolerations := []apiv1.Toleration{
+		{Key: "dedicated", Operator: apiv1.TolerationOpEqual, Value: "nic", Effect: apiv1.TaintEffectNoSchedule},
+		{Key: "maintenance", Operator: apiv1.TolerationOpExists},
+	}
+	if !reflect.DeepEqual(scheduling.tolerations, wantTolerations) {
+		t.Errorf("want tolerations %v, got: %v", wantTolerations, scheduling.tolerations)
+	}
+}
+
+func Test_makeScheduling_Annotations(t *testing.T) {
+	request := requests.CreateFunctionRequest{
+		Service:     "echo",
+		Constraints: []string{"smartnic=enabled"},
+		Annotations: &map[string]string{
+			"com.lambdanic.tolerations": "gpu:NoExecute, dedicated=nic",
+			"com.lambdanic.spread":      "preferred",
+		},
+	}
+	scheduling, err := makeScheduling(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTolerations := []apiv1.Toleration{
+		{Key: "gpu", Operator: apiv1.TolerationOpExists, Effect: apiv1.TaintEffectNoExecute},
+		{Key: "dedicated", Operator: apiv1.TolerationOpEqual, Value: "nic"},
+	}
+	if !reflect.DeepEqual(scheduling.tolerations, wantTolerations) {
+		t.Errorf("want tolerations %v, got: %v", wantTolerations, scheduling.tolerations)
+	}
+	if scheduling.affinity == nil || scheduling.affinity.NodeAffinity != nil {
+		t.Fatalf("want only a pod anti-affinity, got: %+v", scheduling.affinity)
+	}
+	preferred := scheduling.affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	if len(preferred) != 1 || preferred[0].PodAffinityTerm.TopologyKey != "kubernetes.io/hostname" ||
+		preferred[0].PodAffinityTerm.LabelSelector.MatchLabels["faas_function"] != "echo" {
+		t.Errorf("want replicas preferably spread across nodes, got: %+v", preferred)
+	}
+
+	(*request.Annotations)["com.lambdanic.spread"] = "required"
+	if scheduling, err = makeScheduling(request); err != nil {
+		t.Fatal(err)
+	}
+	if required := scheduling.affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution; len(required) != 1 {
+		t.Errorf("want replicas spread across nodes, got: %+v", required)
+	}
+
+	scheduling, err = makeScheduling(requests.CreateFunctionRequest{Service: "echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scheduling.affinity != nil || scheduling.tolerations != nil || len(scheduling.nodeSelector) != 0 {
+		t.Errorf("want no scheduling, got: %+v", scheduling)
+	}
+}
+
+func Test_makeScheduling_Invalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		constraints []string
+		annotations map[string]string
+	}{
+		{name: "unbalanced set", constraints: []string{"tier in (fast"}},
+		{name: "invalid key", constraints: []string{"not a key=value"}},
+		{name: "non-numeric comparison", constraints: []string{"cores>many"}},
+		{name: "invalid toleration effect", constraints: []string{"toleration:dedicated=nic:Never"}},
+		{name: "invalid toleration key", constraints: []string{"toleration:=nic"}},
+		{name: "invalid toleration annotation",
+			annotations: map[string]string{"com.lambdanic.tolerations": "gpu,:NoSchedule"}},
+		{name: "invalid spread", annotations: map[string]string{"com.lambdanic.spread": "always"}},
+	}
+	for _, test := range tests {
+		request := requests.CreateFunctionRequest{
+			Service:     "echo",
+			Constraints: test.constraints,
+			Annotations: &test.annotations,
+		}
+		if _, err := makeScheduling(request); err == nil {
+			t.Errorf("%s: want an error", test.name)
+		}
+	}
+}
+
+func Test_mergeConstraints(t *testing.T) {
+	policy := []string{"smartnic=enabled,pool in (nic, edge)"}
+	tests := []struct {
+		name        string
+		constraints []string
+		want        []string
+		wantErr     bool
+	}{
+		{name: "no constraints", want: policy},
+		{name: "own constraints follow the policy", constraints: []string{"zone=west", "toleration:gpu"},
+			want: []string{policy[0], "zone=west", "toleration:gpu"}},
+		{name: "repeated requirements are dropped", constraints: []string{"smartnic=enabled", "pool in (edge,nic)"},
+			want: policy},
+		{name: "repeated requirements are kept with others", constraints: []string{"smartnic=enabled,zone=west"},
+			want: []string{policy[0], "smartnic=enabled,zone=west"}},
+		{name: "requirements every allowed value satisfies are dropped",
+			constraints: []string{"smartnic", "smartnic exists", "smartnic in (enabled)", "smartnic!=disabled",
+				"smartnic==enabled", "pool notin (core)", "pool in (nic,edge,core)"},
+			want: policy},
+		{name: "other values are rejected", constraints: []string{"smartnic=disabled"}, wantErr: true},
+		{name: "missing labels are rejected", constraints: []string{"!smartnic"}, wantErr: true},
+		{name: "requirements narrowing the allowed values are kept",
+			constraints: []string{"pool=nic", "pool notin (nic)", "smartnic=enabled,pool in (edge,core)"},
+			want:        []string{policy[0], "pool=nic", "pool notin (nic)", "smartnic=enabled,pool in (edge,core)"}},
+		{name: "requirements no allowed value satisfies are rejected",
+			constraints: []string{"pool in (core,cloud)"}, wantErr: true},
+		{name: "invalid constraints are rejected", constraints: []string{"pool in (nic"}, wantErr: true},
+	}
+	for _, test := range tests {
+		merged, err := mergeConstraints(policy, test.constraints)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: want an error, got: %v", test.name, merged)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(merged, test.want) {
+			t.Errorf("%s: want %v, got: %v", test.name, test.want, merged)
+		}
+	}
+
+	for _, test := range []struct {
+		policy     string
+		constraint string
+		wantErr    bool
+	}{
+		{policy: "smartnic", constraint: "smartnic exists"},
+		{policy: "smartnic", constraint: "smartnic=enabled"},
+		{policy: "smartnic", constraint: "!smartnic", wantErr: true},
+		{policy: "gpu>1", constraint: "gpu"},
+		{policy: "gpu>1", constraint: "gpu>2"},
+		{policy: "gpu>1", constraint: "gpu in (1,2)"},
+		{policy: "gpu>1", constraint: "gpu<2", wantErr: true},
+		{policy: "gpu>1", constraint: "gpu=many", wantErr: true},
+		{policy: "gpu>1,gpu notin (2,3)", constraint: "gpu<5"},
+		{policy: "!smartnic", constraint: "smartnic notin (enabled)"},
+		{policy: "!smartnic", constraint: "smartnic", wantErr: true},
+		{policy: "pool in (nic,edge),pool!=edge", constraint: "pool=nic"},
+	} {
+		_, err := mergeConstraints([]string{test.policy}, []string{test.constraint})
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s under %s: want error %v, got: %v", test.constraint, test.policy, test.wantErr, err)
+		}
+	}
+
+	merged, err := mergeConstraints(nil, []string{"zone=west"})
+	if err != nil || !reflect.DeepEqual(merged, []string{"zone=west"}) {
+		t.Errorf("want the constraints of the function without a policy, got: %v %v", merged, err)
+	}
+}
+
+func Test_NewNodePoolPolicy(t *testing.T) {
+	policy, err := NewNodePoolPolicy("smartnic=enabled", " ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := policy.constraints("echo-lambdanic"); !reflect.DeepEqual(got, []string{"smartnic=enabled"}) {
+		t.Errorf("want the SmartNIC node pool, got: %v", got)
+	}
+	if got := policy.constraints("echo"); got != nil {
+		t.Errorf("want container functions unconstrained, got: %v", got)
+	}
+	if got := (*NodePoolPolicy)(nil).constraints("echo-lambdanic"); got != nil {
+		t.Errorf("want no constraints without a policy, got: %v", got)
+	}
+
+	if _, err = NewNodePoolPolicy("pool in (nic", ""); err == nil {
+		t.Errorf("want an error for an invalid selector")
+	}
+}
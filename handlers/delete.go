@@ -6,13 +6,12 @@ package handlers
 import (
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"strings"
+	"time"
 
+	"github.com/Lambda-NIC/faas-netes/logging"
 	"github.com/Lambda-NIC/faas/gateway/requests"
-	"go.etcd.io/etcd/client"
-	v1beta1 "k8s.io/api/extensions/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -20,9 +19,12 @@ import (
 
 // MakeDeleteHandler delete a function
 func MakeDeleteHandler(functionNamespace string,
-	keysAPI client.KeysAPI,
-	clientset *kubernetes.Clientset) http.HandlerFunc {
+	keysAPI Store,
+	clientset kubernetes.Interface,
+	counter *InvocationCounter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		keysAPI := traceKeysAPI(keysAPI, r.Context())
+		logger := logging.FromContext(r.Context())
 		defer r.Body.Close()
 
 		body, _ := ioutil.ReadAll(r.Body)
@@ -39,32 +41,40 @@ func MakeDeleteHandler(functionNamespace string,
 		}
 
 		// LambdaNIC: Delete scheme for lambdanic
-		if strings.Contains(request.FunctionName, "lambdanic") ||
-			strings.Contains(request.FunctionName, "baremetal") {
-			log.Printf("Got request to delete: %s", request.FunctionName)
+		if isSmartNICFunction(request.FunctionName) {
+			logger.Info("Deleting SmartNIC function",
+				"function_name", request.FunctionName)
 			// Check if this service exists
 			if !EtcdFunctionExists(keysAPI, request.FunctionName) {
 				w.WriteHeader(http.StatusBadRequest)
 				w.Write([]byte("Error Deleting Function:" + request.FunctionName))
-				log.Println("Error: " + err.Error())
+				logger.Warn("Function not found",
+					"function_name", request.FunctionName)
 				return
 			}
+			hybrid := false
+			if meta, metaErr := EtcdGetFunctionMeta(keysAPI, request.FunctionName); metaErr == nil {
+				hybrid = isHybridFunction(meta.Annotations)
+			}
 			// Delete the deployments and the function
 			err = EtcdFunctionDelete(keysAPI, request.FunctionName)
 			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				w.Write([]byte("Error Deleting Function:" + request.FunctionName))
-				log.Println("Error: " + err.Error())
+				logger.Error("Could not delete SmartNIC function",
+					"function_name", request.FunctionName, "error", err)
 				return
 			}
-			log.Println("Deleted SmartNIC service - " + request.FunctionName)
+			logger.Info("Deleted SmartNIC function",
+				"function_name", request.FunctionName)
+			// LambdaNIC: Delete the container of a hybrid function.
+			if hybrid {
+				deleteFunction(functionNamespace, clientset, request, w)
+			}
 		} else {
-			getOpts := metav1.GetOptions{}
-
 			// This makes sure we don't delete non-labelled deployments
-			deployment, findDeployErr := clientset.ExtensionsV1beta1().
-				Deployments(functionNamespace).
-				Get(request.FunctionName, getOpts)
+			deployment, _, findDeployErr := getDeployment(clientset,
+				functionNamespace, request.FunctionName)
 
 			if findDeployErr != nil {
 				if errors.IsNotFound(findDeployErr) {
@@ -87,11 +97,16 @@ func MakeDeleteHandler(functionNamespace string,
 			}
 		}
 
+		if err = counter.Delete(request.FunctionName); err != nil {
+			logger.Warn("Could not delete invocation counts",
+				"function_name", request.FunctionName, "error", err)
+		}
+
 		w.WriteHeader(http.StatusAccepted)
 	}
 }
 
-func isFunction(deployment *v1beta1.Deployment) bool {
+func isFunction(deployment *appsv1.Deployment) bool {
 	if deployment != nil {
 		if _, found := deployment.Labels["faas_function"]; found {
 			return true
@@ -100,36 +115,48 @@ func isFunction(deployment *v1beta1.Deployment) bool {
 	return false
 }
 
-func deleteFunction(functionNamespace string, clientset *kubernetes.Clientset,
+func deleteFunction(functionNamespace string, clientset kubernetes.Interface,
 	request requests.DeleteFunctionRequest, w http.ResponseWriter) {
+	if status, err := deleteContainer(functionNamespace, clientset,
+		request.FunctionName); err != nil {
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+// deleteContainer deletes the Deployment, Service and autoscaler of a
+// container function
+func deleteContainer(functionNamespace string, clientset kubernetes.Interface,
+	functionName string) (httpStatus int, err error) {
 
 	foregroundPolicy := metav1.DeletePropagationForeground
 	opts := &metav1.DeleteOptions{PropagationPolicy: &foregroundPolicy}
 
-	if deployErr := clientset.ExtensionsV1beta1().
-		Deployments(functionNamespace).
-		Delete(request.FunctionName, opts); deployErr != nil {
+	deployErr := deleteDeployment(clientset, functionNamespace,
+		functionName, opts)
+	if deployErr != nil {
 
 		if errors.IsNotFound(deployErr) {
-			w.WriteHeader(http.StatusNotFound)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
+			return http.StatusNotFound, deployErr
 		}
-		w.Write([]byte(deployErr.Error()))
-		return
+		return http.StatusInternalServerError, deployErr
 	}
 
-	if svcErr := clientset.CoreV1().
+	started := time.Now()
+	svcErr := clientset.CoreV1().
 		Services(functionNamespace).
-		Delete(request.FunctionName, opts); svcErr != nil {
+		Delete(functionName, opts)
+	observeKubernetesCall("delete_service", started, svcErr)
+	if svcErr != nil {
 
 		if errors.IsNotFound(svcErr) {
-			w.WriteHeader(http.StatusNotFound)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
+			return http.StatusNotFound, svcErr
 		}
+		return http.StatusInternalServerError, svcErr
+	}
 
-		w.Write([]byte(svcErr.Error()))
-		return
+	if hpaErr := deleteHPA(clientset, functionNamespace, functionName); hpaErr != nil {
+		return http.StatusInternalServerError, hpaErr
 	}
+	return http.StatusAccepted, nil
 }
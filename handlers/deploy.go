@@ -7,19 +7,21 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Lambda-NIC/faas-netes/logging"
+	"github.com/Lambda-NIC/faas-netes/types"
 	"github.com/Lambda-NIC/faas/gateway/requests"
-	"go.etcd.io/etcd/client"
+	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
 	corev1 "k8s.io/api/core/v1"
-	v1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -59,38 +61,62 @@ type DeployHandlerConfig struct {
 	FunctionReadinessProbeConfig *FunctionProbeConfig
 	FunctionLivenessProbeConfig  *FunctionProbeConfig
 	ImagePullPolicy              string
+	// NodePools decides the nodes containers are scheduled on, nil leaves
+	// them to their own constraints
+	NodePools *NodePoolPolicy
+	// WaitTimeout is how long a deploy asked to wait with wait=true waits
+	// for the function to be ready
+	WaitTimeout time.Duration
+	// WriteTimeout is the write timeout of the server, deploys stop waiting
+	// before it so that their status is written. Zero does not bound waits.
+	WriteTimeout time.Duration
 }
 
 // MakeDeployHandler creates a handler to create new functions in the cluster
 func MakeDeployHandler(functionNamespace string,
-	keysAPI client.KeysAPI,
-	clientset *kubernetes.Clientset,
+	keysAPI Store,
+	clientset kubernetes.Interface,
 	config *DeployHandlerConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		keysAPI := traceKeysAPI(keysAPI, r.Context())
+		logger := logging.FromContext(r.Context())
 		defer r.Body.Close()
 
 		body, _ := ioutil.ReadAll(r.Body)
 
 		request := requests.CreateFunctionRequest{}
 
-		// Make sure the deployment only occurs at nodes with smartnics
-		request.Constraints = append(request.Constraints, "smartnic=enabled")
-
 		err := json.Unmarshal(body, &request)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
+		// LambdaNIC: Deploys may wait for the function to be ready.
+		wait, err := parseWait(r.URL.Query().Get("wait"), config.WaitTimeout)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		wait = boundByWriteTimeout(wait, config.WriteTimeout)
+
 		if err = ValidateDeployRequest(&request); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
 			return
 		}
 
+		logger.Debug("Deploying function",
+			"service", request.Service,
+			"image", request.Image,
+			"constraints", strings.Join(request.Constraints, ","),
+			"envVars", request.EnvVars,
+			"secrets", request.Secrets)
+
 		// LambdaNIC: Deployment scheme for lambdanic
-		if strings.Contains(request.Service, "lambdanic") ||
-			strings.Contains(request.Service, "baremetal") {
+		container := true
+		if isSmartNICFunction(request.Service) {
 			// Check if this service already exists
 			if EtcdFunctionExists(keysAPI, request.Service) {
 				w.WriteHeader(http.StatusBadRequest)
@@ -104,56 +130,165 @@ func MakeDeployHandler(functionNamespace string,
 				w.Write([]byte("Error Creating Service:" + request.Service))
 				return
 			}
-		} else {
-			existingSecrets, err :=
-				getSecrets(clientset, functionNamespace, request.Secrets)
+			meta := makeFunctionMeta(request)
+			err = EtcdSetFunctionMeta(keysAPI, request.Service, meta)
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
+				logger.Error("Could not store function metadata",
+					"function_name", request.Service, "error", err)
+				EtcdFunctionDelete(keysAPI, request.Service)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Error Creating Service:" + request.Service))
+				return
+			}
+			// LambdaNIC: Hybrid functions also get a container for overflow.
+			container = isHybridFunction(meta.Annotations)
+			if container {
+				if status, err := createFunction(functionNamespace, clientset,
+					request, config, logger); err != nil {
+					EtcdFunctionDelete(keysAPI, request.Service)
+					w.WriteHeader(status)
+					w.Write([]byte(err.Error()))
+					return
+				}
+			}
+		} else {
+			if status, err := createFunction(functionNamespace, clientset,
+				request, config, logger); err != nil {
+				w.WriteHeader(status)
 				w.Write([]byte(err.Error()))
 				return
 			}
+		}
+		if wait <= 0 {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
 
-			deploymentSpec, specErr :=
-				makeDeploymentSpec(request, existingSecrets, config)
+		status := waitForFunction(r.Context(), functionNamespace, keysAPI,
+			clientset, request.Service, container,
+			isSmartNICFunction(request.Service), wait)
+		if !status.Ready {
+			logger.Warn("Function not ready", "function_name", request.Service,
+				"reason", status.Reason, "message", status.Message)
+		}
+		statusBytes, _ := json.Marshal(status)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status.statusCode())
+		w.Write(statusBytes)
+	}
+}
 
-			if specErr != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte(specErr.Error()))
-				return
-			}
+// createFunction creates the Deployment and Service of a container function
+func createFunction(functionNamespace string,
+	clientset kubernetes.Interface,
+	request requests.CreateFunctionRequest,
+	config *DeployHandlerConfig,
+	logger *logging.Logger) (httpStatus int, err error) {
+	existingSecrets, err :=
+		getSecrets(clientset, functionNamespace, request.Secrets)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
 
-			deploy := clientset.Extensions().Deployments(functionNamespace)
+	deploymentSpec, specErr :=
+		makeDeploymentSpec(request, existingSecrets, config)
 
-			_, err = deploy.Create(deploymentSpec)
-			if err != nil {
-				log.Println(err)
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(err.Error()))
-				return
-			}
+	if specErr != nil {
+		return http.StatusBadRequest, specErr
+	}
 
-			log.Println("Created deployment - " + request.Service)
+	hpa, hpaErr := makeHPASpec(request.Service, deploymentSpec.Labels, false)
+	if hpaErr != nil {
+		return http.StatusBadRequest, hpaErr
+	}
 
-			service := clientset.Core().Services(functionNamespace)
-			serviceSpec := makeServiceSpec(request)
-			_, err = service.Create(serviceSpec)
+	deploy := clientset.AppsV1().Deployments(functionNamespace)
 
-			if err != nil {
-				log.Println(err)
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(err.Error()))
-				return
-			}
-			log.Println("Created service - " + request.Service)
-			log.Println(string(body))
+	started := time.Now()
+	_, err = deploy.Create(deploymentSpec)
+	observeKubernetesCall("create_deployment", started, err)
+	if err != nil {
+		logger.Error("Could not create deployment",
+			"function_name", request.Service, "error", err)
+		return http.StatusInternalServerError, err
+	}
+
+	logger.Info("Created deployment", "function_name", request.Service)
+
+	service := clientset.Core().Services(functionNamespace)
+	serviceSpec := makeServiceSpec(request)
+	started = time.Now()
+	_, err = service.Create(serviceSpec)
+	observeKubernetesCall("create_service", started, err)
+
+	if err != nil {
+		logger.Error("Could not create service",
+			"function_name", request.Service, "error", err)
+		rollbackFunction(functionNamespace, clientset, request.Service, false, logger)
+		return http.StatusInternalServerError, err
+	}
+	logger.Info("Created service", "function_name", request.Service)
+
+	if err = syncHPA(clientset, functionNamespace, request.Service, hpa); err != nil {
+		logger.Error("Could not create autoscaler",
+			"function_name", request.Service, "error", err)
+		rollbackFunction(functionNamespace, clientset, request.Service, true, logger)
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusAccepted, nil
+}
+
+// rollbackFunction deletes what createFunction created before it failed, so
+// that the function can be deployed again. The Service is only deleted when
+// it was created, one which already existed is left alone.
+func rollbackFunction(functionNamespace string, clientset kubernetes.Interface,
+	functionName string, service bool, logger *logging.Logger) {
+	logger = logger.With("function_name", functionName)
+	if err := deleteHPA(clientset, functionNamespace, functionName); err != nil {
+		logger.Error("Could not roll back autoscaler", "error", err)
+	}
+	if service {
+		started := time.Now()
+		err := clientset.CoreV1().Services(functionNamespace).
+			Delete(functionName, &metav1.DeleteOptions{})
+		observeKubernetesCall("delete_service", started, err)
+		if err != nil && !errors.IsNotFound(err) {
+			logger.Error("Could not roll back service", "error", err)
+		}
+	}
+	foregroundPolicy := metav1.DeletePropagationForeground
+	opts := &metav1.DeleteOptions{PropagationPolicy: &foregroundPolicy}
+	err := deleteDeployment(clientset, functionNamespace, functionName, opts)
+	if err != nil && !errors.IsNotFound(err) {
+		logger.Error("Could not roll back deployment", "error", err)
+		return
+	}
+	logger.Info("Rolled back function")
+}
+
+// makeFunctionMeta copies the labels and annotations of a SmartNIC function
+// so they can be kept in etcd
+func makeFunctionMeta(request requests.CreateFunctionRequest) types.FunctionMeta {
+	meta := types.FunctionMeta{
+		Labels:      map[string]string{},
+		Annotations: map[string]string{},
+	}
+	if request.Labels != nil {
+		for k, v := range *request.Labels {
+			meta.Labels[k] = v
 		}
-		w.WriteHeader(http.StatusAccepted)
 	}
+	if request.Annotations != nil {
+		for k, v := range *request.Annotations {
+			meta.Annotations[k] = v
+		}
+	}
+	return meta
 }
 
 func makeDeploymentSpec(request requests.CreateFunctionRequest,
 	existingSecrets map[string]*apiv1.Secret,
-	config *DeployHandlerConfig) (*v1beta1.Deployment, error) {
+	config *DeployHandlerConfig) (*appsv1.Deployment, error) {
 	envVars := buildEnvVars(&request)
 	var handler apiv1.Handler
 
@@ -206,7 +341,16 @@ func makeDeploymentSpec(request requests.CreateFunctionRequest,
 		}
 	}
 
-	nodeSelector := createSelector(request.Constraints)
+	constraints, constraintsErr := mergeConstraints(
+		config.NodePools.constraints(request.Service), request.Constraints)
+	if constraintsErr != nil {
+		return nil, constraintsErr
+	}
+	request.Constraints = constraints
+	scheduling, schedulingErr := makeScheduling(request)
+	if schedulingErr != nil {
+		return nil, schedulingErr
+	}
 
 	resources, resourceErr := createResources(request)
 
@@ -224,26 +368,27 @@ func makeDeploymentSpec(request requests.CreateFunctionRequest,
 		imagePullPolicy = apiv1.PullAlways
 	}
 
-	annotations := buildAnnotations(request)
-	deploymentSpec := &v1beta1.Deployment{
+	annotations := withConstraints(buildAnnotations(request), constraints)
+	deploymentSpec := &appsv1.Deployment{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Deployment",
-			APIVersion: "extensions/v1beta1",
+			APIVersion: "apps/v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        request.Service,
+			Labels:      labels,
 			Annotations: annotations,
 		},
-		Spec: v1beta1.DeploymentSpec{
+		Spec: appsv1.DeploymentSpec{
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
 					"faas_function": request.Service,
 				},
 			},
 			Replicas: initialReplicas,
-			Strategy: v1beta1.DeploymentStrategy{
-				Type: v1beta1.RollingUpdateDeploymentStrategyType,
-				RollingUpdate: &v1beta1.RollingUpdateDeployment{
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{
 					MaxUnavailable: &intstr.IntOrString{
 						Type:   intstr.Int,
 						IntVal: int32(0),
@@ -262,7 +407,9 @@ func makeDeploymentSpec(request requests.CreateFunctionRequest,
 					Annotations: annotations,
 				},
 				Spec: apiv1.PodSpec{
-					NodeSelector: nodeSelector,
+					NodeSelector: scheduling.nodeSelector,
+					Affinity:     scheduling.affinity,
+					Tolerations:  scheduling.tolerations,
 					Containers: []apiv1.Container{
 						{
 							Name:  request.Service,
@@ -305,6 +452,7 @@ func makeServiceSpec(request requests.CreateFunctionRequest) *corev1.Service {
 		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        request.Service,
+			Labels:      map[string]string{functionSelector: request.Service},
 			Annotations: buildAnnotations(request),
 		},
 		Spec: corev1.ServiceSpec{
@@ -365,24 +513,6 @@ func int32p(i int32) *int32 {
 	return &i
 }
 
-func createSelector(constraints []string) map[string]string {
-	selector := make(map[string]string)
-
-	log.Println(constraints)
-	if len(constraints) > 0 {
-		for _, constraint := range constraints {
-			parts := strings.Split(constraint, "=")
-
-			if len(parts) == 2 {
-				selector[parts[0]] = parts[1]
-			}
-		}
-	}
-
-	// log.Println("selector: ", selector)
-	return selector
-}
-
 func createResources(request requests.CreateFunctionRequest) (*apiv1.ResourceRequirements, error) {
 	resources := &apiv1.ResourceRequirements{
 		Limits:   apiv1.ResourceList{},
@@ -433,7 +563,8 @@ func getMinReplicaCount(labels map[string]string) *int32 {
 			return int32p(int32(minReplicas))
 		}
 
-		log.Println(err)
+		logging.Warn("Invalid minimum replica count",
+			"value", value, "error", err)
 	}
 
 	return nil
@@ -447,7 +578,7 @@ func getMinReplicaCount(labels map[string]string) *int32 {
 //    to false and there will be no mount for the `/tmp` folder
 //
 // This method is safe for both create and update operations.
-func configureReadOnlyRootFilesystem(request requests.CreateFunctionRequest, deployment *v1beta1.Deployment) {
+func configureReadOnlyRootFilesystem(request requests.CreateFunctionRequest, deployment *appsv1.Deployment) {
 	if deployment.Spec.Template.Spec.Containers[0].SecurityContext != nil {
 		deployment.Spec.Template.Spec.Containers[0].SecurityContext.ReadOnlyRootFilesystem = &request.ReadOnlyRootFilesystem
 	} else {
@@ -0,0 +1,169 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"encoding/json"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Function deployments are created as apps/v1 Deployments. Those created
+// before the migration are only served by extensions/v1beta1 on clusters
+// which do not serve them as apps/v1 too, so every lookup which does not
+// find an apps/v1 Deployment falls back to extensions/v1beta1. Legacy
+// deployments are handled as apps/v1 Deployments and written back through
+// the API they were read from.
+
+// getDeployment returns the Deployment of a function and whether it is only
+// served by extensions/v1beta1
+func getDeployment(clientset kubernetes.Interface, namespace string,
+	name string) (*appsv1.Deployment, bool, error) {
+	started := time.Now()
+	deployment, err := clientset.AppsV1().Deployments(namespace).
+		Get(name, metav1.GetOptions{})
+	observeKubernetesCall("get_deployment", started, err)
+	if err == nil || !errors.IsNotFound(err) {
+		return deployment, false, err
+	}
+
+	started = time.Now()
+	legacy, legacyErr := clientset.ExtensionsV1beta1().Deployments(namespace).
+		Get(name, metav1.GetOptions{})
+	observeKubernetesCall("get_legacy_deployment", started, legacyErr)
+	if legacyErr != nil {
+		// The apps/v1 error is kept, as extensions/v1beta1 may not be served.
+		return nil, false, err
+	}
+	deployment, err = fromLegacyDeployment(legacy)
+	return deployment, true, err
+}
+
+// listDeployments lists the Deployments of functions, those only served
+// by extensions/v1beta1 included
+func listDeployments(clientset kubernetes.Interface, namespace string,
+	opts metav1.ListOptions) ([]appsv1.Deployment, error) {
+	started := time.Now()
+	res, err := clientset.AppsV1().Deployments(namespace).List(opts)
+	observeKubernetesCall("list_deployments", started, err)
+	if err != nil {
+		return nil, err
+	}
+	deployments := res.Items
+
+	started = time.Now()
+	legacy, legacyErr := clientset.ExtensionsV1beta1().Deployments(namespace).List(opts)
+	observeKubernetesCall("list_legacy_deployments", started, legacyErr)
+	if legacyErr != nil {
+		if errors.IsNotFound(legacyErr) {
+			return deployments, nil
+		}
+		return nil, legacyErr
+	}
+	listed := map[string]bool{}
+	for _, deployment := range deployments {
+		listed[deployment.Name] = true
+	}
+	for i := range legacy.Items {
+		if listed[legacy.Items[i].Name] {
+			continue
+		}
+		deployment, convertErr := fromLegacyDeployment(&legacy.Items[i])
+		if convertErr != nil {
+			return nil, convertErr
+		}
+		deployments = append(deployments, *deployment)
+	}
+	return deployments, nil
+}
+
+// updateDeployment writes a Deployment back through the API it was read
+// from
+func updateDeployment(clientset kubernetes.Interface, namespace string,
+	deployment *appsv1.Deployment, legacy bool) error {
+	if !legacy {
+		started := time.Now()
+		_, err := clientset.AppsV1().Deployments(namespace).Update(deployment)
+		observeKubernetesCall("update_deployment", started, err)
+		return err
+	}
+
+	legacyDeployment, err := toLegacyDeployment(deployment)
+	if err != nil {
+		return err
+	}
+	started := time.Now()
+	_, err = clientset.ExtensionsV1beta1().Deployments(namespace).Update(legacyDeployment)
+	observeKubernetesCall("update_legacy_deployment", started, err)
+	return err
+}
+
+// deleteDeployment deletes the Deployment of a function, from
+// extensions/v1beta1 when it is not an apps/v1 Deployment
+func deleteDeployment(clientset kubernetes.Interface, namespace string,
+	name string, opts *metav1.DeleteOptions) error {
+	started := time.Now()
+	err := clientset.AppsV1().Deployments(namespace).Delete(name, opts)
+	observeKubernetesCall("delete_deployment", started, err)
+	if err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+
+	started = time.Now()
+	legacyErr := clientset.ExtensionsV1beta1().Deployments(namespace).Delete(name, opts)
+	observeKubernetesCall("delete_legacy_deployment", started, legacyErr)
+	if legacyErr != nil {
+		return err
+	}
+	return nil
+}
+
+// preserveSelector keeps the labels selected by the immutable selector of a
+// Deployment on its pods, so that an update cannot orphan them. Deployments
+// created by extensions/v1beta1 without a selector select every label of
+// their pods at the time.
+func preserveSelector(selector *metav1.LabelSelector, labels map[string]string) {
+	if selector == nil {
+		return
+	}
+	for k, v := range selector.MatchLabels {
+		labels[k] = v
+	}
+}
+
+// fromLegacyDeployment converts an extensions/v1beta1 Deployment, the two
+// share their JSON representation apart from fields apps/v1 dropped
+func fromLegacyDeployment(legacy *v1beta1.Deployment) (*appsv1.Deployment, error) {
+	data, err := json.Marshal(legacy)
+	if err != nil {
+		return nil, err
+	}
+	deployment := &appsv1.Deployment{}
+	if err = json.Unmarshal(data, deployment); err != nil {
+		return nil, err
+	}
+	deployment.APIVersion = "apps/v1"
+	deployment.Kind = "Deployment"
+	return deployment, nil
+}
+
+// toLegacyDeployment converts a Deployment read from extensions/v1beta1
+// back to be written through it
+func toLegacyDeployment(deployment *appsv1.Deployment) (*v1beta1.Deployment, error) {
+	data, err := json.Marshal(deployment)
+	if err != nil {
+		return nil, err
+	}
+	legacy := &v1beta1.Deployment{}
+	if err = json.Unmarshal(data, legacy); err != nil {
+		return nil, err
+	}
+	legacy.APIVersion = "extensions/v1beta1"
+	legacy.Kind = "Deployment"
+	return legacy, nil
+}
@@ -2,19 +2,20 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
-	"math/rand"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Lambda-NIC/faas-netes/logging"
+	"github.com/Lambda-NIC/faas-netes/types"
 	"go.etcd.io/etcd/client"
 )
 
 // CreateEtcdClient creates a client for ETCD deployment
-func CreateEtcdClient(etcdMasterIP string, etcdPort string) client.KeysAPI {
+func CreateEtcdClient(etcdMasterIP string, etcdPort string) Store {
 	cfg := client.Config{
 		Endpoints: []string{fmt.Sprintf("http://%s:%s", etcdMasterIP, etcdPort)},
 		Transport: client.DefaultTransport,
@@ -24,10 +25,10 @@ func CreateEtcdClient(etcdMasterIP string, etcdPort string) client.KeysAPI {
 	}
 	c, err := client.New(cfg)
 	if err != nil {
-		log.Fatal("Could not connect to ETCD: " + err.Error())
+		logging.Fatal("Could not connect to etcd", "error", err)
 	}
 	kapi := client.NewKeysAPI(c)
-	return kapi
+	return InstrumentKeysAPI(kapi)
 }
 
 // CreateDepKey creates a key for deployment
@@ -42,7 +43,7 @@ func CreateFuncKey(funcName string) string {
 }
 
 // EtcdFunctionCreate creates a function in etcd.
-func EtcdFunctionCreate(keysAPI client.KeysAPI,
+func EtcdFunctionCreate(keysAPI Store,
 	funcName string) error {
 	uid := fmt.Sprintf("%d", time.Now().Nanosecond())
 	funcKey := CreateFuncKey(funcName)
@@ -50,18 +51,20 @@ func EtcdFunctionCreate(keysAPI client.KeysAPI,
 	if err != nil {
 		return err
 	}
-	log.Printf("Added func: %s id: %s to ETCD. Metadata: %q\n",
-		funcName, uid, resp)
+	logging.Debug("Added function to etcd", "function_name", funcName,
+		"uid", uid, "etcd_index", resp.Index)
 	smartNICs, err := GetSmartNICS(keysAPI)
 	if err != nil {
 		return err
 	}
 	numTries := 0
 	for {
-		randIdx := rand.Intn(len(smartNICs))
-		smartNIC := smartNICs[randIdx]
-		var depKey = CreateDepKey(smartNIC, funcName)
-		resp, err = keysAPI.Set(context.Background(), depKey, "1", nil)
+		placement, placeErr := PlaceReplicas(PlacementRandom, smartNICs, 1, nil)
+		if placeErr != nil {
+			_, _ = keysAPI.Delete(context.Background(), funcKey, nil)
+			return placeErr
+		}
+		err = setPlacement(keysAPI, funcName, placement)
 		if err != nil {
 			numTries++
 			if numTries > 10 {
@@ -70,15 +73,15 @@ func EtcdFunctionCreate(keysAPI client.KeysAPI,
 			}
 			continue
 		}
-		log.Printf("Added a Dep: %s to ECTD. Metadata: %q\n", depKey, resp)
-		log.Printf("Created SmartNIC service - %s at %s\n", funcName, smartNIC)
+		logging.Info("Created SmartNIC function", "function_name", funcName,
+			"placement", placement)
 		break
 	}
 	return nil
 }
 
 // UpdateReplicas updates the number of replicas of a function.
-func UpdateReplicas(keysAPI client.KeysAPI,
+func UpdateReplicas(keysAPI Store,
 	numReplicas uint64, funcName string) error {
 	smartNICs, err := GetSmartNICS(keysAPI)
 	if err != nil {
@@ -87,52 +90,60 @@ func UpdateReplicas(keysAPI client.KeysAPI,
 
 	// Distribute load equally to all smartnics.
 	// TODO add max deployments
-	numDepsPerNIC := int(numReplicas) / len(smartNICs)
-	remainder := int(numReplicas) % len(smartNICs)
-	for i, smartNIC := range smartNICs {
-		depKey := CreateDepKey(smartNIC, funcName)
-		var err error
-		if i < remainder {
-			_, err = keysAPI.Set(context.Background(), depKey,
-				strconv.Itoa(numDepsPerNIC+1), nil)
-		} else {
-			_, err = keysAPI.Set(context.Background(), depKey,
-				strconv.Itoa(numDepsPerNIC), nil)
-		}
+	placement, err := PlaceReplicas(PlacementSpread, smartNICs, numReplicas, nil)
+	if err != nil {
+		return err
+	}
+	return setPlacement(keysAPI, funcName, placement)
+}
+
+// setPlacement writes the replica count of a function on every SmartNIC of
+// the placement
+func setPlacement(keysAPI Store, funcName string,
+	placement Placement) error {
+	for smartNIC, numDeps := range placement {
+		_, err := keysAPI.Set(context.Background(),
+			CreateDepKey(smartNIC, funcName),
+			strconv.FormatUint(numDeps, 10), nil)
 		if err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
 // EtcdFunctionDelete deletes the function with function name
-func EtcdFunctionDelete(keysAPI client.KeysAPI, funcName string) error {
+func EtcdFunctionDelete(keysAPI Store, funcName string) error {
 	smartNICs, err := GetSmartNICS(keysAPI)
 	if err != nil {
 		return err
 	}
 	for _, smartNIC := range smartNICs {
-		log.Println("Deleting deployment.")
 		_, err = keysAPI.Delete(context.Background(),
 			CreateDepKey(smartNIC, funcName), nil)
 		if err != nil {
-			log.Printf("Couldn't find deployment at server %s\n", smartNIC)
+			logging.Debug("No deployment on SmartNIC", "function_name", funcName,
+				"smartnic", smartNIC)
 		} else {
-			log.Printf("Deleted %s in server %s\n", funcName, smartNIC)
+			logging.Debug("Deleted deployment", "function_name", funcName,
+				"smartnic", smartNIC)
 		}
+		// A function deployed again must not be taken as acknowledged.
+		_, _ = keysAPI.Delete(context.Background(),
+			CreateAckKey(smartNIC, funcName), nil)
 	}
 	_, err = keysAPI.Delete(context.Background(), CreateFuncKey(funcName), nil)
 	if err != nil {
 		return err
 	}
-	log.Printf("Deleted Function: %s\n", funcName)
+	// Functions deployed before metadata was kept do not have any.
+	_, _ = keysAPI.Delete(context.Background(), CreateMetaKey(funcName), nil)
+	logging.Debug("Deleted function from etcd", "function_name", funcName)
 	return nil
 }
 
 // EtcdFunctionExists checks if the function exists in etcd.
-func EtcdFunctionExists(keysAPI client.KeysAPI, functionName string) bool {
+func EtcdFunctionExists(keysAPI Store, functionName string) bool {
 	_, err := keysAPI.Get(context.Background(),
 		fmt.Sprintf("/functions/%s", functionName),
 		nil)
@@ -141,11 +152,11 @@ func EtcdFunctionExists(keysAPI client.KeysAPI, functionName string) bool {
 }
 
 // GetSmartNICS returns the list of SmartNICs from ETCD.
-func GetSmartNICS(keysAPI client.KeysAPI) ([]string, error) {
+func GetSmartNICS(keysAPI Store) ([]string, error) {
 	resp, err := keysAPI.Get(context.Background(), "/smartnics", nil)
 	// No smartnics found in deployment.
 	if err != nil {
-		log.Println("Could not retrieve SmartNICs")
+		logging.Warn("Could not retrieve SmartNICs", "error", err)
 		return nil, err
 	}
 	var smartNICs []string
@@ -157,10 +168,10 @@ func GetSmartNICS(keysAPI client.KeysAPI) ([]string, error) {
 }
 
 // GetFunctions returns the list of functions
-func GetFunctions(keysAPI client.KeysAPI) ([]string, error) {
+func GetFunctions(keysAPI Store) ([]string, error) {
 	resp, err := keysAPI.Get(context.Background(), "/functions", nil)
 	if err != nil {
-		log.Println("Could not retrieve functions")
+		logging.Warn("Could not retrieve functions", "error", err)
 		return nil, err
 	}
 	var functions []string
@@ -172,7 +183,7 @@ func GetFunctions(keysAPI client.KeysAPI) ([]string, error) {
 }
 
 // GetNumDeployments gives the number of deployments for the function.
-func GetNumDeployments(keysAPI client.KeysAPI,
+func GetNumDeployments(keysAPI Store,
 	funcName string) (uint64, error) {
 
 	var numReplicas uint64
@@ -195,3 +206,223 @@ func GetNumDeployments(keysAPI client.KeysAPI,
 	}
 	return numReplicas, nil
 }
+
+// CreateHeartbeatKey creates the key a SmartNIC writes its heartbeat to
+func CreateHeartbeatKey(smartNIC string) string {
+	return fmt.Sprintf("/heartbeats/%s", smartNIC)
+}
+
+// CreateCapacityKey creates the key holding the replica capacity of a SmartNIC
+func CreateCapacityKey(smartNIC string) string {
+	return fmt.Sprintf("/capacity/%s", smartNIC)
+}
+
+// CreateAckKey creates the key a SmartNIC acknowledges the replicas of a
+// function it loaded with
+func CreateAckKey(smartNIC string, funcName string) string {
+	return fmt.Sprintf("/acks/%s/%s", smartNIC, funcName)
+}
+
+// GetSmartNICAcks returns the number of replicas of each function the
+// SmartNIC acknowledged.
+func GetSmartNICAcks(keysAPI Store, smartNIC string) (map[string]uint64, error) {
+	resp, err := keysAPI.Get(context.Background(),
+		fmt.Sprintf("/acks/%s", smartNIC), nil)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return map[string]uint64{}, nil
+		}
+		return nil, err
+	}
+	acks := make(map[string]uint64)
+	for _, n := range resp.Node.Nodes {
+		numDeps, numDepErr := strconv.ParseUint(n.Value, 10, 64)
+		if numDepErr != nil {
+			continue
+		}
+		parts := strings.Split(n.Key, "/")
+		acks[parts[len(parts)-1]] = numDeps
+	}
+	return acks, nil
+}
+
+// EtcdSmartNICExists checks if the SmartNIC is registered in etcd.
+func EtcdSmartNICExists(keysAPI Store, smartNIC string) bool {
+	_, err := keysAPI.Get(context.Background(),
+		fmt.Sprintf("/smartnics/%s", smartNIC),
+		nil)
+	return err == nil
+}
+
+// GetSmartNICDeployments returns the number of replicas of each function
+// deployed on the SmartNIC.
+func GetSmartNICDeployments(keysAPI Store,
+	smartNIC string) (map[string]uint64, error) {
+	resp, err := keysAPI.Get(context.Background(),
+		fmt.Sprintf("/deployments/smartnic/%s", smartNIC), nil)
+	if err != nil {
+		return nil, err
+	}
+	deployments := make(map[string]uint64)
+	for _, n := range resp.Node.Nodes {
+		numDeps, numDepErr := strconv.ParseUint(n.Value, 10, 64)
+		if numDepErr != nil {
+			continue
+		}
+		parts := strings.Split(n.Key, "/")
+		deployments[parts[len(parts)-1]] = numDeps
+	}
+	return deployments, nil
+}
+
+// GetFunctionPlacement returns the number of replicas of the function on
+// each SmartNIC which hosts at least one of them.
+func GetFunctionPlacement(keysAPI Store,
+	funcName string) (map[string]uint64, error) {
+	smartNICs, err := GetSmartNICS(keysAPI)
+	if err != nil {
+		return nil, err
+	}
+	placement := make(map[string]uint64)
+	for _, smartNIC := range smartNICs {
+		depVal, depErr := keysAPI.Get(context.Background(),
+			CreateDepKey(smartNIC, funcName), nil)
+		if depErr != nil {
+			continue
+		}
+		numDeps, numDepErr := strconv.ParseUint(depVal.Node.Value, 10, 64)
+		if numDepErr == nil && numDeps > 0 {
+			placement[smartNIC] = numDeps
+		}
+	}
+	return placement, nil
+}
+
+// GetSmartNICHeartbeat returns the time of the last heartbeat written by the
+// SmartNIC or nil if it has never sent one.
+func GetSmartNICHeartbeat(keysAPI Store, smartNIC string) *time.Time {
+	resp, err := keysAPI.Get(context.Background(),
+		CreateHeartbeatKey(smartNIC), nil)
+	if err != nil {
+		return nil
+	}
+	lastHeartbeat, parseErr := time.Parse(time.RFC3339Nano, resp.Node.Value)
+	if parseErr != nil {
+		return nil
+	}
+	return &lastHeartbeat
+}
+
+// GetSmartNICCapacity returns the number of replicas the SmartNIC can host,
+// zero means the capacity is not known.
+func GetSmartNICCapacity(keysAPI Store, smartNIC string) uint64 {
+	resp, err := keysAPI.Get(context.Background(),
+		CreateCapacityKey(smartNIC), nil)
+	if err != nil {
+		return 0
+	}
+	capacity, parseErr := strconv.ParseUint(resp.Node.Value, 10, 64)
+	if parseErr != nil {
+		return 0
+	}
+	return capacity
+}
+
+// CreateMetaKey creates a key for the labels and annotations of a function
+func CreateMetaKey(funcName string) string {
+	return fmt.Sprintf("/metadata/%s", funcName)
+}
+
+// EtcdSetFunctionMeta saves the labels and annotations of a function.
+func EtcdSetFunctionMeta(keysAPI Store, funcName string,
+	meta types.FunctionMeta) error {
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	_, err = keysAPI.Set(context.Background(), CreateMetaKey(funcName),
+		string(metaBytes), nil)
+	return err
+}
+
+// EtcdGetFunctionMeta returns the labels and annotations of a function.
+func EtcdGetFunctionMeta(keysAPI Store,
+	funcName string) (*types.FunctionMeta, error) {
+	resp, err := keysAPI.Get(context.Background(), CreateMetaKey(funcName), nil)
+	if err != nil {
+		return nil, err
+	}
+	meta := types.FunctionMeta{}
+	if err = json.Unmarshal([]byte(resp.Node.Value), &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// CreateInvocationKey creates the key holding the invocations of a function
+// counted by a provider replica
+func CreateInvocationKey(funcName string, replica string) string {
+	return fmt.Sprintf("/invocations/%s/%s", funcName, replica)
+}
+
+// GetInvocationShards returns the invocations of a function counted by each
+// provider replica.
+func GetInvocationShards(keysAPI Store,
+	funcName string) (map[string]uint64, error) {
+	resp, err := keysAPI.Get(context.Background(),
+		fmt.Sprintf("/invocations/%s", funcName), nil)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return map[string]uint64{}, nil
+		}
+		return nil, err
+	}
+	shards := map[string]uint64{}
+	for _, node := range resp.Node.Nodes {
+		count, parseErr := strconv.ParseUint(node.Value, 10, 64)
+		if parseErr != nil {
+			continue
+		}
+		shards[node.Key[strings.LastIndex(node.Key, "/")+1:]] = count
+	}
+	return shards, nil
+}
+
+// CreateReplicaKey creates the key a provider replica writes the time of its
+// last flush of invocation counts to
+func CreateReplicaKey(replica string) string {
+	return fmt.Sprintf("/replicas/%s", replica)
+}
+
+// GetReplicaHeartbeats returns the time of the last flush of every provider
+// replica which wrote one.
+func GetReplicaHeartbeats(keysAPI Store) (map[string]time.Time, error) {
+	resp, err := keysAPI.Get(context.Background(), "/replicas", nil)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return map[string]time.Time{}, nil
+		}
+		return nil, err
+	}
+	heartbeats := map[string]time.Time{}
+	for _, node := range resp.Node.Nodes {
+		heartbeat, parseErr := time.Parse(time.RFC3339Nano, node.Value)
+		if parseErr != nil {
+			continue
+		}
+		heartbeats[node.Key[strings.LastIndex(node.Key, "/")+1:]] = heartbeat
+	}
+	return heartbeats, nil
+}
+
+// EtcdDeleteInvocations deletes the invocations of a function counted by
+// every provider replica.
+func EtcdDeleteInvocations(keysAPI Store, funcName string) error {
+	opts := client.DeleteOptions{Recursive: true, Dir: true}
+	_, err := keysAPI.Delete(context.Background(),
+		fmt.Sprintf("/invocations/%s", funcName), &opts)
+	if err != nil && !client.IsKeyNotFound(err) {
+		return err
+	}
+	return nil
+}
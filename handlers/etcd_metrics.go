@@ -0,0 +1,124 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/tracing"
+	"go.etcd.io/etcd/client"
+)
+
+// instrumentedKeysAPI times every call made to etcd and counts the failures
+type instrumentedKeysAPI struct {
+	Store
+}
+
+// InstrumentKeysAPI wraps keysAPI so that its calls are exported as metrics.
+func InstrumentKeysAPI(keysAPI Store) Store {
+	return &instrumentedKeysAPI{Store: keysAPI}
+}
+
+// keyspace returns the top level directory of a key, such as functions for
+// /functions/echo-lambdanic
+func keyspace(key string) string {
+	return strings.SplitN(strings.TrimPrefix(key, "/"), "/", 2)[0]
+}
+
+// startEtcdSpan traces an etcd call made on behalf of a traced operation
+func startEtcdSpan(ctx context.Context, operation string, key string) *tracing.Span {
+	if tracing.SpanFromContext(ctx) == nil {
+		return nil
+	}
+	_, span := tracing.StartSpan(ctx, "etcd "+operation)
+	span.SetAttribute("key", key)
+	return span
+}
+
+func observeEtcdCall(span *tracing.Span, operation string, key string,
+	started time.Time, err error) {
+	if !client.IsKeyNotFound(err) {
+		span.SetError(err)
+	}
+	span.Finish()
+
+	outcome := outcomeSuccess
+	if err != nil {
+		outcome = outcomeError
+		if client.IsKeyNotFound(err) {
+			outcome = outcomeNotFound
+		} else {
+			etcdErrors.WithLabelValues(operation, keyspace(key)).Inc()
+		}
+	}
+	etcdDuration.WithLabelValues(operation, keyspace(key), outcome).
+		Observe(time.Since(started).Seconds())
+}
+
+func (k *instrumentedKeysAPI) Get(ctx context.Context, key string,
+	opts *client.GetOptions) (*client.Response, error) {
+	span := startEtcdSpan(ctx, "get", key)
+	started := time.Now()
+	resp, err := k.Store.Get(ctx, key, opts)
+	observeEtcdCall(span, "get", key, started, err)
+	return resp, err
+}
+
+func (k *instrumentedKeysAPI) Set(ctx context.Context, key, value string,
+	opts *client.SetOptions) (*client.Response, error) {
+	span := startEtcdSpan(ctx, "set", key)
+	started := time.Now()
+	resp, err := k.Store.Set(ctx, key, value, opts)
+	observeEtcdCall(span, "set", key, started, err)
+	return resp, err
+}
+
+func (k *instrumentedKeysAPI) Delete(ctx context.Context, key string,
+	opts *client.DeleteOptions) (*client.Response, error) {
+	span := startEtcdSpan(ctx, "delete", key)
+	started := time.Now()
+	resp, err := k.Store.Delete(ctx, key, opts)
+	observeEtcdCall(span, "delete", key, started, err)
+	return resp, err
+}
+
+// tracedKeysAPI makes the etcd calls of a traced handler children of its
+// span, as the etcd helpers call etcd with a background context
+type tracedKeysAPI struct {
+	Store
+	ctx context.Context
+}
+
+// traceKeysAPI binds keysAPI to the span carried by ctx, it returns keysAPI
+// itself when ctx is not traced.
+func traceKeysAPI(keysAPI Store, ctx context.Context) Store {
+	if tracing.SpanFromContext(ctx) == nil {
+		return keysAPI
+	}
+	return &tracedKeysAPI{Store: keysAPI, ctx: ctx}
+}
+
+// bind uses the bound context unless ctx is traced itself
+func (k *tracedKeysAPI) bind(ctx context.Context) context.Context {
+	if tracing.SpanFromContext(ctx) != nil {
+		return ctx
+	}
+	return k.ctx
+}
+
+func (k *tracedKeysAPI) Get(ctx context.Context, key string,
+	opts *client.GetOptions) (*client.Response, error) {
+	return k.Store.Get(k.bind(ctx), key, opts)
+}
+
+func (k *tracedKeysAPI) Set(ctx context.Context, key, value string,
+	opts *client.SetOptions) (*client.Response, error) {
+	return k.Store.Set(k.bind(ctx), key, value, opts)
+}
+
+func (k *tracedKeysAPI) Delete(ctx context.Context, key string,
+	opts *client.DeleteOptions) (*client.Response, error) {
+	return k.Store.Delete(k.bind(ctx), key, opts)
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.etcd.io/etcd/client"
+)
+
+func Test_keyspace(t *testing.T) {
+	cases := map[string]string{
+		"/functions/echo-lambdanic":                     "functions",
+		"/deployments/smartnic/10.0.0.1/echo-lambdanic": "deployments",
+		"/smartnics": "smartnics",
+	}
+	for key, want := range cases {
+		if got := keyspace(key); got != want {
+			t.Errorf("Key: %s want: %s, got: %s", key, want, got)
+		}
+	}
+}
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	sample := dto.Metric{}
+	if err := counter.Write(&sample); err != nil {
+		t.Fatal(err)
+	}
+	return sample.GetCounter().GetValue()
+}
+
+func Test_InstrumentKeysAPI_CountsErrorsButNotMissingKeys(t *testing.T) {
+	keysAPI := InstrumentKeysAPI(NewMemoryStore())
+	errors := etcdErrors.WithLabelValues("get", "metricstest")
+	before := counterValue(t, errors)
+
+	_, err := keysAPI.Get(context.Background(), "/metricstest/missing", nil)
+	if !client.IsKeyNotFound(err) {
+		t.Fatalf("want key not found, got: %v", err)
+	}
+	if _, err = keysAPI.Set(context.Background(), "/metricstest/key", "1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = keysAPI.Get(context.Background(), "/metricstest/key", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if after := counterValue(t, errors); after != before {
+		t.Errorf("want missing key not counted as error, got: %v errors", after-before)
+	}
+}
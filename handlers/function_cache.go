@@ -0,0 +1,307 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/logging"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// functionSelector selects the Deployments and Services of functions
+const functionSelector = "faas_function"
+
+// cacheRetryInterval is how long a reflector waits before listing again
+// after the API server failed
+const cacheRetryInterval = time.Second
+
+// FunctionCache keeps the function Deployments and Services of a namespace
+// in memory so that reads do not reach the API server. Every resource is
+// listed once and then watched, and listed again whenever its watch fails.
+// Deployments only served by extensions/v1beta1 are kept as well.
+type FunctionCache struct {
+	deployments       *reflector
+	legacyDeployments *reflector
+	services          *reflector
+	stop              chan struct{}
+	wg                sync.WaitGroup
+}
+
+// NewFunctionCache creates a cache of the functions in namespace, call Run
+// to fill it
+func NewFunctionCache(clientset kubernetes.Interface, namespace string) *FunctionCache {
+	opts := func(resourceVersion string) metav1.ListOptions {
+		return metav1.ListOptions{LabelSelector: functionSelector, ResourceVersion: resourceVersion}
+	}
+	return &FunctionCache{
+		deployments: newReflector("deployments",
+			func() (runtime.Object, error) {
+				return clientset.AppsV1().Deployments(namespace).List(opts(""))
+			},
+			func(resourceVersion string) (watch.Interface, error) {
+				return clientset.AppsV1().Deployments(namespace).Watch(opts(resourceVersion))
+			}),
+		legacyDeployments: newReflector("legacy_deployments",
+			func() (runtime.Object, error) {
+				return clientset.ExtensionsV1beta1().Deployments(namespace).List(opts(""))
+			},
+			func(resourceVersion string) (watch.Interface, error) {
+				return clientset.ExtensionsV1beta1().Deployments(namespace).Watch(opts(resourceVersion))
+			}),
+		services: newReflector("services",
+			func() (runtime.Object, error) {
+				return clientset.CoreV1().Services(namespace).List(opts(""))
+			},
+			func(resourceVersion string) (watch.Interface, error) {
+				return clientset.CoreV1().Services(namespace).Watch(opts(resourceVersion))
+			}),
+		stop: make(chan struct{}),
+	}
+}
+
+// Run lists and watches every resource until Close is called
+func (c *FunctionCache) Run() {
+	for _, r := range []*reflector{c.deployments, c.legacyDeployments, c.services} {
+		c.wg.Add(1)
+		go func(r *reflector) {
+			defer c.wg.Done()
+			r.run(c.stop)
+		}(r)
+	}
+}
+
+// Close stops watching the API server
+func (c *FunctionCache) Close() {
+	close(c.stop)
+	c.wg.Wait()
+}
+
+// HasSynced returns true once every resource was listed
+func (c *FunctionCache) HasSynced() bool {
+	return c.deployments.hasSynced() && c.legacyDeployments.hasSynced() &&
+		c.services.hasSynced()
+}
+
+// Deployments returns the function Deployments ordered by name, an apps/v1
+// Deployment hiding a legacy one of the same name
+func (c *FunctionCache) Deployments() ([]appsv1.Deployment, error) {
+	deployments := []appsv1.Deployment{}
+	listed := map[string]bool{}
+	for _, object := range c.deployments.list() {
+		deployment := object.(*appsv1.Deployment)
+		deployments = append(deployments, *deployment)
+		listed[deployment.Name] = true
+	}
+	for _, object := range c.legacyDeployments.list() {
+		legacy := object.(*v1beta1.Deployment)
+		if listed[legacy.Name] {
+			continue
+		}
+		deployment, err := fromLegacyDeployment(legacy)
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, *deployment)
+	}
+	sort.Slice(deployments, func(i, j int) bool {
+		return deployments[i].Name < deployments[j].Name
+	})
+	return deployments, nil
+}
+
+// Deployment returns the Deployment of a function, a NotFound error is
+// returned when it is not cached
+func (c *FunctionCache) Deployment(name string) (*appsv1.Deployment, error) {
+	if object, ok := c.deployments.get(name); ok {
+		return object.(*appsv1.Deployment), nil
+	}
+	if object, ok := c.legacyDeployments.get(name); ok {
+		return fromLegacyDeployment(object.(*v1beta1.Deployment))
+	}
+	return nil, errors.NewNotFound(appsv1.Resource("deployments"), name)
+}
+
+// Service returns the Service of a function, a NotFound error is returned
+// when it is not cached. Services are labelled with faas_function when the
+// function is deployed, those deployed before are labelled, and cached, once
+// the function is updated.
+func (c *FunctionCache) Service(name string) (*corev1.Service, error) {
+	if object, ok := c.services.get(name); ok {
+		return object.(*corev1.Service), nil
+	}
+	return nil, errors.NewNotFound(corev1.Resource("services"), name)
+}
+
+// reflector mirrors one resource of the API server
+type reflector struct {
+	resource string
+	lister   func() (runtime.Object, error)
+	watcher  func(resourceVersion string) (watch.Interface, error)
+
+	mutex   sync.RWMutex
+	objects map[string]runtime.Object
+	synced  bool
+}
+
+func newReflector(resource string, lister func() (runtime.Object, error),
+	watcher func(resourceVersion string) (watch.Interface, error)) *reflector {
+	return &reflector{
+		resource: resource,
+		lister:   lister,
+		watcher:  watcher,
+		objects:  map[string]runtime.Object{},
+	}
+}
+
+func (r *reflector) hasSynced() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.synced
+}
+
+func (r *reflector) get(name string) (runtime.Object, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	object, ok := r.objects[name]
+	return object, ok
+}
+
+func (r *reflector) list() []runtime.Object {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	objects := make([]runtime.Object, 0, len(r.objects))
+	for _, object := range r.objects {
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+// run lists the resource and applies its watch events until stop is closed
+func (r *reflector) run(stop <-chan struct{}) {
+	logger := logging.FromContext(context.Background()).With("resource", r.resource)
+	for {
+		resourceVersion, err := r.relist()
+		switch {
+		case err != nil && errors.IsNotFound(err):
+			// The API group is not served, such as extensions/v1beta1 on
+			// recent clusters, so there is nothing to watch.
+			logger.Debug("Resource not served, not cached", "error", err)
+			r.replace(nil)
+			return
+		case err != nil:
+			logger.Warn("Could not list functions", "error", err)
+		default:
+			if err = r.watch(resourceVersion, stop); err == nil {
+				return
+			}
+			logger.Warn("Function watch failed, listing again", "error", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(cacheRetryInterval):
+		}
+	}
+}
+
+// relist replaces the cached objects with a fresh list and returns its
+// resource version
+func (r *reflector) relist() (string, error) {
+	started := time.Now()
+	list, err := r.lister()
+	observeKubernetesCall("list_"+r.resource, started, err)
+	if err != nil {
+		return "", err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return "", err
+	}
+	r.replace(items)
+	listMeta, err := meta.ListAccessor(list)
+	if err != nil {
+		return "", err
+	}
+	return listMeta.GetResourceVersion(), nil
+}
+
+func (r *reflector) replace(items []runtime.Object) {
+	objects := map[string]runtime.Object{}
+	for _, item := range items {
+		if accessor, err := meta.Accessor(item); err == nil {
+			objects[accessor.GetName()] = item
+		}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.objects = objects
+	r.synced = true
+}
+
+// watch applies the events of the resource from resourceVersion on. It
+// returns nil when stop is closed and an error when the resource must be
+// listed again.
+func (r *reflector) watch(resourceVersion string, stop <-chan struct{}) error {
+	for {
+		w, err := r.watcher(resourceVersion)
+		if err != nil {
+			return err
+		}
+		resourceVersion, err = r.apply(w, resourceVersion, stop)
+		w.Stop()
+		if err != nil {
+			return err
+		}
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+	}
+}
+
+// apply applies the events of a watch until it ends and returns the last
+// resource version seen
+func (r *reflector) apply(w watch.Interface, resourceVersion string,
+	stop <-chan struct{}) (string, error) {
+	for {
+		select {
+		case <-stop:
+			return resourceVersion, nil
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				// The API server ends watches, they are started again.
+				return resourceVersion, nil
+			}
+			if event.Type == watch.Error {
+				return resourceVersion, errors.FromObject(event.Object)
+			}
+			accessor, err := meta.Accessor(event.Object)
+			if err != nil {
+				return resourceVersion, fmt.Errorf("unexpected watch object: %v", err)
+			}
+			r.mutex.Lock()
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				r.objects[accessor.GetName()] = event.Object
+			case watch.Deleted:
+				delete(r.objects, accessor.GetName())
+			}
+			r.mutex.Unlock()
+			resourceVersion = accessor.GetResourceVersion()
+		}
+	}
+}
@@ -1,9 +1,19 @@
 package handlers
 
-import "net/http"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
 
-// MakeHealthHandler returns 200/OK when healthy
-// LambdaNIC: Should this always return OK?
+	"github.com/Lambda-NIC/faas-netes/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// MakeHealthHandler returns 200/OK while the provider serves HTTP. It is the
+// liveness check, the dependencies are checked by the readiness handler.
 func MakeHealthHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
@@ -11,3 +21,149 @@ func MakeHealthHandler() http.HandlerFunc {
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+const (
+	// ReadinessEtcd checks that etcd can be read
+	ReadinessEtcd = "etcd"
+	// ReadinessKubernetes checks that the Kubernetes API can be reached
+	ReadinessKubernetes = "kubernetes"
+	// ReadinessSmartNIC checks that at least one SmartNIC is healthy
+	ReadinessSmartNIC = "smartnic"
+	// ReadinessCache checks that the function cache is synced
+	ReadinessCache = "cache"
+)
+
+// readinessTimeout is how long the readiness checks, which run concurrently,
+// may take before those which did not return fail
+const readinessTimeout = 2 * time.Second
+
+// ReadinessCheck returns an error when a dependency of the provider is not
+// usable
+type ReadinessCheck func(ctx context.Context) error
+
+// EtcdReadinessCheck reads the SmartNIC directory from etcd
+func EtcdReadinessCheck(keysAPI Store) ReadinessCheck {
+	return func(ctx context.Context) error {
+		_, err := keysAPI.Get(ctx, "/smartnics", nil)
+		return err
+	}
+}
+
+// KubernetesReadinessCheck asks the Kubernetes API for its version, the
+// request is cancelled with the context
+func KubernetesReadinessCheck(clientset kubernetes.Interface) ReadinessCheck {
+	return func(ctx context.Context) error {
+		return clientset.Discovery().RESTClient().Get().
+			AbsPath("/version").
+			Context(ctx).
+			Do().
+			Error()
+	}
+}
+
+// FunctionCacheReadinessCheck checks that the functions were listed into the
+// cache
+func FunctionCacheReadinessCheck(cache *FunctionCache) ReadinessCheck {
+	return func(ctx context.Context) error {
+		if !cache.HasSynced() {
+			return fmt.Errorf("function cache not synced")
+		}
+		return nil
+	}
+}
+
+// SmartNICReadinessCheck looks for a healthy SmartNIC in the routing table
+func SmartNICReadinessCheck(routingTable *RoutingTable) ReadinessCheck {
+	return func(ctx context.Context) error {
+		for _, smartNIC := range routingTable.registeredSmartNICs() {
+			if routingTable.healthOf(smartNIC) == SmartNICHealthy {
+				return nil
+			}
+		}
+		return fmt.Errorf("no healthy SmartNIC")
+	}
+}
+
+// runReadinessCheck runs a check, failing it when it does not return before
+// the context is done
+func runReadinessCheck(ctx context.Context, check ReadinessCheck) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- check(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out: %v", ctx.Err())
+	}
+}
+
+// MakeReadinessHandler returns 200/OK when every required check passes and
+// 503/Service Unavailable otherwise. Every check is run and reported so that
+// the failing dependency can be told apart, concurrently and within
+// readinessTimeout so that slow dependencies do not add up.
+func MakeReadinessHandler(checks map[string]ReadinessCheck,
+	required []string) http.HandlerFunc {
+	isRequired := map[string]bool{}
+	for _, name := range required {
+		isRequired[name] = true
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		status := types.ReadinessStatus{
+			Ready:  true,
+			Checks: map[string]types.CheckStatus{},
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+		var mutex sync.Mutex
+		var wg sync.WaitGroup
+		for name, check := range checks {
+			wg.Add(1)
+			go func(name string, check ReadinessCheck) {
+				defer wg.Done()
+				started := time.Now()
+				err := runReadinessCheck(ctx, check)
+				checkStatus := types.CheckStatus{
+					Healthy:  err == nil,
+					Required: isRequired[name],
+					Duration: time.Since(started).String(),
+				}
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				if err != nil {
+					checkStatus.Error = err.Error()
+					if checkStatus.Required {
+						status.Ready = false
+					}
+				}
+				status.Checks[name] = checkStatus
+			}(name, check)
+		}
+		wg.Wait()
+		for name := range isRequired {
+			if _, ok := checks[name]; !ok {
+				status.Ready = false
+				status.Checks[name] = types.CheckStatus{
+					Required: true,
+					Error:    "unknown check",
+				}
+			}
+		}
+
+		statusBytes, _ := json.Marshal(status)
+		w.Header().Set("Content-Type", "application/json")
+		if status.Ready {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(statusBytes)
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func passingCheck(ctx context.Context) error { return nil }
+
+func failingCheck(ctx context.Context) error { return errTest }
+
+func readiness(t *testing.T, checks map[string]ReadinessCheck,
+	required []string) (int, types.ReadinessStatus) {
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+	MakeReadinessHandler(checks, required).ServeHTTP(rr, req)
+
+	status := types.ReadinessStatus{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	return rr.Code, status
+}
+
+func Test_MakeReadinessHandler_OptionalCheckFailing(t *testing.T) {
+	code, status := readiness(t, map[string]ReadinessCheck{
+		ReadinessEtcd:     passingCheck,
+		ReadinessSmartNIC: failingCheck,
+	}, []string{ReadinessEtcd})
+
+	if code != http.StatusOK || !status.Ready {
+		t.Errorf("want ready with failing optional check, got: %d %v", code, status)
+	}
+	if check := status.Checks[ReadinessSmartNIC]; check.Healthy || check.Error != errTest.Error() {
+		t.Errorf("want optional check reported as failed, got: %v", check)
+	}
+}
+
+func Test_MakeReadinessHandler_RequiredCheckFailing(t *testing.T) {
+	code, status := readiness(t, map[string]ReadinessCheck{
+		ReadinessEtcd:       failingCheck,
+		ReadinessKubernetes: passingCheck,
+	}, []string{ReadinessEtcd, ReadinessKubernetes})
+
+	if code != http.StatusServiceUnavailable || status.Ready {
+		t.Errorf("want not ready with failing required check, got: %d %v", code, status)
+	}
+	if !status.Checks[ReadinessKubernetes].Healthy {
+		t.Errorf("want other checks still reported, got: %v", status.Checks)
+	}
+}
+
+func Test_MakeReadinessHandler_UnknownRequiredCheck(t *testing.T) {
+	code, _ := readiness(t, map[string]ReadinessCheck{}, []string{"unknown"})
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("want not ready with unknown required check, got: %d", code)
+	}
+}
+
+func Test_runReadinessCheck_TimesOut(t *testing.T) {
+	block := func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := runReadinessCheck(ctx, block); err == nil {
+		t.Errorf("want timeout error")
+	}
+}
+
+func Test_MakeReadinessHandler_RunsChecksConcurrently(t *testing.T) {
+	slow := func(ctx context.Context) error {
+		time.Sleep(300 * time.Millisecond)
+		return nil
+	}
+	started := time.Now()
+	code, _ := readiness(t, map[string]ReadinessCheck{
+		ReadinessEtcd:       slow,
+		ReadinessKubernetes: slow,
+		ReadinessCache:      slow,
+	}, []string{ReadinessEtcd, ReadinessKubernetes, ReadinessCache})
+	if code != http.StatusOK {
+		t.Errorf("want ready, got: %d", code)
+	}
+	if elapsed := time.Since(started); elapsed > 600*time.Millisecond {
+		t.Errorf("want the checks run concurrently, took: %s", elapsed)
+	}
+}
+
+func Test_KubernetesReadinessCheck_HonoursContext(t *testing.T) {
+	released := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-released
+	}))
+	defer server.Close()
+	defer close(released)
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if err = KubernetesReadinessCheck(clientset)(ctx); err == nil {
+		t.Errorf("want an error when the Kubernetes API does not answer")
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("want the check cancelled with its context, took: %s", elapsed)
+	}
+}
+
+func Test_SmartNICReadinessCheck(t *testing.T) {
+	unhealthy := newTestRoutingTable(map[string]string{"10.0.0.1": SmartNICUnhealthy})
+	if err := SmartNICReadinessCheck(unhealthy)(context.Background()); err == nil {
+		t.Errorf("want error without a healthy SmartNIC")
+	}
+	healthy := newTestRoutingTable(map[string]string{
+		"10.0.0.1": SmartNICUnhealthy,
+		"10.0.0.2": SmartNICHealthy,
+	})
+	if err := SmartNICReadinessCheck(healthy)(context.Background()); err != nil {
+		t.Errorf("want healthy SmartNIC found, got: %v", err)
+	}
+}
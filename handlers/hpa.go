@@ -0,0 +1,141 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// scaleTargetLabel is the average CPU utilisation, in percent of the
+	// requested CPU, the HorizontalPodAutoscaler of a function keeps
+	scaleTargetLabel = "com.openfaas.scale.target"
+	// defaultScaleTarget is the CPU utilisation targeted when a function
+	// only sets com.openfaas.scale.max
+	defaultScaleTarget = 80
+	// defaultScaleMax is the most replicas of a function which only sets
+	// com.openfaas.scale.target
+	defaultScaleMax = 20
+)
+
+// Container functions which set com.openfaas.scale.max or
+// com.openfaas.scale.target are scaled by an autoscaling/v1
+// HorizontalPodAutoscaler named after them, between com.openfaas.scale.min
+// and com.openfaas.scale.max replicas. The HorizontalPodAutoscaler follows
+// the labels of the function on every update and is deleted along with it.
+
+// makeHPASpec returns the HorizontalPodAutoscaler of a function, or nil
+// when its labels do not ask for one. An error is returned for labels which
+// are not valid.
+func makeHPASpec(functionName string, labels map[string]string,
+	legacy bool) (*autoscalingv1.HorizontalPodAutoscaler, error) {
+	maxValue, hasMax := labels[scaleMaxLabel]
+	targetValue, hasTarget := labels[scaleTargetLabel]
+	if !hasMax && !hasTarget {
+		return nil, nil
+	}
+
+	minReplicas := int32(1)
+	if value := getMinReplicaCount(labels); value != nil {
+		minReplicas = *value
+	}
+	maxReplicas := int32(defaultScaleMax)
+	if hasMax {
+		parsed, err := strconv.ParseInt(maxValue, 10, 32)
+		if err != nil || parsed < 1 {
+			return nil, fmt.Errorf("invalid %s label: %q", scaleMaxLabel, maxValue)
+		}
+		maxReplicas = int32(parsed)
+	}
+	if maxReplicas < minReplicas {
+		return nil, fmt.Errorf("%s label %d is below the minimum of %d replicas",
+			scaleMaxLabel, maxReplicas, minReplicas)
+	}
+	target := int32(defaultScaleTarget)
+	if hasTarget {
+		parsed, err := strconv.ParseInt(targetValue, 10, 32)
+		if err != nil || parsed < 1 {
+			return nil, fmt.Errorf("invalid %s label: %q", scaleTargetLabel, targetValue)
+		}
+		target = int32(parsed)
+	}
+
+	apiVersion := "apps/v1"
+	if legacy {
+		apiVersion = "extensions/v1beta1"
+	}
+	return &autoscalingv1.HorizontalPodAutoscaler{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "HorizontalPodAutoscaler",
+			APIVersion: "autoscaling/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   functionName,
+			Labels: map[string]string{"faas_function": functionName},
+		},
+		Spec: autoscalingv1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv1.CrossVersionObjectReference{
+				Kind:       "Deployment",
+				Name:       functionName,
+				APIVersion: apiVersion,
+			},
+			MinReplicas:                    &minReplicas,
+			MaxReplicas:                    maxReplicas,
+			TargetCPUUtilizationPercentage: &target,
+		},
+	}, nil
+}
+
+// syncHPA creates, updates or deletes the HorizontalPodAutoscaler of a
+// function so that it matches hpa, nil meaning it should not have one
+func syncHPA(clientset kubernetes.Interface, functionNamespace string,
+	functionName string, hpa *autoscalingv1.HorizontalPodAutoscaler) error {
+	hpas := clientset.AutoscalingV1().HorizontalPodAutoscalers(functionNamespace)
+
+	started := time.Now()
+	existing, err := hpas.Get(functionName, metav1.GetOptions{})
+	observeKubernetesCall("get_hpa", started, err)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	found := err == nil
+
+	switch {
+	case hpa == nil && found:
+		return deleteHPA(clientset, functionNamespace, functionName)
+	case hpa == nil:
+		return nil
+	case found:
+		existing.Labels = hpa.Labels
+		existing.Spec = hpa.Spec
+		started = time.Now()
+		_, err = hpas.Update(existing)
+		observeKubernetesCall("update_hpa", started, err)
+		return err
+	default:
+		started = time.Now()
+		_, err = hpas.Create(hpa)
+		observeKubernetesCall("create_hpa", started, err)
+		return err
+	}
+}
+
+// deleteHPA deletes the HorizontalPodAutoscaler of a function, if it has one
+func deleteHPA(clientset kubernetes.Interface, functionNamespace string,
+	functionName string) error {
+	started := time.Now()
+	err := clientset.AutoscalingV1().HorizontalPodAutoscalers(functionNamespace).
+		Delete(functionName, &metav1.DeleteOptions{})
+	observeKubernetesCall("delete_hpa", started, err)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
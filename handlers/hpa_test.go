@@ -0,0 +1,72 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"testing"
+)
+
+func Test_makeHPASpec(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		legacy      bool
+		wantHPA     bool
+		wantMin     int32
+		wantMax     int32
+		wantTarget  int32
+		wantVersion string
+	}{
+		{name: "no scaling labels", labels: map[string]string{"com.openfaas.scale.min": "2"}},
+		{name: "maximum only", labels: map[string]string{"com.openfaas.scale.max": "5"},
+			wantHPA: true, wantMin: 1, wantMax: 5, wantTarget: 80, wantVersion: "apps/v1"},
+		{name: "target only", labels: map[string]string{"com.openfaas.scale.target": "50"},
+			wantHPA: true, wantMin: 1, wantMax: 20, wantTarget: 50, wantVersion: "apps/v1"},
+		{name: "every label", labels: map[string]string{"com.openfaas.scale.min": "2",
+			"com.openfaas.scale.max": "4", "com.openfaas.scale.target": "60"},
+			wantHPA: true, wantMin: 2, wantMax: 4, wantTarget: 60, wantVersion: "apps/v1"},
+		{name: "legacy deployment", labels: map[string]string{"com.openfaas.scale.max": "3"}, legacy: true,
+			wantHPA: true, wantMin: 1, wantMax: 3, wantTarget: 80, wantVersion: "extensions/v1beta1"},
+	}
+	for _, test := range tests {
+		hpa, err := makeHPASpec("echo", test.labels, test.legacy)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !test.wantHPA {
+			if hpa != nil {
+				t.Errorf("%s: want no autoscaler, got: %+v", test.name, hpa.Spec)
+			}
+			continue
+		}
+		if hpa == nil {
+			t.Errorf("%s: want an autoscaler", test.name)
+			continue
+		}
+		spec := hpa.Spec
+		if *spec.MinReplicas != test.wantMin || spec.MaxReplicas != test.wantMax ||
+			*spec.TargetCPUUtilizationPercentage != test.wantTarget {
+			t.Errorf("%s: want %d to %d replicas at %d%%, got: %d to %d at %d%%", test.name,
+				test.wantMin, test.wantMax, test.wantTarget, *spec.MinReplicas, spec.MaxReplicas,
+				*spec.TargetCPUUtilizationPercentage)
+		}
+		if spec.ScaleTargetRef.Name != "echo" || spec.ScaleTargetRef.APIVersion != test.wantVersion {
+			t.Errorf("%s: want the %s deployment scaled, got: %+v", test.name, test.wantVersion,
+				spec.ScaleTargetRef)
+		}
+	}
+}
+
+func Test_makeHPASpec_Invalid(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{"com.openfaas.scale.max": "many"},
+		{"com.openfaas.scale.max": "0"},
+		{"com.openfaas.scale.target": "-5"},
+		{"com.openfaas.scale.min": "3", "com.openfaas.scale.max": "2"},
+	} {
+		if _, err := makeHPASpec("echo", labels, false); err == nil {
+			t.Errorf("want an error for labels %v", labels)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	netesTypes "github.com/Lambda-NIC/faas-netes/types"
 	"github.com/Lambda-NIC/faas-provider/types"
 )
 
@@ -14,22 +15,44 @@ const (
 	ProviderName = "faas-netes"
 )
 
-//MakeInfoHandler creates handler for /system/info endpoint
-func MakeInfoHandler(version, sha string) http.HandlerFunc {
+// MakeInfoHandler creates handler for /system/info endpoint. The SmartNIC
+// pools are taken from the routing table and features lists which optional
+// behaviour is enabled.
+func MakeInfoHandler(version, sha string, routingTable *RoutingTable,
+	features map[string]bool) http.HandlerFunc {
+	if features == nil {
+		features = map[string]bool{}
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Body != nil {
 			defer r.Body.Close()
 		}
 
-		infoRequest := types.InfoRequest{
-			Orchestration: OrchestrationIdentifier,
-			Provider:      ProviderName,
-			Version: types.ProviderVersion{
-				Release: version,
-				SHA:     sha,
+		infoRequest := netesTypes.ProviderInfo{
+			InfoRequest: types.InfoRequest{
+				Orchestration: OrchestrationIdentifier,
+				Provider:      ProviderName,
+				Version: types.ProviderVersion{
+					Release: version,
+					SHA:     sha,
+				},
+			},
+			Capabilities: netesTypes.ProviderCapabilities{
+				Backends:            []string{backendContainer, backendSmartNIC, backendBareMetal},
+				ProtocolVersions:    nicProtocolVersions,
+				RoutingModes:        []string{RoutingRandom, RoutingAdaptive},
+				PlacementStrategies: PlacementStrategies,
+				Features:            features,
 			},
 		}
 
+		// LambdaNIC: Bare-metal servers are registered along with SmartNICs,
+		// so both backends are served by a single pool.
+		infoRequest.Capabilities.Pools = []netesTypes.BackendPool{
+			makeSmartNICPool(routingTable),
+		}
+
 		jsonOut, marshalErr := json.Marshal(infoRequest)
 		if marshalErr != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -41,3 +64,22 @@ func MakeInfoHandler(version, sha string) http.HandlerFunc {
 		w.Write(jsonOut)
 	}
 }
+
+// makeSmartNICPool counts the registered SmartNICs by health
+func makeSmartNICPool(routingTable *RoutingTable) netesTypes.BackendPool {
+	pool := netesTypes.BackendPool{
+		Backends: []string{backendSmartNIC, backendBareMetal},
+	}
+	for _, smartNIC := range routingTable.registeredSmartNICs() {
+		pool.Size++
+		switch routingTable.healthOf(smartNIC) {
+		case SmartNICHealthy:
+			pool.Healthy++
+		case SmartNICUnhealthy:
+			pool.Unhealthy++
+		default:
+			pool.Unknown++
+		}
+	}
+	return pool
+}
@@ -0,0 +1,278 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/logging"
+	"go.etcd.io/etcd/client"
+)
+
+// foldedShard holds the invocations of a function counted by provider
+// replicas which went away. Hostnames cannot contain an underscore so it is
+// never the shard of a replica.
+const foldedShard = "_folded"
+
+// foldAttempts is how often adding to a folded shard is tried when other
+// replicas fold at the same time
+const foldAttempts = 5
+
+// deadReplicaFlushes is how many flush intervals a replica may miss before
+// its shards are folded
+const deadReplicaFlushes = 10
+
+// InvocationCounter keeps the invocation counts of every provider replica in
+// etcd. Each replica flushes the invocations it served to its own shard under
+// /invocations/<function>/<replica>, along with the time of the flush to
+// /replicas/<replica>, and the shards are summed on read. The shards of
+// replicas which stopped flushing, such as pods which were replaced, are
+// folded into the _folded shard of their function, so the counts hold across
+// provider replicas and restarts whether or not a replica comes back under
+// the same name. SmartNIC functions are removed from etcd when the provider
+// starts, and their counts along with them.
+type InvocationCounter struct {
+	keysAPI Store
+	replica string
+
+	mutex sync.Mutex
+	// base is added to the invocations counted by this process, it holds
+	// the shards written before a restart
+	base    map[string]float64
+	flushed map[string]uint64
+}
+
+// NewInvocationCounter creates a counter which flushes to the shards of
+// replica, call Load before counting to carry on from a previous run.
+func NewInvocationCounter(keysAPI Store,
+	replica string) *InvocationCounter {
+	return &InvocationCounter{
+		keysAPI: keysAPI,
+		replica: replica,
+		base:    map[string]float64{},
+		flushed: map[string]uint64{},
+	}
+}
+
+// Load reads the shards this replica wrote before it restarted.
+func (c *InvocationCounter) Load() error {
+	opts := client.GetOptions{Recursive: true}
+	resp, err := c.keysAPI.Get(context.Background(), "/invocations", &opts)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, funcNode := range resp.Node.Nodes {
+		funcName := funcNode.Key[strings.LastIndex(funcNode.Key, "/")+1:]
+		for _, shardNode := range funcNode.Nodes {
+			if shardNode.Key[strings.LastIndex(shardNode.Key, "/")+1:] != c.replica {
+				continue
+			}
+			count, parseErr := strconv.ParseUint(shardNode.Value, 10, 64)
+			if parseErr != nil {
+				continue
+			}
+			c.base[funcName] = float64(count)
+			c.flushed[funcName] = count
+		}
+	}
+	return nil
+}
+
+// Run flushes the invocations to etcd at every interval and folds the shards
+// of replicas which missed deadReplicaFlushes flushes. This function is
+// blocking.
+func (c *InvocationCounter) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := c.Flush(); err != nil {
+			logging.Warn("Could not flush invocation counts", "error", err)
+		}
+		if err := c.Fold(deadReplicaFlushes * interval); err != nil {
+			logging.Warn("Could not fold invocation counts", "error", err)
+		}
+	}
+}
+
+// Flush writes the shard of every function invoked since the last flush.
+// A shard which was flushed before is only replaced while it holds what was
+// flushed. When it is gone it was folded or the function was deleted, and
+// the shard starts again from the invocations counted since.
+func (c *InvocationCounter) Flush() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, lastErr := c.keysAPI.Set(context.Background(), CreateReplicaKey(c.replica),
+		time.Now().UTC().Format(time.RFC3339Nano), nil)
+	for funcName, count := range invocationCounts() {
+		total := uint64(c.base[funcName] + count)
+		flushed, ok := c.flushed[funcName]
+		if flushed == total && (ok || total == 0) {
+			continue
+		}
+		key := CreateInvocationKey(funcName, c.replica)
+		var opts *client.SetOptions
+		if ok {
+			opts = &client.SetOptions{PrevValue: strconv.FormatUint(flushed, 10)}
+		}
+		_, err := c.keysAPI.Set(context.Background(), key,
+			strconv.FormatUint(total, 10), opts)
+		if client.IsKeyNotFound(err) {
+			c.base[funcName] -= float64(flushed)
+			total -= flushed
+			_, err = c.keysAPI.Set(context.Background(), key,
+				strconv.FormatUint(total, 10), nil)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		c.flushed[funcName] = total
+	}
+	return lastErr
+}
+
+// Fold adds the shards of replicas which have not flushed for timeout to the
+// folded shard of their function and deletes them. A shard is only deleted
+// while it holds the count which was read, so that it is folded once even
+// when other replicas fold at the same time.
+func (c *InvocationCounter) Fold(timeout time.Duration) error {
+	opts := client.GetOptions{Recursive: true}
+	resp, err := c.keysAPI.Get(context.Background(), "/invocations", &opts)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	heartbeats, err := GetReplicaHeartbeats(c.keysAPI)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	alive := func(replica string) bool {
+		heartbeat, ok := heartbeats[replica]
+		return replica == c.replica || ok && now.Sub(heartbeat) < timeout
+	}
+
+	var lastErr error
+	for _, funcNode := range resp.Node.Nodes {
+		funcName := funcNode.Key[strings.LastIndex(funcNode.Key, "/")+1:]
+		for _, shardNode := range funcNode.Nodes {
+			replica := shardNode.Key[strings.LastIndex(shardNode.Key, "/")+1:]
+			if replica == foldedShard || alive(replica) {
+				continue
+			}
+			if foldErr := foldShard(c.keysAPI, funcName, replica, shardNode.Value); foldErr != nil {
+				lastErr = foldErr
+				continue
+			}
+			logging.Info("Folded invocation counts of replica",
+				"function_name", funcName, "replica", replica, "count", shardNode.Value)
+		}
+	}
+	for replica, heartbeat := range heartbeats {
+		if alive(replica) {
+			continue
+		}
+		_, err = c.keysAPI.Delete(context.Background(), CreateReplicaKey(replica),
+			&client.DeleteOptions{PrevValue: heartbeat.Format(time.RFC3339Nano)})
+		if err != nil && !client.IsKeyNotFound(err) && !isCompareFailed(err) {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// foldShard deletes the shard of a replica if it still holds value and adds
+// its count to the folded shard of the function
+func foldShard(keysAPI Store, funcName string, replica string, value string) error {
+	count, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	_, err = keysAPI.Delete(context.Background(),
+		CreateInvocationKey(funcName, replica),
+		&client.DeleteOptions{PrevValue: value})
+	if err != nil {
+		// The replica flushed again or another one folded the shard first.
+		if client.IsKeyNotFound(err) || isCompareFailed(err) {
+			return nil
+		}
+		return err
+	}
+
+	key := CreateInvocationKey(funcName, foldedShard)
+	for attempt := 0; attempt < foldAttempts; attempt++ {
+		total := count
+		opts := &client.SetOptions{PrevExist: client.PrevNoExist}
+		resp, getErr := keysAPI.Get(context.Background(), key, nil)
+		if getErr == nil {
+			folded, _ := strconv.ParseUint(resp.Node.Value, 10, 64)
+			total += folded
+			opts = &client.SetOptions{PrevValue: resp.Node.Value}
+		} else if !client.IsKeyNotFound(getErr) {
+			return getErr
+		}
+		_, err = keysAPI.Set(context.Background(), key,
+			strconv.FormatUint(total, 10), opts)
+		if err == nil || !isCompareFailed(err) && !isNodeExist(err) &&
+			!client.IsKeyNotFound(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("could not fold %d invocations of %s: %s", count, funcName, err)
+}
+
+func isCompareFailed(err error) bool {
+	etcdErr, ok := err.(client.Error)
+	return ok && etcdErr.Code == client.ErrorCodeTestFailed
+}
+
+func isNodeExist(err error) bool {
+	etcdErr, ok := err.(client.Error)
+	return ok && etcdErr.Code == client.ErrorCodeNodeExist
+}
+
+// Count returns the invocations of a function served by every provider
+// replica. The shard of this replica is taken from its live count rather
+// than etcd, and only the live count is returned when etcd cannot be read.
+func (c *InvocationCounter) Count(functionName string) float64 {
+	c.mutex.Lock()
+	count := c.base[functionName] + invocationCount(functionName)
+	c.mutex.Unlock()
+
+	shards, err := GetInvocationShards(c.keysAPI, functionName)
+	if err != nil {
+		logging.Warn("Could not read invocation counts",
+			"function_name", functionName, "error", err)
+		return count
+	}
+	for replica, shard := range shards {
+		if replica != c.replica {
+			count += float64(shard)
+		}
+	}
+	return count
+}
+
+// Delete removes the invocation counts of a deleted function so that a
+// function deployed again under the same name starts from zero.
+func (c *InvocationCounter) Delete(functionName string) error {
+	c.mutex.Lock()
+	c.base[functionName] = -invocationCount(functionName)
+	delete(c.flushed, functionName)
+	c.mutex.Unlock()
+
+	return EtcdDeleteInvocations(c.keysAPI, functionName)
+}
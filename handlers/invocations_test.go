@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_InvocationCounter_SumsReplicaShards(t *testing.T) {
+	keysAPI := NewMemoryStore()
+	keysAPI.values[CreateInvocationKey("shard-lambdanic", "other")] = "5"
+	counter := NewInvocationCounter(keysAPI, "self")
+
+	observeInvocation("shard-lambdanic", backendSmartNIC, "10.0.0.1", time.Now(), false)
+	observeInvocation("shard-lambdanic", backendSmartNIC, "10.0.0.1", time.Now(), false)
+
+	if count := counter.Count("shard-lambdanic"); count != 7 {
+		t.Errorf("want: 7 invocations across replicas, got: %v", count)
+	}
+
+	if err := counter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if shard := keysAPI.values[CreateInvocationKey("shard-lambdanic", "self")]; shard != "2" {
+		t.Errorf("want shard of this replica: 2, got: %s", shard)
+	}
+}
+
+func Test_InvocationCounter_LoadCarriesOnAfterRestart(t *testing.T) {
+	keysAPI := NewMemoryStore()
+	keysAPI.values[CreateInvocationKey("restart-lambdanic", "self")] = "10"
+	counter := NewInvocationCounter(keysAPI, "self")
+	if err := counter.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	observeInvocation("restart-lambdanic", backendSmartNIC, "10.0.0.1", time.Now(), false)
+	if err := counter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if shard := keysAPI.values[CreateInvocationKey("restart-lambdanic", "self")]; shard != "11" {
+		t.Errorf("want shard carried on from before the restart: 11, got: %s", shard)
+	}
+	if count := counter.Count("restart-lambdanic"); count != 11 {
+		t.Errorf("want: 11 invocations, got: %v", count)
+	}
+}
+
+func Test_InvocationCounter_DeleteStartsFromZero(t *testing.T) {
+	keysAPI := NewMemoryStore()
+	keysAPI.values[CreateInvocationKey("deleted-lambdanic", "other")] = "3"
+	counter := NewInvocationCounter(keysAPI, "self")
+	observeInvocation("deleted-lambdanic", backendSmartNIC, "10.0.0.1", time.Now(), false)
+	if err := counter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := counter.Delete("deleted-lambdanic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := counter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if shards, _ := GetInvocationShards(keysAPI, "deleted-lambdanic"); len(shards) != 0 {
+		t.Errorf("want shards deleted, got: %v", shards)
+	}
+	if count := counter.Count("deleted-lambdanic"); count != 0 {
+		t.Errorf("want: 0 invocations after delete, got: %v", count)
+	}
+}
+
+func Test_InvocationCounter_FoldsShardsAfterRestartUnderNewHostname(t *testing.T) {
+	keysAPI := NewMemoryStore()
+	// The replica flushed under the hostname of a pod which was replaced.
+	keysAPI.values[CreateInvocationKey("rename-lambdanic", "faas-netes-abc")] = "2"
+	keysAPI.values[CreateReplicaKey("faas-netes-abc")] =
+		time.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano)
+
+	after := NewInvocationCounter(keysAPI, "faas-netes-xyz")
+	if err := after.Load(); err != nil {
+		t.Fatal(err)
+	}
+	observeInvocation("rename-lambdanic", backendSmartNIC, "10.0.0.1", time.Now(), false)
+	if err := after.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := after.Fold(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	shards, _ := GetInvocationShards(keysAPI, "rename-lambdanic")
+	if len(shards) != 2 || shards[foldedShard] != 2 || shards["faas-netes-xyz"] != 1 {
+		t.Errorf("want the shard of the old hostname folded, got: %v", shards)
+	}
+	if _, ok := keysAPI.values[CreateReplicaKey("faas-netes-abc")]; ok {
+		t.Errorf("want the heartbeat of the old hostname deleted")
+	}
+	if count := after.Count("rename-lambdanic"); count != 3 {
+		t.Errorf("want: 3 invocations across restarts, got: %v", count)
+	}
+
+	// Folding again does not count the shard twice.
+	if err := after.Fold(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if count := after.Count("rename-lambdanic"); count != 3 {
+		t.Errorf("want: 3 invocations after folding again, got: %v", count)
+	}
+}
+
+func Test_InvocationCounter_FlushAfterShardWasFolded(t *testing.T) {
+	keysAPI := NewMemoryStore()
+	counter := NewInvocationCounter(keysAPI, "slow")
+	observeInvocation("folded-lambdanic", backendSmartNIC, "10.0.0.1", time.Now(), false)
+	if err := counter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// Another replica takes this one for dead while it is still serving.
+	keysAPI.values[CreateReplicaKey("slow")] =
+		time.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano)
+	if err := NewInvocationCounter(keysAPI, "other").Fold(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	observeInvocation("folded-lambdanic", backendSmartNIC, "10.0.0.1", time.Now(), false)
+	if err := counter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	shards, _ := GetInvocationShards(keysAPI, "folded-lambdanic")
+	if shards[foldedShard] != 1 || shards["slow"] != 1 {
+		t.Errorf("want the shard to carry on from the folded count, got: %v", shards)
+	}
+	if count := counter.Count("folded-lambdanic"); count != 2 {
+		t.Errorf("want: 2 invocations, got: %v", count)
+	}
+}
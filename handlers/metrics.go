@@ -0,0 +1,274 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	backendSmartNIC  = "smartnic"
+	backendBareMetal = "baremetal"
+	backendContainer = "container"
+)
+
+// backendRequests counts the invocations served by each backend so that the
+// share of traffic taken by the overflow path of hybrid functions is visible
+var backendRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "faasnetes_backend_requests_total",
+		Help: "Invocations served by each backend of a function",
+	},
+	[]string{"function_name", "backend"},
+)
+
+// overflowRequests counts why invocations of hybrid functions were sent to
+// the container instead of a SmartNIC
+var overflowRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "faasnetes_overflow_requests_total",
+		Help: "Invocations of hybrid functions sent to the container by reason",
+	},
+	[]string{"function_name", "reason"},
+)
+
+// hedgedRequests counts the duplicate invocations sent to a second SmartNIC
+// and how many of them replied first, apart from the invocations themselves
+var hedgedRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "faasnetes_hedged_requests_total",
+		Help: "Duplicate invocations sent to a second SmartNIC by outcome",
+	},
+	[]string{"function_name", "outcome"},
+)
+
+// circuitState is one for the current state of the circuit breaker of each
+// SmartNIC and zero for the others
+var circuitState = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "faasnetes_smartnic_circuit_state",
+		Help: "State of the circuit breaker of each SmartNIC",
+	},
+	[]string{"smartnic", "state"},
+)
+
+// invocationLabels are the labels of the invocation statistics, smartnic is
+// empty for invocations served by a container
+var invocationLabels = []string{"function_name", "backend", "smartnic"}
+
+// invocationsTotal counts every invocation of a function once, by the backend
+// which served it or failed it
+var invocationsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "faasnetes_function_invocations_total",
+		Help: "Invocations of each function",
+	},
+	invocationLabels,
+)
+
+// invocationErrors counts the invocations which could not be served
+var invocationErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "faasnetes_function_invocation_errors_total",
+		Help: "Invocations of each function which failed",
+	},
+	invocationLabels,
+)
+
+// invocationDuration measures how long invocations took end to end
+var invocationDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "faasnetes_function_invocation_duration_seconds",
+		Help:    "Duration of the invocations of each function",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	},
+	invocationLabels,
+)
+
+// invocationsInflight is the number of invocations outstanding on a backend
+var invocationsInflight = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "faasnetes_function_invocations_inflight",
+		Help: "Invocations of each function currently outstanding",
+	},
+	invocationLabels,
+)
+
+// trackInflight counts an invocation as outstanding until the returned
+// function is called
+func trackInflight(functionName string, backend string, smartNIC string) func() {
+	gauge := invocationsInflight.WithLabelValues(functionName, backend, smartNIC)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// observeInvocation records an invocation which started at started once it
+// was served or failed
+func observeInvocation(functionName string, backend string, smartNIC string,
+	started time.Time, failed bool) {
+	invocationsTotal.WithLabelValues(functionName, backend, smartNIC).Inc()
+	invocationDuration.WithLabelValues(functionName, backend, smartNIC).
+		Observe(time.Since(started).Seconds())
+	if failed {
+		invocationErrors.WithLabelValues(functionName, backend, smartNIC).Inc()
+	}
+}
+
+// invocationCount sums the invocations of a function over every backend and
+// SmartNIC since the provider started
+func invocationCount(functionName string) float64 {
+	return invocationCounts()[functionName]
+}
+
+// invocationCounts sums the invocations of every function over every backend
+// and SmartNIC since the provider started
+func invocationCounts() map[string]float64 {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		invocationsTotal.Collect(metrics)
+		close(metrics)
+	}()
+
+	counts := map[string]float64{}
+	for metric := range metrics {
+		sample := dto.Metric{}
+		if err := metric.Write(&sample); err != nil {
+			continue
+		}
+		for _, label := range sample.GetLabel() {
+			if label.GetName() == "function_name" {
+				counts[label.GetValue()] += sample.GetCounter().GetValue()
+			}
+		}
+	}
+	return counts
+}
+
+const (
+	outcomeSuccess  = "success"
+	outcomeNotFound = "not_found"
+	outcomeError    = "error"
+)
+
+// controlPlaneBuckets cover etcd and Kubernetes API calls from a millisecond
+// to the request timeouts
+var controlPlaneBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// etcdDuration measures the calls made to etcd
+var etcdDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "faasnetes_etcd_request_duration_seconds",
+		Help:    "Duration of etcd calls by operation, keyspace and outcome",
+		Buckets: controlPlaneBuckets,
+	},
+	[]string{"operation", "keyspace", "outcome"},
+)
+
+// etcdErrors counts the etcd calls which failed, a missing key is not an error
+var etcdErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "faasnetes_etcd_request_errors_total",
+		Help: "etcd calls which failed by operation and keyspace",
+	},
+	[]string{"operation", "keyspace"},
+)
+
+// kubernetesDuration measures the calls made to the Kubernetes API
+var kubernetesDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "faasnetes_kubernetes_request_duration_seconds",
+		Help:    "Duration of Kubernetes API calls by operation and outcome",
+		Buckets: controlPlaneBuckets,
+	},
+	[]string{"operation", "outcome"},
+)
+
+// kubernetesErrors counts the Kubernetes API calls which failed, a missing
+// object is not an error
+var kubernetesErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "faasnetes_kubernetes_request_errors_total",
+		Help: "Kubernetes API calls which failed by operation",
+	},
+	[]string{"operation"},
+)
+
+// smartNICsGauge is the number of SmartNICs registered in etcd
+var smartNICsGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "faasnetes_smartnics",
+		Help: "SmartNICs registered in etcd",
+	},
+)
+
+// functionsGauge is the number of functions deployed on each backend as of
+// the last time they were listed
+var functionsGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "faasnetes_functions",
+		Help: "Functions deployed by backend",
+	},
+	[]string{"backend"},
+)
+
+// routingTableSize is the number of functions in the routing table
+var routingTableSize = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "faasnetes_routing_table_entries",
+		Help: "Functions in the SmartNIC routing table",
+	},
+)
+
+const (
+	scaleUp   = "up"
+	scaleDown = "down"
+)
+
+// scaleEvents counts the functions scaled by the provider itself, container
+// functions to and from zero, SmartNIC functions by the autoscaler and
+// functions by alerts
+var scaleEvents = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "faasnetes_function_scale_events_total",
+		Help: "Functions scaled by the provider by direction",
+	},
+	[]string{"function_name", "direction"},
+)
+
+// observeKubernetesCall records a Kubernetes API call which started at started
+func observeKubernetesCall(operation string, started time.Time, err error) {
+	outcome := outcomeSuccess
+	if err != nil {
+		outcome = outcomeError
+		if errors.IsNotFound(err) {
+			outcome = outcomeNotFound
+		} else {
+			kubernetesErrors.WithLabelValues(operation).Inc()
+		}
+	}
+	kubernetesDuration.WithLabelValues(operation, outcome).
+		Observe(time.Since(started).Seconds())
+}
+
+func init() {
+	prometheus.MustRegister(backendRequests)
+	prometheus.MustRegister(overflowRequests)
+	prometheus.MustRegister(hedgedRequests)
+	prometheus.MustRegister(circuitState)
+	prometheus.MustRegister(invocationsTotal)
+	prometheus.MustRegister(invocationErrors)
+	prometheus.MustRegister(invocationDuration)
+	prometheus.MustRegister(invocationsInflight)
+	prometheus.MustRegister(etcdDuration)
+	prometheus.MustRegister(etcdErrors)
+	prometheus.MustRegister(kubernetesDuration)
+	prometheus.MustRegister(kubernetesErrors)
+	prometheus.MustRegister(smartNICsGauge)
+	prometheus.MustRegister(functionsGauge)
+	prometheus.MustRegister(routingTableSize)
+	prometheus.MustRegister(scaleEvents)
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_invocationCount_SumsBackendsAndSmartNICs(t *testing.T) {
+	started := time.Now()
+	observeInvocation("count-lambdanic", backendSmartNIC, "10.0.0.1", started, false)
+	observeInvocation("count-lambdanic", backendSmartNIC, "10.0.0.2", started, true)
+	observeInvocation("count-lambdanic", backendContainer, "", started, false)
+	observeInvocation("other-lambdanic", backendSmartNIC, "10.0.0.1", started, false)
+
+	if count := invocationCount("count-lambdanic"); count != 3 {
+		t.Errorf("want: 3 invocations, got: %v", count)
+	}
+	if count := invocationCount("missing"); count != 0 {
+		t.Errorf("want: 0 invocations of unknown function, got: %v", count)
+	}
+}
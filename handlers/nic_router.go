@@ -0,0 +1,447 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// RoutingRandom sends invocations to a SmartNIC picked uniformly at random
+	RoutingRandom = "random"
+	// RoutingAdaptive sends invocations to the better of two SmartNICs picked
+	// at random, judged by their observed latency, error rate and load
+	RoutingAdaptive = "adaptive"
+)
+
+// routingAnnotation selects the routing mode of a function, overriding the
+// mode the provider was started with
+const routingAnnotation = "com.lambdanic.routing"
+
+const (
+	// ewmaWeight is the weight of a new observation in the moving averages
+	ewmaWeight = 0.2
+	// errorPenalty scales the cost of a SmartNIC with its error rate
+	errorPenalty = 10.0
+	// slowLatencyFactor is how many times slower than the fastest SmartNIC
+	// hosting the function an invocation can be before it counts as a failure
+	slowLatencyFactor = 4.0
+	// minBackoff is the backoff after the first consecutive failure, it
+	// doubles with every further failure up to maxBackoff
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+// nicStats are the moving averages of the invocations sent to a SmartNIC
+type nicStats struct {
+	latency      float64
+	errorRate    float64
+	samples      uint64
+	failures     uint
+	backoffUntil time.Time
+}
+
+func (s *nicStats) observe(latency time.Duration, failed bool, now time.Time) {
+	errorSample := 0.0
+	if failed {
+		errorSample = 1.0
+	}
+	if s.samples == 0 {
+		s.latency = latency.Seconds()
+		s.errorRate = errorSample
+	} else {
+		s.latency = ewmaWeight*latency.Seconds() + (1-ewmaWeight)*s.latency
+		s.errorRate = ewmaWeight*errorSample + (1-ewmaWeight)*s.errorRate
+	}
+	s.samples++
+
+	if !failed {
+		s.failures = 0
+		s.backoffUntil = time.Time{}
+		return
+	}
+	backoff := minBackoff << s.failures
+	if backoff > maxBackoff || backoff <= 0 {
+		backoff = maxBackoff
+	}
+	s.failures++
+	s.backoffUntil = now.Add(backoff)
+}
+
+// latencySamples are the most recent latencies of a function
+type latencySamples struct {
+	values [256]time.Duration
+	next   int
+	count  int
+}
+
+// minLatencySamples is how many invocations of a function must be seen
+// before its latency percentiles are used
+const minLatencySamples = 20
+
+// statsKey identifies the invocations of a function on a SmartNIC
+type statsKey struct {
+	functionName string
+	smartNIC     string
+}
+
+// SmartNICRouter chooses the SmartNIC which serves each invocation. It keeps
+// the invocations outstanding on every SmartNIC, moving averages of the
+// latency and error rate observed per SmartNIC and per function and a circuit
+// breaker per SmartNIC.
+type SmartNICRouter struct {
+	defaultMode   string
+	breakerConfig *CircuitBreakerConfig
+	now           func() time.Time
+	rnd           *rand.Rand
+
+	mutex         sync.Mutex
+	inflight      map[string]int
+	nicStats      map[string]*nicStats
+	functionStats map[statsKey]*nicStats
+	latencies     map[string]*latencySamples
+	breakers      map[string]*circuitBreaker
+}
+
+// NewSmartNICRouter creates a router which uses defaultMode for functions
+// which do not select a routing mode themselves. The circuit breakers never
+// open when breakerConfig is nil.
+func NewSmartNICRouter(defaultMode string,
+	breakerConfig *CircuitBreakerConfig) *SmartNICRouter {
+	if defaultMode != RoutingAdaptive {
+		defaultMode = RoutingRandom
+	}
+	if breakerConfig == nil {
+		breakerConfig = &CircuitBreakerConfig{}
+	}
+	return &SmartNICRouter{
+		defaultMode:   defaultMode,
+		breakerConfig: breakerConfig,
+		now:           time.Now,
+		inflight:      map[string]int{},
+		nicStats:      map[string]*nicStats{},
+		functionStats: map[statsKey]*nicStats{},
+		latencies:     map[string]*latencySamples{},
+		breakers:      map[string]*circuitBreaker{},
+	}
+}
+
+// SetClock replaces the clock of the router, the simulator uses it to route
+// on simulated time.
+func (r *SmartNICRouter) SetClock(now func() time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.now = now
+}
+
+// SetRand replaces the source the router picks SmartNICs from, the
+// simulator seeds it so that runs can be repeated. The default source is
+// used when rnd is nil.
+func (r *SmartNICRouter) SetRand(rnd *rand.Rand) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rnd = rnd
+}
+
+// intn returns a random number in [0, n) from the source of the router, the
+// mutex of the router must be held
+func (r *SmartNICRouter) intn(n int) int {
+	if r.rnd != nil {
+		return r.rnd.Intn(n)
+	}
+	return rand.Intn(n)
+}
+
+// Select picks the SmartNIC which serves an invocation of a function in the
+// routing table. When none is returned the reason is, and hybrid functions
+// overflow to their container. A SmartNIC which is returned must be given
+// back with Release once the invocation completes, along with whether the
+// invocation is a probe of its circuit breaker.
+func (r *SmartNICRouter) Select(functionName string, routingTable *RoutingTable,
+	maxInflight int) (string, string, bool) {
+	route := routingTable.lookup(functionName)
+	return r.selectSmartNIC(functionName, route, routingTable, maxInflight,
+		isHybridFunction(route.meta.Annotations))
+}
+
+// Release records the outcome of an invocation sent to a SmartNIC returned by
+// Select and frees its slot.
+func (r *SmartNICRouter) Release(functionName string, smartNIC string,
+	probe bool, latency time.Duration, err error) {
+	r.release(functionName, smartNIC, probe, latency, err)
+}
+
+// modeOf returns the routing mode selected by the annotations of a function
+func (r *SmartNICRouter) modeOf(annotations map[string]string) string {
+	switch annotations[routingAnnotation] {
+	case RoutingRandom:
+		return RoutingRandom
+	case RoutingAdaptive:
+		return RoutingAdaptive
+	}
+	return r.defaultMode
+}
+
+// selectSmartNIC picks a healthy SmartNIC with spare capacity and a closed
+// circuit which hosts the function. When there is none, hybrid functions get
+// the reason back so that the invocation can overflow to their container,
+// while functions which only run on SmartNICs are sent to any SmartNIC hosting
+// them whose circuit is not open. A SmartNIC which is returned must be given
+// back with release once the invocation completes, along with whether the
+// invocation is a probe of its circuit breaker.
+func (r *SmartNICRouter) selectSmartNIC(functionName string,
+	route functionRoute, routingTable *RoutingTable,
+	maxInflight int, hybrid bool) (string, string, bool) {
+	candidates := route.smartNICs
+	if len(candidates) == 0 && !hybrid {
+		candidates = routingTable.registeredSmartNICs()
+	}
+	if len(candidates) == 0 {
+		return "", overflowUnavailable, false
+	}
+	adaptive := r.modeOf(route.meta.Annotations) == RoutingAdaptive
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	eligible, reason := r.eligibleSmartNICs(functionName, candidates,
+		routingTable, maxInflight, adaptive, "")
+
+	var smartNIC string
+	switch {
+	case len(eligible) > 0 && adaptive:
+		smartNIC = r.powerOfTwoChoices(functionName, eligible)
+	case len(eligible) > 0:
+		smartNIC = eligible[r.intn(len(eligible))]
+	case hybrid:
+		return "", reason, false
+	default:
+		closed := r.availableSmartNICs(candidates)
+		if len(closed) == 0 {
+			return "", overflowCircuitOpen, false
+		}
+		smartNIC = closed[r.intn(len(closed))]
+	}
+	r.inflight[smartNIC]++
+	return smartNIC, "", r.breakerOf(smartNIC).selected(r.now())
+}
+
+// selectHedge picks a second SmartNIC for a hedged invocation of a function
+// already sent to first, it returns an empty string when there is none. A
+// SmartNIC which is returned must be given back with release.
+func (r *SmartNICRouter) selectHedge(functionName string,
+	route functionRoute, routingTable *RoutingTable,
+	maxInflight int, first string) (string, bool) {
+	adaptive := r.modeOf(route.meta.Annotations) == RoutingAdaptive
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	eligible, _ := r.eligibleSmartNICs(functionName, route.smartNICs,
+		routingTable, maxInflight, adaptive, first)
+	if len(eligible) == 0 {
+		return "", false
+	}
+	smartNIC := eligible[r.intn(len(eligible))]
+	if adaptive {
+		smartNIC = r.powerOfTwoChoices(functionName, eligible)
+	}
+	r.inflight[smartNIC]++
+	return smartNIC, r.breakerOf(smartNIC).selected(r.now())
+}
+
+// eligibleSmartNICs filters out the candidates which are unhealthy, in
+// backoff, saturated or whose circuit is open along with the exclude
+// SmartNIC. When none are left the reason is returned.
+func (r *SmartNICRouter) eligibleSmartNICs(functionName string,
+	candidates []string, routingTable *RoutingTable, maxInflight int,
+	adaptive bool, exclude string) ([]string, string) {
+	now := r.now()
+	reason := overflowUnhealthy
+	eligible := []string{}
+	for _, smartNIC := range candidates {
+		if smartNIC == exclude ||
+			routingTable.healthOf(smartNIC) == SmartNICUnhealthy {
+			continue
+		}
+		if adaptive && now.Before(r.statsOf(functionName, smartNIC).backoffUntil) {
+			continue
+		}
+		if !r.breakerOf(smartNIC).available(now) {
+			if reason == overflowUnhealthy {
+				reason = overflowCircuitOpen
+			}
+			continue
+		}
+		if maxInflight > 0 && r.inflight[smartNIC] >= maxInflight {
+			reason = overflowSaturated
+			continue
+		}
+		eligible = append(eligible, smartNIC)
+	}
+	return eligible, reason
+}
+
+// availableSmartNICs filters out the candidates whose circuit is open
+func (r *SmartNICRouter) availableSmartNICs(candidates []string) []string {
+	now := r.now()
+	available := []string{}
+	for _, smartNIC := range candidates {
+		if r.breakerOf(smartNIC).available(now) {
+			available = append(available, smartNIC)
+		}
+	}
+	return available
+}
+
+// breakerOf returns the circuit breaker of a SmartNIC
+func (r *SmartNICRouter) breakerOf(smartNIC string) *circuitBreaker {
+	breaker, ok := r.breakers[smartNIC]
+	if !ok {
+		breaker = newCircuitBreaker(smartNIC, r.breakerConfig)
+		r.breakers[smartNIC] = breaker
+	}
+	return breaker
+}
+
+// circuitState returns the state of the circuit breaker of a SmartNIC
+func (r *SmartNICRouter) circuitState(smartNIC string) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if breaker, ok := r.breakers[smartNIC]; ok {
+		return breaker.stateAt(r.now())
+	}
+	return CircuitClosed
+}
+
+// release records the outcome of an invocation sent to a SmartNIC returned by
+// selectSmartNIC or selectHedge and frees its slot. Invocations which were
+// cancelled because another reply won are not recorded.
+func (r *SmartNICRouter) release(functionName string, smartNIC string,
+	probe bool, latency time.Duration, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.inflight[smartNIC]--
+	if err == context.Canceled {
+		r.breakerOf(smartNIC).cancelled(probe)
+		return
+	}
+	now := r.now()
+	r.breakerOf(smartNIC).record(err != nil, probe, now)
+	if err == nil {
+		r.recordLatency(functionName, latency)
+	}
+
+	functionStats, ok := r.functionStats[statsKey{functionName, smartNIC}]
+	if !ok {
+		functionStats = &nicStats{}
+		r.functionStats[statsKey{functionName, smartNIC}] = functionStats
+	}
+	failed := err != nil || r.isSlow(functionName, latency)
+	functionStats.observe(latency, failed, now)
+
+	stats, ok := r.nicStats[smartNIC]
+	if !ok {
+		stats = &nicStats{}
+		r.nicStats[smartNIC] = stats
+	}
+	stats.observe(latency, err != nil, now)
+}
+
+// recordLatency keeps the latency of a successful invocation of a function
+// in a fixed size ring of the most recent ones
+func (r *SmartNICRouter) recordLatency(functionName string, latency time.Duration) {
+	samples, ok := r.latencies[functionName]
+	if !ok {
+		samples = &latencySamples{}
+		r.latencies[functionName] = samples
+	}
+	samples.values[samples.next] = latency
+	samples.next = (samples.next + 1) % len(samples.values)
+	if samples.count < len(samples.values) {
+		samples.count++
+	}
+}
+
+// latencyPercentile returns the latency below which the given percentage of
+// the recent invocations of a function completed. It returns false until
+// enough invocations have been seen.
+func (r *SmartNICRouter) latencyPercentile(functionName string,
+	percentile float64) (time.Duration, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	samples, ok := r.latencies[functionName]
+	if !ok || samples.count < minLatencySamples {
+		return 0, false
+	}
+	sorted := make([]time.Duration, samples.count)
+	copy(sorted, samples.values[:samples.count])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(math.Ceil(percentile/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	} else if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx], true
+}
+
+// isSlow returns true when the latency is far above that of the fastest
+// SmartNIC hosting the function
+func (r *SmartNICRouter) isSlow(functionName string, latency time.Duration) bool {
+	fastest := 0.0
+	for key, stats := range r.functionStats {
+		if key.functionName != functionName || stats.samples == 0 {
+			continue
+		}
+		if fastest == 0 || stats.latency < fastest {
+			fastest = stats.latency
+		}
+	}
+	return fastest > 0 && latency.Seconds() > slowLatencyFactor*fastest
+}
+
+// statsOf returns the statistics of a function on a SmartNIC, falling back
+// to those of the SmartNIC when the function has not been sent there yet
+func (r *SmartNICRouter) statsOf(functionName string, smartNIC string) nicStats {
+	if stats, ok := r.functionStats[statsKey{functionName, smartNIC}]; ok {
+		return *stats
+	}
+	if stats, ok := r.nicStats[smartNIC]; ok {
+		return *stats
+	}
+	return nicStats{}
+}
+
+// cost estimates how long an invocation sent to the SmartNIC will take
+func (r *SmartNICRouter) cost(functionName string, smartNIC string) float64 {
+	stats := r.statsOf(functionName, smartNIC)
+	return stats.latency * float64(r.inflight[smartNIC]+1) *
+		(1 + errorPenalty*stats.errorRate)
+}
+
+// powerOfTwoChoices picks two SmartNICs at random and returns the cheaper one
+func (r *SmartNICRouter) powerOfTwoChoices(functionName string,
+	smartNICs []string) string {
+	if len(smartNICs) == 1 {
+		return smartNICs[0]
+	}
+	i := r.intn(len(smartNICs))
+	j := r.intn(len(smartNICs) - 1)
+	if j >= i {
+		j++
+	}
+	first, second := smartNICs[i], smartNICs[j]
+	if r.cost(functionName, second) < r.cost(functionName, first) {
+		return second
+	}
+	return first
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/types"
+)
+
+var errTest = errors.New("test error")
+
+func newTestRoutingTable(health map[string]string) *RoutingTable {
+	routingTable := NewRoutingTable(nil)
+	routingTable.health = health
+	for smartNIC := range health {
+		routingTable.smartNICs = append(routingTable.smartNICs, smartNIC)
+	}
+	return routingTable
+}
+
+func Test_selectSmartNIC_SkipsUnhealthy(t *testing.T) {
+	routingTable := newTestRoutingTable(map[string]string{
+		"10.0.0.1": SmartNICUnhealthy,
+		"10.0.0.2": SmartNICHealthy,
+	})
+	route := functionRoute{smartNICs: []string{"10.0.0.1", "10.0.0.2"}}
+
+	for i := 0; i < 10; i++ {
+		smartNIC, reason, _ := NewSmartNICRouter(RoutingRandom, nil).selectSmartNIC("echo-lambdanic", route, routingTable, 0, true)
+		if smartNIC != "10.0.0.2" || len(reason) > 0 {
+			t.Fatalf("want: 10.0.0.2, got: %s reason: %s", smartNIC, reason)
+		}
+	}
+}
+
+func Test_selectSmartNIC_HybridOverflowsWhenSaturated(t *testing.T) {
+	routingTable := newTestRoutingTable(map[string]string{
+		"10.0.0.1": SmartNICHealthy,
+	})
+	route := functionRoute{
+		smartNICs: []string{"10.0.0.1"},
+		meta: types.FunctionMeta{
+			Annotations: map[string]string{hybridAnnotation: "true"},
+		},
+	}
+	router := NewSmartNICRouter(RoutingRandom, nil)
+
+	smartNIC, reason, _ := router.selectSmartNIC("echo-lambdanic", route, routingTable, 1, true)
+	if smartNIC != "10.0.0.1" {
+		t.Fatalf("want: 10.0.0.1, got: %s reason: %s", smartNIC, reason)
+	}
+
+	smartNIC, reason, _ = router.selectSmartNIC("echo-lambdanic", route, routingTable, 1, true)
+	if len(smartNIC) > 0 || reason != overflowSaturated {
+		t.Errorf("want overflow: %s, got: %s reason: %s", overflowSaturated, smartNIC, reason)
+	}
+
+	router.release("echo-lambdanic", "10.0.0.1", false, time.Millisecond, nil)
+	smartNIC, reason, _ = router.selectSmartNIC("echo-lambdanic", route, routingTable, 1, true)
+	if smartNIC != "10.0.0.1" {
+		t.Errorf("want: 10.0.0.1 after release, got: %s reason: %s", smartNIC, reason)
+	}
+}
+
+func Test_selectSmartNIC_NICOnlyIgnoresSaturation(t *testing.T) {
+	routingTable := newTestRoutingTable(map[string]string{
+		"10.0.0.1": SmartNICUnhealthy,
+	})
+	route := functionRoute{smartNICs: []string{"10.0.0.1"}}
+
+	smartNIC, reason, _ := NewSmartNICRouter(RoutingRandom, nil).selectSmartNIC("echo-lambdanic", route, routingTable, 1, false)
+	if smartNIC != "10.0.0.1" || len(reason) > 0 {
+		t.Errorf("want: 10.0.0.1, got: %s reason: %s", smartNIC, reason)
+	}
+}
+
+func Test_selectSmartNIC_HybridWithoutPlacementOverflows(t *testing.T) {
+	routingTable := newTestRoutingTable(map[string]string{
+		"10.0.0.1": SmartNICHealthy,
+	})
+
+	smartNIC, reason, _ := NewSmartNICRouter(RoutingRandom, nil).selectSmartNIC("echo-lambdanic", functionRoute{}, routingTable, 0, true)
+	if len(smartNIC) > 0 || reason != overflowUnavailable {
+		t.Errorf("want overflow: %s, got: %s reason: %s", overflowUnavailable, smartNIC, reason)
+	}
+
+	smartNIC, reason, _ = NewSmartNICRouter(RoutingRandom, nil).selectSmartNIC("echo-lambdanic", functionRoute{}, routingTable, 0, false)
+	if smartNIC != "10.0.0.1" {
+		t.Errorf("want any registered SmartNIC, got: %s reason: %s", smartNIC, reason)
+	}
+}
+
+func Test_modeOf_AnnotationOverridesDefault(t *testing.T) {
+	router := NewSmartNICRouter(RoutingRandom, nil)
+
+	if mode := router.modeOf(nil); mode != RoutingRandom {
+		t.Errorf("want default mode: %s, got: %s", RoutingRandom, mode)
+	}
+	if mode := router.modeOf(map[string]string{routingAnnotation: RoutingAdaptive}); mode != RoutingAdaptive {
+		t.Errorf("want annotated mode: %s, got: %s", RoutingAdaptive, mode)
+	}
+	if mode := router.modeOf(map[string]string{routingAnnotation: "unknown"}); mode != RoutingRandom {
+		t.Errorf("want default mode for unknown annotation: %s, got: %s", RoutingRandom, mode)
+	}
+}
+
+func Test_selectSmartNIC_AdaptivePrefersFasterSmartNIC(t *testing.T) {
+	routingTable := newTestRoutingTable(map[string]string{
+		"10.0.0.1": SmartNICHealthy,
+		"10.0.0.2": SmartNICHealthy,
+	})
+	route := functionRoute{smartNICs: []string{"10.0.0.1", "10.0.0.2"}}
+	router := NewSmartNICRouter(RoutingAdaptive, nil)
+	router.release("echo-lambdanic", "10.0.0.1", false, 10*time.Millisecond, nil)
+	router.release("echo-lambdanic", "10.0.0.2", false, 1*time.Millisecond, nil)
+	router.inflight = map[string]int{}
+
+	for i := 0; i < 10; i++ {
+		smartNIC, _, _ := router.selectSmartNIC("echo-lambdanic", route, routingTable, 0, false)
+		if smartNIC != "10.0.0.2" {
+			t.Fatalf("want faster SmartNIC: 10.0.0.2, got: %s", smartNIC)
+		}
+		router.inflight[smartNIC]--
+	}
+}
+
+func Test_selectSmartNIC_AdaptiveBacksOffFailingSmartNIC(t *testing.T) {
+	routingTable := newTestRoutingTable(map[string]string{
+		"10.0.0.1": SmartNICHealthy,
+	})
+	route := functionRoute{
+		smartNICs: []string{"10.0.0.1"},
+		meta: types.FunctionMeta{
+			Annotations: map[string]string{routingAnnotation: RoutingAdaptive},
+		},
+	}
+	now := time.Now()
+	router := NewSmartNICRouter(RoutingRandom, nil)
+	router.now = func() time.Time { return now }
+
+	router.inflight["10.0.0.1"]++
+	router.release("echo-lambdanic", "10.0.0.1", false, time.Second, errTest)
+	if smartNIC, _, _ := router.selectSmartNIC("echo-lambdanic", route, routingTable, 0, true); len(smartNIC) > 0 {
+		t.Fatalf("want SmartNIC in backoff to be skipped, got: %s", smartNIC)
+	}
+
+	router.inflight["10.0.0.1"]++
+	router.release("echo-lambdanic", "10.0.0.1", false, time.Second, errTest)
+	now = now.Add(minBackoff)
+	if smartNIC, _, _ := router.selectSmartNIC("echo-lambdanic", route, routingTable, 0, true); len(smartNIC) > 0 {
+		t.Fatalf("want backoff to double after second failure, got: %s", smartNIC)
+	}
+
+	now = now.Add(2 * minBackoff)
+	if smartNIC, _, _ := router.selectSmartNIC("echo-lambdanic", route, routingTable, 0, true); smartNIC != "10.0.0.1" {
+		t.Errorf("want SmartNIC after backoff expires, got: %s", smartNIC)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+const (
+	// PlacementRandom places each replica of a function on a SmartNIC
+	// picked at random
+	PlacementRandom = "random"
+	// PlacementSpread spreads the replicas of a scaled function evenly over
+	// every SmartNIC
+	PlacementSpread = "spread"
+)
+
+// PlacementStrategies are the placement strategies known to PlaceReplicas
+var PlacementStrategies = []string{PlacementRandom, PlacementSpread}
+
+// Placement is how many replicas of a function each SmartNIC hosts
+type Placement map[string]uint64
+
+// PlaceReplicas decides which SmartNICs host the replicas of a function. It
+// does not read or write etcd so that placement can be simulated. Random
+// choices are taken from rnd, or from the default source when it is nil.
+func PlaceReplicas(strategy string, smartNICs []string, replicas uint64,
+	rnd *rand.Rand) (Placement, error) {
+	if len(smartNICs) == 0 {
+		return nil, fmt.Errorf("no SmartNICs to place %d replicas on", replicas)
+	}
+	intn := rand.Intn
+	if rnd != nil {
+		intn = rnd.Intn
+	}
+
+	placement := Placement{}
+	switch strategy {
+	case PlacementRandom:
+		for i := uint64(0); i < replicas; i++ {
+			placement[smartNICs[intn(len(smartNICs))]]++
+		}
+	case PlacementSpread:
+		// Every SmartNIC is given a count, clearing earlier placements.
+		perNIC := replicas / uint64(len(smartNICs))
+		remainder := replicas % uint64(len(smartNICs))
+		for i, smartNIC := range smartNICs {
+			placement[smartNIC] = perNIC
+			if uint64(i) < remainder {
+				placement[smartNIC]++
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown placement strategy: %s", strategy)
+	}
+	return placement, nil
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/Lambda-NIC/faas-netes/types"
+)
+
+func Test_PlaceReplicas_Spread(t *testing.T) {
+	placement, err := PlaceReplicas(PlacementSpread,
+		[]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Placement{"10.0.0.1": 2, "10.0.0.2": 1, "10.0.0.3": 1}
+	for smartNIC, numDeps := range want {
+		if placement[smartNIC] != numDeps {
+			t.Errorf("want %d replicas on %s, got: %d", numDeps, smartNIC, placement[smartNIC])
+		}
+	}
+
+	placement, _ = PlaceReplicas(PlacementSpread, []string{"10.0.0.1", "10.0.0.2"}, 1, nil)
+	if numDeps, ok := placement["10.0.0.2"]; !ok || numDeps != 0 {
+		t.Errorf("want spread to clear SmartNICs without replicas, got: %v", placement)
+	}
+}
+
+func Test_PlaceReplicas_RandomIsRepeatable(t *testing.T) {
+	smartNICs := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	first, err := PlaceReplicas(PlacementRandom, smartNICs, 5, rand.New(rand.NewSource(7)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := PlaceReplicas(PlacementRandom, smartNICs, 5, rand.New(rand.NewSource(7)))
+
+	total := uint64(0)
+	for smartNIC, numDeps := range first {
+		total += numDeps
+		if second[smartNIC] != numDeps {
+			t.Errorf("want the same placement for the same seed, got: %v and %v", first, second)
+		}
+	}
+	if total != 5 {
+		t.Errorf("want 5 replicas placed, got: %d", total)
+	}
+}
+
+func Test_PlaceReplicas_Errors(t *testing.T) {
+	if _, err := PlaceReplicas(PlacementSpread, nil, 1, nil); err == nil {
+		t.Errorf("want an error without SmartNICs")
+	}
+	if _, err := PlaceReplicas("binpack", []string{"10.0.0.1"}, 1, nil); err == nil {
+		t.Errorf("want an error for an unknown strategy")
+	}
+}
+
+func Test_UpdateReplicas_SpreadsInEtcd(t *testing.T) {
+	keysAPI := NewMemoryStore()
+	keysAPI.values["/smartnics/10.0.0.1"] = "10.0.0.1"
+	keysAPI.values["/smartnics/10.0.0.2"] = "10.0.0.2"
+
+	if err := UpdateReplicas(keysAPI, 3, "echo-lambdanic"); err != nil {
+		t.Fatal(err)
+	}
+	if got := keysAPI.values[CreateDepKey("10.0.0.1", "echo-lambdanic")]; got != "2" {
+		t.Errorf("want 2 replicas on 10.0.0.1, got: %s", got)
+	}
+	if got := keysAPI.values[CreateDepKey("10.0.0.2", "echo-lambdanic")]; got != "1" {
+		t.Errorf("want 1 replica on 10.0.0.2, got: %s", got)
+	}
+}
+
+func Test_RoutingTable_Load(t *testing.T) {
+	routingTable := NewRoutingTable(nil)
+	routingTable.Load([]string{"10.0.0.1", "10.0.0.2"},
+		map[string]string{"10.0.0.1": SmartNICHealthy, "10.0.0.2": SmartNICUnhealthy},
+		map[string]Placement{"echo-lambdanic": {"10.0.0.2": 1, "10.0.0.1": 0}},
+		map[string]types.FunctionMeta{
+			"echo-lambdanic": {Annotations: map[string]string{hybridAnnotation: "true"}},
+		})
+
+	route := routingTable.lookup("echo-lambdanic")
+	if len(route.smartNICs) != 1 || route.smartNICs[0] != "10.0.0.2" {
+		t.Errorf("want only SmartNICs with replicas routed to, got: %v", route.smartNICs)
+	}
+	if !isHybridFunction(route.meta.Annotations) {
+		t.Errorf("want the metadata loaded")
+	}
+
+	router := NewSmartNICRouter(RoutingRandom, nil)
+	if smartNIC, reason, _ := router.Select("echo-lambdanic", routingTable, 0); smartNIC != "" ||
+		reason != overflowUnhealthy {
+		t.Errorf("want the hybrid function to overflow from its unhealthy SmartNIC, got: %s %s",
+			smartNIC, reason)
+	}
+}
@@ -5,26 +5,83 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
-	"math/rand"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Lambda-NIC/faas-netes/logging"
+	"github.com/Lambda-NIC/faas-netes/recording"
+	"github.com/Lambda-NIC/faas-netes/tracing"
 	"github.com/Lambda-NIC/faas/gateway/requests"
 	"github.com/gorilla/mux"
-	"go.etcd.io/etcd/client"
+)
+
+// ProxyConfig specify options for the function proxy
+type ProxyConfig struct {
+	// NICTimeout is how long to wait for a SmartNIC to reply
+	NICTimeout time.Duration
+	// MaxInflightPerNIC is how many invocations may be outstanding on a
+	// SmartNIC before it is saturated, zero means there is no limit
+	MaxInflightPerNIC int
+	// HedgeDelay is how long hedged functions wait for a reply before
+	// sending a duplicate, until their latency percentile is known
+	HedgeDelay time.Duration
+	// Recorder appends every invocation to a trace, nil disables it
+	Recorder *recording.Recorder
+	// SmartNICPort and BareMetalPort are the UDP ports invocations are sent
+	// to, the defaults are used when they are zero
+	SmartNICPort  int
+	BareMetalPort int
+	// Transport sends invocations to function containers, nil uses a
+	// transport dialling the function service
+	Transport http.RoundTripper
+	// Scaler scales container functions up from zero replicas before they
+	// are invoked, nil disables it
+	Scaler *FunctionScaler
+	// WriteTimeout is the write timeout of the server, invocations held
+	// while their function is scaled from zero fail before it. Zero does
+	// not bound them.
+	WriteTimeout time.Duration
+}
+
+const (
+	// DefaultSmartNICPort is where SmartNICs listen for invocations
+	DefaultSmartNICPort = 4369
+	// DefaultBareMetalPort is where bare-metal servers listen for invocations
+	DefaultBareMetalPort = 10000
+)
+
+// ports returns the UDP ports of SmartNICs and bare-metal servers
+func (c *ProxyConfig) ports() (int, int) {
+	smartNICPort, bareMetalPort := c.SmartNICPort, c.BareMetalPort
+	if smartNICPort == 0 {
+		smartNICPort = DefaultSmartNICPort
+	}
+	if bareMetalPort == 0 {
+		bareMetalPort = DefaultBareMetalPort
+	}
+	return smartNICPort, bareMetalPort
+}
+
+const (
+	overflowUnavailable = "unavailable"
+	overflowUnhealthy   = "unhealthy"
+	overflowSaturated   = "saturated"
+	overflowTimeout     = "timeout"
+	overflowCircuitOpen = "circuit-open"
 )
 
 // MakeProxy creates a proxy for HTTP web requests which can be routed to a function.
-func MakeProxy(functionNamespace string, keysAPI client.KeysAPI,
+func MakeProxy(functionNamespace string, routingTable *RoutingTable,
+	router *SmartNICRouter,
 	timeout time.Duration,
-	smartNICs []string) http.HandlerFunc {
+	config *ProxyConfig) http.HandlerFunc {
 	proxyClient := http.Client{
 		Transport: &http.Transport{
 			Proxy: http.ProxyFromEnvironment,
@@ -38,9 +95,14 @@ func MakeProxy(functionNamespace string, keysAPI client.KeysAPI,
 			ExpectContinueTimeout: 1500 * time.Millisecond,
 		},
 	}
+	if config.Transport != nil {
+		proxyClient.Transport = config.Transport
+	}
+	holdTimeout := boundByWriteTimeout(config.WriteTimeout, config.WriteTimeout)
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("Got Proxy")
+		logger := logging.FromContext(r.Context())
+		logger.Debug("Got proxy request", "method", r.Method, "path", r.URL.Path)
 		if r.Body != nil {
 			defer r.Body.Close()
 		}
@@ -55,11 +117,36 @@ func MakeProxy(functionNamespace string, keysAPI client.KeysAPI,
 			vars := mux.Vars(r)
 			service := vars["name"]
 
-			stamp := strconv.FormatInt(time.Now().Unix(), 10)
+			started := time.Now()
+			logger = logger.With("function_name", service)
+
+			ctx, span := tracing.StartSpanFromRequest(r, "proxy "+service)
+			defer span.Finish()
+			span.SetAttribute("function_name", service)
+
+			record := recording.Record{
+				Time:     started,
+				CallID:   r.Header.Get(logging.CallIDHeader),
+				Function: service,
+				Method:   r.Method,
+			}
+			if config.Recorder != nil && r.Body != nil {
+				payload := &countingReader{ReadCloser: r.Body}
+				r.Body = payload
+				recorder := logging.NewStatusRecorder(w)
+				w = recorder
+				defer func() {
+					record.PayloadBytes = payload.n
+					record.Latency = time.Since(started).Seconds()
+					record.Status = recorder.Status
+					if err := config.Recorder.Record(record); err != nil {
+						logger.Warn("Could not record invocation", "error", err)
+					}
+				}()
+			}
 
 			defer func(when time.Time) {
-				seconds := time.Since(when).Seconds()
-				log.Printf("[%s] took %f seconds\n", stamp, seconds)
+				logger.Debug("Proxied invocation", "duration", time.Since(when))
 			}(time.Now())
 
 			forwardReq := requests.NewForwardRequest(r.Method, *r.URL)
@@ -68,6 +155,7 @@ func MakeProxy(functionNamespace string, keysAPI client.KeysAPI,
 
 			request, _ := http.NewRequest(r.Method, url, r.Body)
 			copyHeaders(&request.Header, &r.Header)
+			tracing.Inject(ctx, request.Header)
 
 			defer request.Body.Close()
 			var response *http.Response
@@ -79,40 +167,128 @@ func MakeProxy(functionNamespace string, keysAPI client.KeysAPI,
 			if isLambdaNIC || isBareMetal {
 				body, readErr := ioutil.ReadAll(r.Body)
 				if readErr != nil {
-					log.Printf("Error reading body: %v\n", err)
+					logger.Warn("Could not read body", "error", readErr)
+					writeHead(service, http.StatusBadRequest, w)
+					return
+				}
+				bodyStr := string(body)
+				jobID, jobIDErr := strconv.Atoi(bodyStr)
+				if jobIDErr != nil {
+					logger.Warn("Could not parse job ID", "error", jobIDErr)
 				} else {
-					bodyStr := string(body)
-					jobID, jobIDErr := strconv.Atoi(bodyStr)
-					if jobIDErr != nil {
-						log.Printf("Error paring job ID: %v\n", err)
-					}
-					// TODO: Send to smartNIC and wait or let it send response back?
-					//clientHeader := w.Header()
-					//copyHeaders(&clientHeader, &response.Header)
-					//writeHead(service, http.StatusOK, w)
-					//io.Copy(w, "Hello")
-					log.Println("Sending proxy for SmartNICs")
-					randIdx := rand.Intn(len(smartNICs))
-					addrStr := smartNICs[randIdx]
-					result := ""
-					if isLambdaNIC {
-						result = sendReceiveLambdaNic(addrStr, 4369, jobID,
-							"                ")
-					} else if isBareMetal {
-						result = sendReceiveLambdaNic(addrStr, 10000, jobID,
-							"                ")
+					record.JobID = &jobID
+				}
+
+				smartNICPort, bareMetalPort := config.ports()
+				port, backend := smartNICPort, backendSmartNIC
+				if isBareMetal {
+					port, backend = bareMetalPort, backendBareMetal
+				}
+
+				route := routingTable.lookup(service)
+				hybrid := isHybridFunction(route.meta.Annotations)
+				addrStr, reason, probe := router.selectSmartNIC(service, route,
+					routingTable, config.MaxInflightPerNIC, hybrid)
+
+				result := ""
+				if len(addrStr) > 0 {
+					logger.Debug("Sending invocation to SmartNIC", "smartnic", addrStr)
+					done := trackInflight(service, backend, addrStr)
+					var winner string
+					var nicErr error
+					result, winner, nicErr = invokeSmartNIC(ctx, router,
+						routingTable, config, service, route, addrStr, probe, port, jobID)
+					done()
+					if nicErr != nil {
+						reason = overflowTimeout
+					} else {
+						addrStr = winner
 					}
+				}
+
+				span.SetAttribute("backend", backend)
+				span.SetAttribute("smartnic", addrStr)
+				record.Backend, record.SmartNIC = backend, addrStr
+				if len(reason) > 0 {
+					span.SetAttribute("overflow_reason", reason)
+					record.OverflowReason = reason
+				}
+
+				switch {
+				case len(reason) == 0:
+					backendRequests.WithLabelValues(service, backend).Inc()
+					observeInvocation(service, backend, addrStr, started, false)
 					response = generateResponse(request, result)
+				case hybrid:
+					// LambdaNIC: Overflow to the container of a hybrid function.
+					overflowRequests.WithLabelValues(service, reason).Inc()
+					record.Backend, record.SmartNIC = backendContainer, ""
+					overflowReq, _ := http.NewRequest(r.Method, url, bytes.NewReader(body))
+					copyHeaders(&overflowReq.Header, &r.Header)
+					tracing.Inject(ctx, overflowReq.Header)
+					done := trackInflight(service, backendContainer, "")
+					response, err = proxyClient.Do(overflowReq)
+					done()
+					observeInvocation(service, backendContainer, "", started, err != nil)
+					span.SetError(err)
+					if err != nil {
+						logger.Error("Could not reach overflow container",
+							"overflow_reason", reason, "error", err)
+						writeHead(service, http.StatusInternalServerError, w)
+						buf := bytes.NewBufferString("Can't reach service: " + service)
+						w.Write(buf.Bytes())
+						return
+					}
+					defer response.Body.Close()
+					backendRequests.WithLabelValues(service, backendContainer).Inc()
+				case reason == overflowCircuitOpen:
+					// LambdaNIC: Fail fast while every SmartNIC circuit is open.
+					observeInvocation(service, backend, "", started, true)
+					writeHead(service, http.StatusServiceUnavailable, w)
+					buf := bytes.NewBufferString("SmartNICs unavailable for service: " + service)
+					w.Write(buf.Bytes())
+					return
+				default:
+					observeInvocation(service, backend, addrStr, started, true)
+					writeHead(service, http.StatusInternalServerError, w)
+					buf := bytes.NewBufferString("Can't reach SmartNIC for service: " + service)
+					w.Write(buf.Bytes())
+					return
 				}
 			} else {
+				span.SetAttribute("backend", backendContainer)
+				record.Backend = backendContainer
+				// LambdaNIC: Hold invocations of functions scaled to zero.
+				if config.Scaler != nil {
+					holdCtx := ctx
+					if holdTimeout > 0 {
+						var cancel context.CancelFunc
+						holdCtx, cancel = context.WithTimeout(ctx, holdTimeout)
+						defer cancel()
+					}
+					if err = config.Scaler.Ready(holdCtx, service); err != nil {
+						observeInvocation(service, backendContainer, "", started, true)
+						span.SetError(err)
+						logger.Error("Could not scale function from zero", "error", err)
+						writeHead(service, http.StatusServiceUnavailable, w)
+						buf := bytes.NewBufferString("Can't scale service: " + service)
+						w.Write(buf.Bytes())
+						return
+					}
+				}
+				done := trackInflight(service, backendContainer, "")
 				response, err = proxyClient.Do(request)
+				done()
+				observeInvocation(service, backendContainer, "", started, err != nil)
+				span.SetError(err)
 				if err != nil {
-					log.Println(err.Error())
+					logger.Error("Could not reach function", "error", err)
 					writeHead(service, http.StatusInternalServerError, w)
 					buf := bytes.NewBufferString("Can't reach service: " + service)
 					w.Write(buf.Bytes())
 					return
 				}
+				backendRequests.WithLabelValues(service, backendContainer).Inc()
 			}
 			copyHeaders(&clientHeader, &response.Header)
 			writeHead(service, http.StatusOK, w)
@@ -121,6 +297,107 @@ func MakeProxy(functionNamespace string, keysAPI client.KeysAPI,
 	}
 }
 
+const (
+	// hedgeAnnotation opts a SmartNIC function into hedged invocations
+	hedgeAnnotation = "com.lambdanic.hedge"
+	// hedgePercentileAnnotation is the latency percentile of the function
+	// after which the duplicate invocation is sent
+	hedgePercentileAnnotation = "com.lambdanic.hedge.percentile"
+	defaultHedgePercentile    = 95.0
+)
+
+// nicReply is the reply to an invocation sent to a SmartNIC
+type nicReply struct {
+	smartNIC string
+	result   string
+	err      error
+	hedge    bool
+}
+
+// hedgeDelay returns how long to wait for the reply of a SmartNIC before a
+// duplicate invocation is sent to a second one
+func hedgeDelay(router *SmartNICRouter, functionName string,
+	annotations map[string]string, fallback time.Duration) time.Duration {
+	percentile := defaultHedgePercentile
+	if value, ok := annotations[hedgePercentileAnnotation]; ok {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err == nil && parsed > 0 && parsed <= 100 {
+			percentile = parsed
+		}
+	}
+	if delay, ok := router.latencyPercentile(functionName, percentile); ok {
+		return delay
+	}
+	return fallback
+}
+
+// invokeSmartNIC sends an invocation to the SmartNIC picked by the router. For
+// functions which opt into hedging a duplicate is sent to a second SmartNIC
+// when the first has not replied within the hedge delay. The first successful
+// reply wins and the other invocation is cancelled. The SmartNIC which
+// replied is returned with the result. Each invocation is traced as a child
+// of the span carried by parent. Probe tells whether the invocation is a probe
+// of the circuit breaker of the SmartNIC.
+func invokeSmartNIC(parent context.Context, router *SmartNICRouter,
+	routingTable *RoutingTable, config *ProxyConfig, functionName string,
+	route functionRoute, smartNIC string, probe bool, port int,
+	jobID int) (string, string, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	replies := make(chan nicReply, 2)
+	send := func(smartNIC string, probe bool, hedge bool) {
+		_, span := tracing.StartSpan(ctx, "smartnic "+smartNIC)
+		span.SetAttribute("smartnic", smartNIC)
+		span.SetAttribute("hedge", strconv.FormatBool(hedge))
+		sent := time.Now()
+		result, err := sendReceiveLambdaNic(ctx, smartNIC, port, jobID,
+			nicFrameData(span), config.NICTimeout)
+		router.release(functionName, smartNIC, probe, time.Since(sent), err)
+		if err != context.Canceled {
+			span.SetError(err)
+		}
+		span.Finish()
+		replies <- nicReply{smartNIC: smartNIC, result: result, err: err, hedge: hedge}
+	}
+
+	go send(smartNIC, probe, false)
+	outstanding := 1
+
+	var hedgeTimer <-chan time.Time
+	if route.meta.Annotations[hedgeAnnotation] == "true" {
+		timer := time.NewTimer(hedgeDelay(router, functionName,
+			route.meta.Annotations, config.HedgeDelay))
+		defer timer.Stop()
+		hedgeTimer = timer.C
+	}
+
+	var lastErr error
+	for outstanding > 0 {
+		select {
+		case reply := <-replies:
+			outstanding--
+			if reply.err == nil {
+				if reply.hedge {
+					hedgedRequests.WithLabelValues(functionName, "won").Inc()
+				}
+				return reply.result, reply.smartNIC, nil
+			}
+			lastErr = reply.err
+		case <-hedgeTimer:
+			hedgeTimer = nil
+			second, secondProbe := router.selectHedge(functionName, route,
+				routingTable, config.MaxInflightPerNIC, smartNIC)
+			if len(second) > 0 {
+				hedgedRequests.WithLabelValues(functionName, "sent").Inc()
+				go send(second, secondProbe, true)
+				outstanding++
+			}
+		}
+	}
+	return "", "", lastErr
+}
+
 func writeHead(service string, code int, w http.ResponseWriter) {
 	w.WriteHeader(code)
 }
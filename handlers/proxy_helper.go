@@ -4,23 +4,46 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
 	"time"
+
+	"github.com/Lambda-NIC/faas-netes/logging"
+	"github.com/Lambda-NIC/faas-netes/tracing"
 )
 
-func sendReceiveLambdaNic(addrStr string,
-	port int, jobID int, data string) string {
+// nicProtocolVersions are the versions of the UDP protocol spoken with
+// SmartNICs and bare-metal servers. Both send the job ID as 4 big endian
+// bytes followed by a 16 byte data field, which is blank in version 1 and
+// holds the trace ID of traced invocations in version 2.
+var nicProtocolVersions = []string{"1", "2"}
+
+// nicFrameData returns the data field of the request frame sent to a
+// SmartNIC, the trace ID of the span when the invocation is traced
+func nicFrameData(span *tracing.Span) string {
+	if span == nil {
+		return "                "
+	}
+	traceID := span.Context().TraceID
+	return string(traceID[:])
+}
+
+// sendReceiveLambdaNic sends the job ID and data to a SmartNIC and waits up
+// to timeout for the reply, or until ctx is cancelled
+func sendReceiveLambdaNic(ctx context.Context, addrStr string,
+	port int, jobID int, data string, timeout time.Duration) (string, error) {
 	remoteUDPAddr := net.UDPAddr{IP: net.ParseIP(addrStr), Port: port}
+	logger := logging.FromContext(ctx).With("smartnic", addrStr)
 
 	//log.Printf("Connecting to server:%s \n", remoteUDPAddr.String())
 	conn, err := net.DialUDP("udp4", nil, &remoteUDPAddr)
 	if err != nil {
-		log.Printf("Error: UDP conn error: %v\n", err)
-		return ""
+		logger.Warn("Could not connect to SmartNIC", "error", err)
+		return "", err
 	}
 	defer conn.Close()
 
@@ -31,19 +54,32 @@ func sendReceiveLambdaNic(addrStr string,
 	dataBytes := append(bs, []byte(data)...)
 	_, err = conn.Write(dataBytes)
 	if err != nil {
-		log.Printf("Error in sending to server\n")
-		return ""
+		logger.Warn("Could not send to SmartNIC", "error", err)
+		return "", err
 	}
 	//log.Printf("Sent %d bytes to server:%s\n", n, remoteUDPAddr.String())
-	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Unblock the read below.
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
 	msg := make([]byte, 32)
 	n, err := conn.Read(msg)
 	if err != nil {
-		log.Printf("Error in receiving from server\n")
-		return ""
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		logger.Warn("Could not receive from SmartNIC", "error", err)
+		return "", err
 	}
 	//fmt.Printf("Message from server: %d bytes: %s\n", n, string(msg[:n]))
-	return string(msg[:n])
+	return string(msg[:n]), nil
 }
 
 func generateResponse(req *http.Request, body string) *http.Response {
@@ -60,3 +96,15 @@ func generateResponse(req *http.Request, body string) *http.Response {
 	}
 	return t
 }
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/logging"
+	"github.com/Lambda-NIC/faas-netes/recording"
+	"github.com/Lambda-NIC/faas-netes/tracing"
+	"github.com/Lambda-NIC/faas-netes/types"
+	"github.com/gorilla/mux"
+)
+
+// listenSmartNIC answers every invocation sent to addr with reply after delay
+func listenSmartNIC(t *testing.T, addr string, delay time.Duration, reply string) *net.UDPConn {
+	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenUDP("udp4", udpAddr)
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", addr, err)
+	}
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			_, remote, readErr := conn.ReadFromUDP(buf)
+			if readErr != nil {
+				return
+			}
+			time.Sleep(delay)
+			conn.WriteToUDP([]byte(reply), remote)
+		}
+	}()
+	return conn
+}
+
+func Test_invokeSmartNIC_HedgeWinsOverSlowSmartNIC(t *testing.T) {
+	slow := listenSmartNIC(t, "127.0.0.1:0", time.Second, "slow")
+	defer slow.Close()
+	port := slow.LocalAddr().(*net.UDPAddr).Port
+	fast := listenSmartNIC(t, fmt.Sprintf("127.0.0.2:%d", port), 0, "fast")
+	defer fast.Close()
+
+	routingTable := newTestRoutingTable(map[string]string{
+		"127.0.0.1": SmartNICHealthy,
+		"127.0.0.2": SmartNICHealthy,
+	})
+	route := functionRoute{
+		smartNICs: []string{"127.0.0.1", "127.0.0.2"},
+		meta: types.FunctionMeta{
+			Annotations: map[string]string{hedgeAnnotation: "true"},
+		},
+	}
+	config := &ProxyConfig{NICTimeout: 2 * time.Second, HedgeDelay: 20 * time.Millisecond}
+	router := NewSmartNICRouter(RoutingRandom, nil)
+	router.inflight["127.0.0.1"]++
+
+	started := time.Now()
+	result, smartNIC, err := invokeSmartNIC(context.Background(), router, routingTable, config, "echo-lambdanic",
+		route, "127.0.0.1", false, port, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "fast" || smartNIC != "127.0.0.2" {
+		t.Errorf("want reply from hedged SmartNIC: fast, got: %s from %s", result, smartNIC)
+	}
+	if took := time.Since(started); took > 500*time.Millisecond {
+		t.Errorf("want hedged reply before the slow SmartNIC, took: %s", took)
+	}
+}
+
+func Test_invokeSmartNIC_NoHedgeWithoutAnnotation(t *testing.T) {
+	slow := listenSmartNIC(t, "127.0.0.1:0", 100*time.Millisecond, "slow")
+	defer slow.Close()
+	port := slow.LocalAddr().(*net.UDPAddr).Port
+	fast := listenSmartNIC(t, fmt.Sprintf("127.0.0.2:%d", port), 0, "fast")
+	defer fast.Close()
+
+	routingTable := newTestRoutingTable(map[string]string{
+		"127.0.0.1": SmartNICHealthy,
+		"127.0.0.2": SmartNICHealthy,
+	})
+	route := functionRoute{smartNICs: []string{"127.0.0.1", "127.0.0.2"}}
+	config := &ProxyConfig{NICTimeout: 2 * time.Second, HedgeDelay: 10 * time.Millisecond}
+	router := NewSmartNICRouter(RoutingRandom, nil)
+	router.inflight["127.0.0.1"]++
+
+	result, _, err := invokeSmartNIC(context.Background(), router, routingTable, config, "echo-lambdanic",
+		route, "127.0.0.1", false, port, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "slow" {
+		t.Errorf("want reply from first SmartNIC: slow, got: %s", result)
+	}
+}
+
+func Test_latencyPercentile(t *testing.T) {
+	router := NewSmartNICRouter(RoutingRandom, nil)
+	if _, ok := router.latencyPercentile("echo-lambdanic", 95); ok {
+		t.Fatalf("want no percentile before %d samples", minLatencySamples)
+	}
+
+	for i := 1; i <= 100; i++ {
+		router.inflight["10.0.0.1"]++
+		router.release("echo-lambdanic", "10.0.0.1", false, time.Duration(i)*time.Millisecond, nil)
+	}
+
+	got, ok := router.latencyPercentile("echo-lambdanic", 95)
+	if !ok || got != 95*time.Millisecond {
+		t.Errorf("want p95: %s, got: %s", 95*time.Millisecond, got)
+	}
+}
+
+func Test_invokeSmartNIC_EmbedsTraceID(t *testing.T) {
+	exporter := tracing.NewMemoryExporter(16)
+	tracing.SetExporter(exporter)
+	defer tracing.SetExporter(nil)
+
+	udpAddr, _ := net.ResolveUDPAddr("udp4", "127.0.0.1:0")
+	conn, err := net.ListenUDP("udp4", udpAddr)
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	defer conn.Close()
+	frames := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, remote, readErr := conn.ReadFromUDP(buf)
+		if readErr != nil {
+			return
+		}
+		frames <- buf[:n]
+		conn.WriteToUDP([]byte("ok"), remote)
+	}()
+
+	routingTable := newTestRoutingTable(map[string]string{"127.0.0.1": SmartNICHealthy})
+	route := functionRoute{smartNICs: []string{"127.0.0.1"}}
+	config := &ProxyConfig{NICTimeout: 2 * time.Second}
+	router := NewSmartNICRouter(RoutingRandom, nil)
+	router.inflight["127.0.0.1"]++
+
+	ctx, span := tracing.StartSpan(context.Background(), "proxy")
+	_, _, err = invokeSmartNIC(ctx, router, routingTable, config, "echo-lambdanic",
+		route, "127.0.0.1", false, conn.LocalAddr().(*net.UDPAddr).Port, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	span.Finish()
+
+	frame := <-frames
+	traceID := span.Context().TraceID
+	if len(frame) != 20 || !bytes.Equal(frame[4:], traceID[:]) {
+		t.Errorf("want trace ID %s in the data field, got: %v", traceID, frame)
+	}
+	spans := exporter.Spans()
+	if len(spans) != 2 || spans[0].Name != "smartnic 127.0.0.1" || spans[0].ParentID != span.SpanID {
+		t.Errorf("want SmartNIC span as child of the proxy span, got: %v", spans)
+	}
+}
+
+func Test_MakeProxy_RecordsInvocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trace.jsonl")
+	recorder, err := recording.NewRecorder(recording.RecorderConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+
+	routingTable := newTestRoutingTable(map[string]string{})
+	proxy := MakeProxy("default", routingTable, NewSmartNICRouter(RoutingRandom, nil),
+		time.Second, &ProxyConfig{NICTimeout: time.Second, Recorder: recorder})
+
+	req := httptest.NewRequest(http.MethodPost, "/function/echo-lambdanic",
+		bytes.NewBufferString("42"))
+	req.Header.Set(logging.CallIDHeader, "call-1")
+	req = mux.SetURLVars(req, map[string]string{"name": "echo-lambdanic"})
+	rr := httptest.NewRecorder()
+	proxy(rr, req)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := recording.ReadRecords(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("want 1 record, got: %d", len(records))
+	}
+	record := records[0]
+	if record.Function != "echo-lambdanic" || record.CallID != "call-1" ||
+		record.Backend != backendSmartNIC || record.PayloadBytes != 2 ||
+		record.JobID == nil || *record.JobID != 42 ||
+		record.OverflowReason != overflowUnavailable ||
+		record.Status != http.StatusInternalServerError || rr.Code != record.Status {
+		t.Errorf("unexpected record: %+v", record)
+	}
+}
@@ -6,28 +6,35 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 
+	"github.com/Lambda-NIC/faas-netes/logging"
 	"github.com/Lambda-NIC/faas/gateway/requests"
-	"go.etcd.io/etcd/client"
-	v1beta1 "k8s.io/api/extensions/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 // MakeFunctionReader handler for reading functions deployed in the cluster as deployments.
+// The deployments are read from the cache once it is synced, when it is set.
 func MakeFunctionReader(functionNamespace string,
-	keysAPI client.KeysAPI,
-	clientset *kubernetes.Clientset) http.HandlerFunc {
+	keysAPI Store,
+	clientset kubernetes.Interface,
+	cache *FunctionCache,
+	counter *InvocationCounter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		keysAPI := traceKeysAPI(keysAPI, r.Context())
 
-		functions, err := getServiceList(functionNamespace, clientset)
+		functions, err := getServiceList(functionNamespace, clientset, cache)
+		if err == nil {
+			functionsGauge.WithLabelValues(backendContainer).Set(float64(len(functions)))
+		}
 
 		// Get the
 		smartNICFuncs, smartNICerr := GetFunctions(keysAPI)
 		if smartNICerr == nil {
+			functionsGauge.WithLabelValues(backendSmartNIC).Set(float64(len(smartNICFuncs)))
 			// print directory keys
 			for _, funcName := range smartNICFuncs {
 				numReps, numRepErr := GetNumDeployments(keysAPI, funcName)
@@ -39,20 +46,28 @@ func MakeFunctionReader(functionNamespace string,
 					Replicas:          numReps,
 					Image:             "smartnic",
 					AvailableReplicas: numReps,
-					InvocationCount:   0,
 				}
-				functions = append(functions, function)
+				if meta, metaErr := EtcdGetFunctionMeta(keysAPI, funcName); metaErr == nil {
+					function.Labels = &meta.Labels
+					function.Annotations = &meta.Annotations
+				}
+				functions = mergeHybridFunction(functions, function)
 			}
 		}
 
 		// If both types of services throw errors.
 		if err != nil && smartNICerr != nil {
-			log.Println(err)
+			logging.FromContext(r.Context()).Error("Could not list functions",
+				"error", err, "smartnic_error", smartNICerr)
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
 			return
 		}
 
+		for i := range functions {
+			functions[i].InvocationCount = counter.Count(functions[i].Name)
+		}
+
 		functionBytes, _ := json.Marshal(functions)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -60,21 +75,57 @@ func MakeFunctionReader(functionNamespace string,
 	}
 }
 
+// mergeHybridFunction adds a SmartNIC function to the list of functions. The
+// container of a hybrid function is already in the list under the same name,
+// so its replicas are added to those on the SmartNICs instead, and the
+// constraints it is deployed with are shown.
+func mergeHybridFunction(functions []requests.Function,
+	function requests.Function) []requests.Function {
+	for i, container := range functions {
+		if container.Name == function.Name {
+			function.Image = container.Image
+			function.Replicas += container.Replicas
+			function.AvailableReplicas += container.AvailableReplicas
+			if container.Annotations != nil {
+				if value, ok := (*container.Annotations)[constraintsAnnotation]; ok {
+					annotations := map[string]string{}
+					if function.Annotations != nil {
+						for k, v := range *function.Annotations {
+							annotations[k] = v
+						}
+					}
+					annotations[constraintsAnnotation] = value
+					function.Annotations = &annotations
+				}
+			}
+			functions[i] = function
+			return functions
+		}
+	}
+	return append(functions, function)
+}
+
 func getServiceList(functionNamespace string,
-	clientset *kubernetes.Clientset) ([]requests.Function, error) {
+	clientset kubernetes.Interface,
+	cache *FunctionCache) ([]requests.Function, error) {
 	functions := []requests.Function{}
 
 	listOpts := metav1.ListOptions{
-		LabelSelector: "faas_function",
+		LabelSelector: functionSelector,
 	}
 
-	res, err := clientset.ExtensionsV1beta1().Deployments(functionNamespace).List(listOpts)
-
+	var deployments []appsv1.Deployment
+	var err error
+	if cache != nil && cache.HasSynced() {
+		deployments, err = cache.Deployments()
+	} else {
+		deployments, err = listDeployments(clientset, functionNamespace, listOpts)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	for _, item := range res.Items {
+	for _, item := range deployments {
 		function := readFunction(item)
 		if function != nil {
 			functions = append(functions, *function)
@@ -85,12 +136,16 @@ func getServiceList(functionNamespace string,
 }
 
 // getService returns a function/service or nil if not found
-func getService(functionNamespace string, functionName string, clientset *kubernetes.Clientset) (*requests.Function, error) {
-
-	getOpts := metav1.GetOptions{}
-
-	item, err := clientset.ExtensionsV1beta1().Deployments(functionNamespace).Get(functionName, getOpts)
-
+func getService(functionNamespace string, functionName string, clientset kubernetes.Interface,
+	cache *FunctionCache) (*requests.Function, error) {
+
+	var item *appsv1.Deployment
+	var err error
+	if cache != nil && cache.HasSynced() {
+		item, err = cache.Deployment(functionName)
+	} else {
+		item, _, err = getDeployment(clientset, functionNamespace, functionName)
+	}
 	if err != nil {
 		if errors.IsNotFound(err) {
 			return nil, nil
@@ -110,7 +165,7 @@ func getService(functionNamespace string, functionName string, clientset *kubern
 	return nil, fmt.Errorf("function: %s not found", functionName)
 }
 
-func readFunction(item v1beta1.Deployment) *requests.Function {
+func readFunction(item appsv1.Deployment) *requests.Function {
 	var replicas uint64
 	if item.Spec.Replicas != nil {
 		replicas = uint64(*item.Spec.Replicas)
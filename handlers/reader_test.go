@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/Lambda-NIC/faas/gateway/requests"
+)
+
+func Test_mergeHybridFunction_AddsContainerReplicas(t *testing.T) {
+	functions := []requests.Function{
+		{Name: "figlet", Image: "functions/figlet", Replicas: 1, AvailableReplicas: 1},
+		{Name: "echo-lambdanic", Image: "functions/echo", Replicas: 2, AvailableReplicas: 1},
+	}
+	nicFunction := requests.Function{
+		Name:              "echo-lambdanic",
+		Image:             "smartnic",
+		Replicas:          4,
+		AvailableReplicas: 4,
+	}
+
+	functions = mergeHybridFunction(functions, nicFunction)
+
+	if len(functions) != 2 {
+		t.Fatalf("want: 2 functions, got: %d", len(functions))
+	}
+	merged := functions[1]
+	if merged.Replicas != 6 || merged.AvailableReplicas != 5 {
+		t.Errorf("want replicas: 6/5, got: %d/%d", merged.Replicas, merged.AvailableReplicas)
+	}
+	if merged.Image != "functions/echo" {
+		t.Errorf("want image: functions/echo, got: %s", merged.Image)
+	}
+}
+
+func Test_mergeHybridFunction_AppendsNICOnlyFunction(t *testing.T) {
+	functions := []requests.Function{
+		{Name: "figlet", Image: "functions/figlet", Replicas: 1},
+	}
+
+	functions = mergeHybridFunction(functions, requests.Function{Name: "echo-lambdanic", Image: "smartnic"})
+
+	if len(functions) != 2 || functions[1].Image != "smartnic" {
+		t.Errorf("want SmartNIC function appended, got: %v", functions)
+	}
+}
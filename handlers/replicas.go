@@ -6,25 +6,25 @@ package handlers
 import (
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strings"
 
+	"github.com/Lambda-NIC/faas-netes/logging"
 	"github.com/Lambda-NIC/faas-netes/types"
 	"github.com/Lambda-NIC/faas/gateway/requests"
 	"github.com/gorilla/mux"
-	"go.etcd.io/etcd/client"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 // MakeReplicaUpdater updates desired count of replicas
-func MakeReplicaUpdater(functionNamespace string, keysAPI client.KeysAPI,
-	clientset *kubernetes.Clientset) http.HandlerFunc {
+func MakeReplicaUpdater(functionNamespace string, keysAPI Store,
+	clientset kubernetes.Interface) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		keysAPI := traceKeysAPI(keysAPI, r.Context())
 
 		vars := mux.Vars(r)
 		functionName := vars["name"]
+		logger := logging.FromContext(r.Context()).With("function_name", functionName)
 
 		req := types.ScaleServiceRequest{}
 		if r.Body != nil {
@@ -35,44 +35,37 @@ func MakeReplicaUpdater(functionNamespace string, keysAPI client.KeysAPI,
 				w.WriteHeader(http.StatusBadRequest)
 				msg := "Cannot parse request. Please pass valid JSON."
 				w.Write([]byte(msg))
-				log.Println(msg, marshalErr)
+				logger.Warn(msg, "error", marshalErr)
 				return
 			}
 		}
-		if strings.Contains(functionName, "lambdanic") ||
-			strings.Contains(functionName, "baremetal") {
-			log.Printf("Updating replica for %s\n", functionName)
+		if isSmartNICFunction(functionName) {
+			logger.Info("Scaling SmartNIC function", "replicas", req.Replicas)
 			err := UpdateReplicas(keysAPI, req.Replicas, functionName)
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				w.Write([]byte("Unable to update function deployment " + functionName))
-				log.Println(err)
+				logger.Error("Could not scale SmartNIC function", "error", err)
 				return
 			}
 		} else {
-			options := metav1.GetOptions{
-				TypeMeta: metav1.TypeMeta{
-					Kind:       "Deployment",
-					APIVersion: "extensions/v1beta1",
-				},
-			}
-			deployment, err := clientset.ExtensionsV1beta1().Deployments(functionNamespace).Get(functionName, options)
+			deployment, legacy, err := getDeployment(clientset, functionNamespace, functionName)
 
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				w.Write([]byte("Unable to lookup function deployment " + functionName))
-				log.Println(err)
+				logger.Error("Could not look up deployment", "error", err)
 				return
 			}
 
 			replicas := int32(req.Replicas)
 			deployment.Spec.Replicas = &replicas
-			_, err = clientset.ExtensionsV1beta1().Deployments(functionNamespace).Update(deployment)
+			err = updateDeployment(clientset, functionNamespace, deployment, legacy)
 
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				w.Write([]byte("Unable to update function deployment " + functionName))
-				log.Println(err)
+				logger.Error("Could not scale deployment", "error", err)
 				return
 			}
 		}
@@ -81,11 +74,15 @@ func MakeReplicaUpdater(functionNamespace string, keysAPI client.KeysAPI,
 	}
 }
 
-// MakeReplicaReader reads the amount of replicas for a deployment
+// MakeReplicaReader reads the amount of replicas for a deployment, from the
+// cache once it is synced when it is set
 func MakeReplicaReader(functionNamespace string,
-	keysAPI client.KeysAPI,
-	clientset *kubernetes.Clientset) http.HandlerFunc {
+	keysAPI Store,
+	clientset kubernetes.Interface,
+	cache *FunctionCache,
+	counter *InvocationCounter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		keysAPI := traceKeysAPI(keysAPI, r.Context())
 		vars := mux.Vars(r)
 		functionName := vars["name"]
 		var function requests.Function
@@ -108,20 +105,32 @@ func MakeReplicaReader(functionNamespace string,
 				Replicas:          numReps,
 				Image:             "smartnic",
 				AvailableReplicas: numReps,
-				InvocationCount:   0,
+			}
+			if meta, metaErr := EtcdGetFunctionMeta(keysAPI, functionName); metaErr == nil {
+				function.Labels = &meta.Labels
+				function.Annotations = &meta.Annotations
+				// LambdaNIC: Count the container replicas of a hybrid function.
+				if isHybridFunction(meta.Annotations) {
+					container, _ := getService(functionNamespace, functionName, clientset, cache)
+					if container != nil {
+						function = mergeHybridFunction([]requests.Function{*container}, function)[0]
+					}
+				}
 			}
 		} else {
-			function, err := getService(functionNamespace, functionName, clientset)
+			container, err := getService(functionNamespace, functionName, clientset, cache)
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
 
-			if function == nil {
+			if container == nil {
 				w.WriteHeader(http.StatusNotFound)
 				return
 			}
+			function = *container
 		}
+		function.InvocationCount = counter.Count(functionName)
 
 		functionBytes, _ := json.Marshal(function)
 		w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,239 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// rolloutPollInterval is how often a deploy which waits looks at the
+	// function again
+	rolloutPollInterval = 100 * time.Millisecond
+	// RolloutTimeout is reported when the function was not ready in time
+	RolloutTimeout = "Timeout"
+	// RolloutProgressDeadlineExceeded is reported when Kubernetes gave up
+	// on the rollout of the Deployment
+	RolloutProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+)
+
+// rolloutFailures are the reasons pods wait for which fail a deploy right
+// away, as they rarely go away on their own
+var rolloutFailures = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// DeployStatus is the progress of a deploy which waits for the function to
+// be ready. The replicas count those of the container and the SmartNICs.
+type DeployStatus struct {
+	Function          string `json:"function"`
+	Ready             bool   `json:"ready"`
+	Replicas          uint64 `json:"replicas"`
+	AvailableReplicas uint64 `json:"availableReplicas"`
+	// PendingSmartNICs have not acknowledged the replicas placed on them
+	PendingSmartNICs []string `json:"pendingSmartNICs,omitempty"`
+	// Reason and Message tell why a function is not ready
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// parseWait reads the wait query parameter of a deploy: how long to wait for
+// the function to be ready, as a duration or seconds, true meaning
+// fallback. Zero means the deploy does not wait.
+func parseWait(value string, fallback time.Duration) (time.Duration, error) {
+	switch value {
+	case "", "false":
+		return 0, nil
+	case "true":
+		return fallback, nil
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid wait %q, want true, a duration or seconds", value)
+	}
+	return timeout, nil
+}
+
+// writeMargin is how long before the write timeout of the server a handler
+// stops waiting, to leave time to write its answer
+const writeMargin = time.Second
+
+// boundByWriteTimeout shortens a wait so that it ends before the write
+// timeout of the server cuts the connection, by writeMargin or half the
+// timeout when it is shorter. A zero write timeout leaves the wait as it is.
+func boundByWriteTimeout(wait time.Duration, writeTimeout time.Duration) time.Duration {
+	if writeTimeout <= 0 {
+		return wait
+	}
+	limit := writeTimeout - writeMargin
+	if limit < writeTimeout/2 {
+		limit = writeTimeout / 2
+	}
+	if wait > limit {
+		return limit
+	}
+	return wait
+}
+
+// waitForFunction waits until every replica of the container of a
+// function is available and every SmartNIC acknowledged its replicas, a pod
+// fails for one of the rolloutFailures or the timeout passes
+func waitForFunction(ctx context.Context, functionNamespace string,
+	keysAPI Store, clientset kubernetes.Interface, functionName string,
+	container bool, smartNIC bool, timeout time.Duration) DeployStatus {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(rolloutPollInterval)
+	defer ticker.Stop()
+
+	for {
+		status := DeployStatus{Function: functionName, Ready: true}
+		if container {
+			rolloutStatus(functionNamespace, clientset, functionName, &status)
+		}
+		if smartNIC && status.Reason == "" {
+			acknowledgedStatus(keysAPI, functionName, &status)
+		}
+		if status.Ready || status.Reason != "" {
+			return status
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			status.Reason = RolloutTimeout
+			status.Message = pendingMessage(status, timeout)
+			return status
+		}
+	}
+}
+
+// rolloutStatus adds the replicas of the Deployment of a function to the
+// status, and the reason it failed if it did
+func rolloutStatus(functionNamespace string, clientset kubernetes.Interface,
+	functionName string, status *DeployStatus) {
+	deployment, _, err := getDeployment(clientset, functionNamespace, functionName)
+	if err != nil {
+		status.Ready = false
+		status.Message = err.Error()
+		return
+	}
+	desired := uint64(replicaCount(deployment))
+	available := uint64(deployment.Status.AvailableReplicas)
+	status.Replicas += desired
+	status.AvailableReplicas += available
+	if available < desired || deployment.Status.ObservedGeneration < deployment.Generation {
+		status.Ready = false
+	}
+	if status.Ready {
+		return
+	}
+
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing &&
+			condition.Reason == RolloutProgressDeadlineExceeded {
+			status.Reason, status.Message = condition.Reason, condition.Message
+			return
+		}
+	}
+	status.Reason, status.Message = podFailure(functionNamespace, clientset, functionName)
+}
+
+// podFailure returns the reason and message of the first container of the
+// pods of a function waiting for one of the rolloutFailures
+func podFailure(functionNamespace string, clientset kubernetes.Interface,
+	functionName string) (string, string) {
+	started := time.Now()
+	pods, err := clientset.CoreV1().Pods(functionNamespace).List(metav1.ListOptions{
+		LabelSelector: "faas_function=" + functionName,
+	})
+	observeKubernetesCall("list_pods", started, err)
+	if err != nil {
+		return "", ""
+	}
+	for _, pod := range pods.Items {
+		statuses := append([]apiv1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+		statuses = append(statuses, pod.Status.ContainerStatuses...)
+		for _, containerStatus := range statuses {
+			waiting := containerStatus.State.Waiting
+			if waiting != nil && rolloutFailures[waiting.Reason] {
+				return waiting.Reason, fmt.Sprintf("pod %s: %s", pod.Name, waiting.Message)
+			}
+		}
+	}
+	return "", ""
+}
+
+// acknowledgedStatus adds the replicas of a function placed on the
+// SmartNICs to the status, and the SmartNICs which did not acknowledge them
+func acknowledgedStatus(keysAPI Store, functionName string, status *DeployStatus) {
+	placement, err := GetFunctionPlacement(keysAPI, functionName)
+	if err != nil || len(placement) == 0 {
+		status.Ready = false
+		if err != nil {
+			status.Message = err.Error()
+		}
+		return
+	}
+	smartNICs := make([]string, 0, len(placement))
+	for smartNIC := range placement {
+		smartNICs = append(smartNICs, smartNIC)
+	}
+	sort.Strings(smartNICs)
+
+	for _, smartNIC := range smartNICs {
+		replicas := placement[smartNIC]
+		status.Replicas += replicas
+		acks, ackErr := GetSmartNICAcks(keysAPI, smartNIC)
+		if ackErr == nil && acks[functionName] == replicas {
+			status.AvailableReplicas += replicas
+			continue
+		}
+		status.Ready = false
+		status.PendingSmartNICs = append(status.PendingSmartNICs, smartNIC)
+	}
+}
+
+// pendingMessage tells what a function was still waiting for
+func pendingMessage(status DeployStatus, timeout time.Duration) string {
+	message := fmt.Sprintf("%d of %d replicas available after %s",
+		status.AvailableReplicas, status.Replicas, timeout)
+	if len(status.PendingSmartNICs) > 0 {
+		message += ", waiting for SmartNICs " + strings.Join(status.PendingSmartNICs, ", ")
+	}
+	if len(status.Message) > 0 {
+		message += ": " + status.Message
+	}
+	return message
+}
+
+// statusCode returns the HTTP status a deploy which waited answers with
+func (s DeployStatus) statusCode() int {
+	switch {
+	case s.Ready:
+		return http.StatusOK
+	case s.Reason == RolloutTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
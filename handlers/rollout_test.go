@@ -0,0 +1,63 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_parseWait(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "false", want: 0},
+		{value: "true", want: time.Minute},
+		{value: "30", want: 30 * time.Second},
+		{value: "1m30s", want: 90 * time.Second},
+		{value: "0", want: 0},
+		{value: "soon", wantErr: true},
+		{value: "-5s", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseWait(test.value, time.Minute)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: want an error, got: %s", test.value, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%q: want %s, got: %s %v", test.value, test.want, got, err)
+		}
+	}
+}
+
+func Test_DeployStatus_statusCode(t *testing.T) {
+	tests := []struct {
+		status DeployStatus
+		want   int
+	}{
+		{status: DeployStatus{Ready: true}, want: http.StatusOK},
+		{status: DeployStatus{Reason: RolloutTimeout}, want: http.StatusGatewayTimeout},
+		{status: DeployStatus{Reason: "CrashLoopBackOff"}, want: http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		if got := test.status.statusCode(); got != test.want {
+			t.Errorf("%+v: want %d, got: %d", test.status, test.want, got)
+		}
+	}
+}
+
+func Test_pendingMessage(t *testing.T) {
+	status := DeployStatus{Replicas: 3, AvailableReplicas: 1,
+		PendingSmartNICs: []string{"127.0.0.1", "127.0.0.2"}}
+	want := "1 of 3 replicas available after 5s, waiting for SmartNICs 127.0.0.1, 127.0.0.2"
+	if got := pendingMessage(status, 5*time.Second); got != want {
+		t.Errorf("want %q, got: %q", want, got)
+	}
+}
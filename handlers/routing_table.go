@@ -0,0 +1,181 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/logging"
+	"github.com/Lambda-NIC/faas-netes/types"
+	"go.etcd.io/etcd/client"
+)
+
+// hybridAnnotation marks a SmartNIC function which is also deployed as a
+// container that takes the overflow when the SmartNICs cannot serve it
+const hybridAnnotation = "com.lambdanic.hybrid"
+
+// isSmartNICFunction returns true for functions deployed on SmartNICs or
+// bare-metal servers rather than as containers
+func isSmartNICFunction(functionName string) bool {
+	return strings.Contains(functionName, "lambdanic") ||
+		strings.Contains(functionName, "baremetal")
+}
+
+// isHybridFunction returns true when a SmartNIC function also has a container
+func isHybridFunction(annotations map[string]string) bool {
+	return annotations[hybridAnnotation] == "true"
+}
+
+// functionRoute is the routing table entry of a SmartNIC function
+type functionRoute struct {
+	// smartNICs hosting at least one replica of the function
+	smartNICs []string
+	meta      types.FunctionMeta
+}
+
+// RoutingTable caches the SmartNIC placement, health and function metadata
+// held in etcd so that the proxy does not query etcd on every invocation.
+type RoutingTable struct {
+	keysAPI Store
+
+	mutex     sync.RWMutex
+	smartNICs []string
+	health    map[string]string
+	routes    map[string]functionRoute
+}
+
+// NewRoutingTable creates an empty routing table, call Refresh or Run to
+// populate it from etcd.
+func NewRoutingTable(keysAPI Store) *RoutingTable {
+	return &RoutingTable{
+		keysAPI: keysAPI,
+		health:  map[string]string{},
+		routes:  map[string]functionRoute{},
+	}
+}
+
+// Run refreshes the routing table from etcd at every interval. This
+// function is blocking.
+func (t *RoutingTable) Run(interval time.Duration) {
+	for {
+		if err := t.Refresh(); err != nil {
+			logging.Warn("Could not refresh routing table", "error", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Refresh reloads the routing table from etcd.
+func (t *RoutingTable) Refresh() error {
+	smartNICs, err := GetSmartNICS(t.keysAPI)
+	if err != nil {
+		return err
+	}
+
+	routes := map[string]functionRoute{}
+	opts := client.GetOptions{Recursive: true}
+	resp, err := t.keysAPI.Get(context.Background(), "/deployments/smartnic", &opts)
+	if err != nil {
+		return err
+	}
+	for _, nicNode := range resp.Node.Nodes {
+		smartNIC := nicNode.Key[strings.LastIndex(nicNode.Key, "/")+1:]
+		for _, depNode := range nicNode.Nodes {
+			numDeps, numDepErr := strconv.ParseUint(depNode.Value, 10, 64)
+			if numDepErr != nil || numDeps == 0 {
+				continue
+			}
+			funcName := depNode.Key[strings.LastIndex(depNode.Key, "/")+1:]
+			route := routes[funcName]
+			route.smartNICs = append(route.smartNICs, smartNIC)
+			routes[funcName] = route
+		}
+	}
+
+	// Functions deployed before metadata was kept do not have any.
+	if resp, metaErr := t.keysAPI.Get(context.Background(), "/metadata", nil); metaErr == nil {
+		for _, metaNode := range resp.Node.Nodes {
+			funcName := metaNode.Key[strings.LastIndex(metaNode.Key, "/")+1:]
+			meta := types.FunctionMeta{}
+			if jsonErr := json.Unmarshal([]byte(metaNode.Value), &meta); jsonErr != nil {
+				continue
+			}
+			route := routes[funcName]
+			route.meta = meta
+			routes[funcName] = route
+		}
+	}
+
+	now := time.Now()
+	health := map[string]string{}
+	for _, smartNIC := range smartNICs {
+		health[smartNIC] = smartNICHealth(GetSmartNICHeartbeat(t.keysAPI, smartNIC), now)
+	}
+
+	t.set(smartNICs, health, routes)
+	return nil
+}
+
+// Load replaces the routing table with the given SmartNICs, their health and
+// the placement and metadata of every function, without reading etcd. The
+// simulator uses it to route on a simulated SmartNIC inventory.
+func (t *RoutingTable) Load(smartNICs []string, health map[string]string,
+	placements map[string]Placement, metas map[string]types.FunctionMeta) {
+	routes := map[string]functionRoute{}
+	for funcName, placement := range placements {
+		route := functionRoute{meta: metas[funcName]}
+		for smartNIC, numDeps := range placement {
+			if numDeps > 0 {
+				route.smartNICs = append(route.smartNICs, smartNIC)
+			}
+		}
+		routes[funcName] = route
+	}
+	t.set(smartNICs, health, routes)
+}
+
+func (t *RoutingTable) set(smartNICs []string, health map[string]string,
+	routes map[string]functionRoute) {
+	for funcName := range routes {
+		sort.Strings(routes[funcName].smartNICs)
+	}
+
+	t.mutex.Lock()
+	t.smartNICs = smartNICs
+	t.health = health
+	t.routes = routes
+	t.mutex.Unlock()
+
+	smartNICsGauge.Set(float64(len(smartNICs)))
+	routingTableSize.Set(float64(len(routes)))
+}
+
+// lookup returns the routing table entry of a function
+func (t *RoutingTable) lookup(functionName string) functionRoute {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.routes[functionName]
+}
+
+// registeredSmartNICs returns every SmartNIC registered in etcd
+func (t *RoutingTable) registeredSmartNICs() []string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.smartNICs
+}
+
+// healthOf returns the health of a SmartNIC as of the last refresh
+func (t *RoutingTable) healthOf(smartNIC string) string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	if health, ok := t.health[smartNIC]; ok {
+		return health
+	}
+	return SmartNICUnknown
+}
@@ -0,0 +1,293 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/logging"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// scaleZeroLabel opts a container function into being scaled to zero
+	// replicas once it is idle
+	scaleZeroLabel = "com.openfaas.scale.zero"
+	// scaleZeroDurationLabel is how long a function must be idle before it
+	// is scaled to zero, such as 30m, in place of the default
+	scaleZeroDurationLabel = "com.openfaas.scale.zero-duration"
+	// scalePollInterval is how often a function scaled from zero is checked
+	// for an available replica
+	scalePollInterval = 50 * time.Millisecond
+)
+
+// ScalingConfig specify how container functions are scaled to and from zero
+type ScalingConfig struct {
+	// IdleDuration is how long a function which opted in must go without
+	// invocations before it is scaled to zero, unless it sets its own
+	IdleDuration time.Duration
+	// ScaleFromZeroTimeout bounds how long invocations of a function with
+	// no replicas wait for it to be scaled up
+	ScaleFromZeroTimeout time.Duration
+}
+
+// FunctionScaler scales idle container functions to zero replicas and
+// scales them back up when they are invoked. A function is idle when the
+// invocations counted by every provider replica did not change for its idle
+// duration.
+type FunctionScaler struct {
+	namespace string
+	clientset kubernetes.Interface
+	cache     *FunctionCache
+	counter   *InvocationCounter
+	config    ScalingConfig
+
+	mutex sync.Mutex
+	// activity is the invocation count of each function and when it last
+	// changed
+	activity map[string]functionActivity
+	// wakes are the scale ups in progress, every invocation of a function
+	// waits for the same one
+	wakes map[string]*wake
+}
+
+type functionActivity struct {
+	count float64
+	at    time.Time
+}
+
+// wake is the scale up of a function from zero, err is set once done is
+// closed
+type wake struct {
+	done chan struct{}
+	err  error
+}
+
+// NewFunctionScaler creates a scaler of the functions in namespace. The
+// cache is read once it is synced, the API server otherwise, and may be nil.
+func NewFunctionScaler(functionNamespace string, clientset kubernetes.Interface,
+	cache *FunctionCache, counter *InvocationCounter,
+	config ScalingConfig) *FunctionScaler {
+	return &FunctionScaler{
+		namespace: functionNamespace,
+		clientset: clientset,
+		cache:     cache,
+		counter:   counter,
+		config:    config,
+		activity:  map[string]functionActivity{},
+		wakes:     map[string]*wake{},
+	}
+}
+
+// Run scales idle functions to zero at every interval. This function is
+// blocking.
+func (s *FunctionScaler) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := s.ScaleIdle(); err != nil {
+			logging.Warn("Could not scale idle functions", "error", err)
+		}
+	}
+}
+
+// ScaleIdle scales the functions which opted in to zero replicas once they
+// were not invoked for their idle duration. Functions seen for the first
+// time start being idle now.
+func (s *FunctionScaler) ScaleIdle() error {
+	deployments, err := s.deployments()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	seen := map[string]bool{}
+	var lastErr error
+	for i := range deployments {
+		deployment := &deployments[i]
+		name := deployment.Name
+		seen[name] = true
+		if isSmartNICFunction(name) || deployment.Labels[scaleZeroLabel] != "true" {
+			continue
+		}
+
+		count := s.counter.Count(name)
+		s.mutex.Lock()
+		last, ok := s.activity[name]
+		if !ok || last.count != count {
+			s.activity[name] = functionActivity{count: count, at: now}
+		}
+		s.mutex.Unlock()
+		if !ok || last.count != count || replicaCount(deployment) == 0 {
+			continue
+		}
+		idle := now.Sub(last.at)
+		if idle < s.idleDuration(name, deployment.Labels) {
+			continue
+		}
+
+		if err = s.scale(name, 0); err != nil {
+			logging.Error("Could not scale idle function to zero",
+				"function_name", name, "error", err)
+			lastErr = err
+			continue
+		}
+		scaleEvents.WithLabelValues(name, scaleDown).Inc()
+		logging.Info("Scaled idle function to zero", "function_name", name,
+			"idle", idle)
+	}
+
+	s.mutex.Lock()
+	for name := range s.activity {
+		if !seen[name] {
+			delete(s.activity, name)
+		}
+	}
+	s.mutex.Unlock()
+	return lastErr
+}
+
+// Ready returns once a container function can be invoked. When it has no
+// replicas it is scaled to its minimum replica count and the invocation
+// waits for one of them to be available, up to the scale from zero
+// timeout. Functions which cannot be looked up are left for the invocation
+// to fail.
+func (s *FunctionScaler) Ready(ctx context.Context, functionName string) error {
+	s.touch(functionName)
+	deployment, err := s.deployment(functionName)
+	if err != nil || replicaCount(deployment) > 0 {
+		s.mutex.Lock()
+		w, ok := s.wakes[functionName]
+		s.mutex.Unlock()
+		if !ok {
+			return nil
+		}
+		return w.wait(ctx)
+	}
+
+	s.mutex.Lock()
+	w, ok := s.wakes[functionName]
+	if !ok {
+		w = &wake{done: make(chan struct{})}
+		s.wakes[functionName] = w
+		go s.scaleFromZero(functionName, deployment, w)
+	}
+	s.mutex.Unlock()
+	return w.wait(ctx)
+}
+
+func (w *wake) wait(ctx context.Context) error {
+	select {
+	case <-w.done:
+		return w.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// scaleFromZero scales a function up and waits for an available replica.
+// It is not bound to the invocation which started it, as others wait for it
+// too.
+func (s *FunctionScaler) scaleFromZero(functionName string,
+	deployment *appsv1.Deployment, w *wake) {
+	started := time.Now()
+	logger := logging.FromContext(context.Background()).With("function_name", functionName)
+
+	replicas := int32(1)
+	if minReplicas := getMinReplicaCount(deployment.Labels); minReplicas != nil {
+		replicas = *minReplicas
+	}
+	w.err = s.scale(functionName, replicas)
+	if w.err == nil {
+		scaleEvents.WithLabelValues(functionName, scaleUp).Inc()
+		w.err = s.waitAvailable(functionName, started.Add(s.config.ScaleFromZeroTimeout))
+	}
+	if w.err != nil {
+		logger.Error("Could not scale function from zero", "error", w.err)
+	} else {
+		logger.Info("Scaled function from zero", "replicas", replicas,
+			"duration", time.Since(started))
+	}
+	s.touch(functionName)
+
+	s.mutex.Lock()
+	delete(s.wakes, functionName)
+	s.mutex.Unlock()
+	close(w.done)
+}
+
+// waitAvailable polls a function until one of its replicas is available
+func (s *FunctionScaler) waitAvailable(functionName string, deadline time.Time) error {
+	for {
+		deployment, err := s.deployment(functionName)
+		if err == nil && deployment.Status.AvailableReplicas > 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no replica of %s available after %s",
+				functionName, s.config.ScaleFromZeroTimeout)
+		}
+		time.Sleep(scalePollInterval)
+	}
+}
+
+// touch marks a function as invoked now, so that it is not scaled to zero
+// before its invocations are counted
+func (s *FunctionScaler) touch(functionName string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if last, ok := s.activity[functionName]; ok {
+		last.at = time.Now()
+		s.activity[functionName] = last
+	}
+}
+
+func (s *FunctionScaler) idleDuration(functionName string, labels map[string]string) time.Duration {
+	if value, ok := labels[scaleZeroDurationLabel]; ok {
+		duration, err := time.ParseDuration(value)
+		if err == nil && duration > 0 {
+			return duration
+		}
+		logging.Warn("Invalid idle duration", "function_name", functionName,
+			"value", value, "error", err)
+	}
+	return s.config.IdleDuration
+}
+
+func (s *FunctionScaler) scale(functionName string, replicas int32) error {
+	deployment, legacy, err := getDeployment(s.clientset, s.namespace, functionName)
+	if err != nil {
+		return err
+	}
+	deployment.Spec.Replicas = &replicas
+	return updateDeployment(s.clientset, s.namespace, deployment, legacy)
+}
+
+func (s *FunctionScaler) deployment(functionName string) (*appsv1.Deployment, error) {
+	if s.cache != nil && s.cache.HasSynced() {
+		return s.cache.Deployment(functionName)
+	}
+	deployment, _, err := getDeployment(s.clientset, s.namespace, functionName)
+	return deployment, err
+}
+
+func (s *FunctionScaler) deployments() ([]appsv1.Deployment, error) {
+	if s.cache != nil && s.cache.HasSynced() {
+		return s.cache.Deployments()
+	}
+	return listDeployments(s.clientset, s.namespace,
+		metav1.ListOptions{LabelSelector: functionSelector})
+}
+
+// replicaCount returns the desired replicas of a deployment, Kubernetes
+// defaults them to one
+func replicaCount(deployment *appsv1.Deployment) int32 {
+	if deployment.Spec.Replicas == nil {
+		return 1
+	}
+	return *deployment.Spec.Replicas
+}
@@ -0,0 +1,40 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+func Test_idleDuration(t *testing.T) {
+	scaler := NewFunctionScaler("default", nil, nil, nil,
+		ScalingConfig{IdleDuration: 15 * time.Minute})
+	tests := []struct {
+		labels map[string]string
+		want   time.Duration
+	}{
+		{labels: map[string]string{}, want: 15 * time.Minute},
+		{labels: map[string]string{scaleZeroDurationLabel: "30s"}, want: 30 * time.Second},
+		{labels: map[string]string{scaleZeroDurationLabel: "soon"}, want: 15 * time.Minute},
+		{labels: map[string]string{scaleZeroDurationLabel: "-1m"}, want: 15 * time.Minute},
+	}
+	for _, test := range tests {
+		if got := scaler.idleDuration("echo", test.labels); got != test.want {
+			t.Errorf("idle duration of %v: want %s, got: %s", test.labels, test.want, got)
+		}
+	}
+}
+
+func Test_replicaCount(t *testing.T) {
+	deployment := &appsv1.Deployment{}
+	if replicas := replicaCount(deployment); replicas != 1 {
+		t.Errorf("want Kubernetes' default of one replica, got: %d", replicas)
+	}
+	deployment.Spec.Replicas = int32p(0)
+	if replicas := replicaCount(deployment); replicas != 0 {
+		t.Errorf("want no replicas, got: %d", replicas)
+	}
+}
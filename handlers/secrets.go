@@ -2,10 +2,11 @@ package handlers
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/Lambda-NIC/faas/gateway/requests"
+	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
-	v1beta1 "k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -15,11 +16,13 @@ const (
 )
 
 // getSecrets queries Kubernetes for a list of secrets by name in the given k8s namespace.
-func getSecrets(clientset *kubernetes.Clientset, namespace string, secretNames []string) (map[string]*apiv1.Secret, error) {
+func getSecrets(clientset kubernetes.Interface, namespace string, secretNames []string) (map[string]*apiv1.Secret, error) {
 	secrets := map[string]*apiv1.Secret{}
 
 	for _, secretName := range secretNames {
+		started := time.Now()
 		secret, err := clientset.Core().Secrets(namespace).Get(secretName, metav1.GetOptions{})
+		observeKubernetesCall("get_secret", started, err)
 		if err != nil {
 			return secrets, err
 		}
@@ -33,7 +36,7 @@ func getSecrets(clientset *kubernetes.Clientset, namespace string, secretNames [
 // in the kubernetes cluster.  For each requested secret, we inspect the type and add it to the
 // deployment spec as appropriat: secrets with type `SecretTypeDockercfg/SecretTypeDockerjson`
 // are added as ImagePullSecrets all other secrets are mounted as files in the deployments containers.
-func UpdateSecrets(request requests.CreateFunctionRequest, deployment *v1beta1.Deployment, existingSecrets map[string]*apiv1.Secret) error {
+func UpdateSecrets(request requests.CreateFunctionRequest, deployment *appsv1.Deployment, existingSecrets map[string]*apiv1.Secret) error {
 	// Add / reference pre-existing secrets within Kubernetes
 	secretVolumeProjections := []apiv1.VolumeProjection{}
 
@@ -5,8 +5,8 @@ import (
 	"testing"
 
 	"github.com/Lambda-NIC/faas/gateway/requests"
+	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
-	v1beta1 "k8s.io/api/extensions/v1beta1"
 )
 
 func Test_UpdateSecrets_DoesNotAddVolumeIfRequestSecretsIsNil(t *testing.T) {
@@ -19,8 +19,8 @@ func Test_UpdateSecrets_DoesNotAddVolumeIfRequestSecretsIsNil(t *testing.T) {
 		"testsecret": {Type: apiv1.SecretTypeOpaque, Data: map[string][]byte{"filename": []byte("contents")}},
 	}
 
-	deployment := &v1beta1.Deployment{
-		Spec: v1beta1.DeploymentSpec{
+	deployment := &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
 			Template: apiv1.PodTemplateSpec{
 				Spec: apiv1.PodSpec{
 					Containers: []apiv1.Container{
@@ -49,8 +49,8 @@ func Test_UpdateSecrets_DoesNotAddVolumeIfRequestSecretsIsEmpty(t *testing.T) {
 		"testsecret": {Type: apiv1.SecretTypeOpaque, Data: map[string][]byte{"filename": []byte("contents")}},
 	}
 
-	deployment := &v1beta1.Deployment{
-		Spec: v1beta1.DeploymentSpec{
+	deployment := &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
 			Template: apiv1.PodTemplateSpec{
 				Spec: apiv1.PodSpec{
 					Containers: []apiv1.Container{
@@ -80,8 +80,8 @@ func Test_UpdateSecrets_RemovesAllCopiesOfExitingSecretsVolumes(t *testing.T) {
 		"testsecret": {Type: apiv1.SecretTypeOpaque, Data: map[string][]byte{"filename": []byte("contents")}},
 	}
 
-	deployment := &v1beta1.Deployment{
-		Spec: v1beta1.DeploymentSpec{
+	deployment := &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
 			Template: apiv1.PodTemplateSpec{
 				Spec: apiv1.PodSpec{
 					Containers: []apiv1.Container{
@@ -129,8 +129,8 @@ func Test_UpdateSecrets_AddNewSecretVolume(t *testing.T) {
 		"testsecret": {Type: apiv1.SecretTypeOpaque, Data: map[string][]byte{"filename": []byte("contents")}},
 	}
 
-	deployment := &v1beta1.Deployment{
-		Spec: v1beta1.DeploymentSpec{
+	deployment := &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
 			Template: apiv1.PodTemplateSpec{
 				Spec: apiv1.PodSpec{
 					Containers: []apiv1.Container{
@@ -159,8 +159,8 @@ func Test_UpdateSecrets_ReplacesPreviousSecretMountWithNewMount(t *testing.T) {
 		"testsecret": {Type: apiv1.SecretTypeOpaque, Data: map[string][]byte{"filename": []byte("contents")}},
 	}
 
-	deployment := &v1beta1.Deployment{
-		Spec: v1beta1.DeploymentSpec{
+	deployment := &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
 			Template: apiv1.PodTemplateSpec{
 				Spec: apiv1.PodSpec{
 					Containers: []apiv1.Container{
@@ -196,8 +196,8 @@ func Test_UpdateSecrets_RemovesSecretsVolumeIfRequestSecretsIsEmptyOrNil(t *test
 		"testsecret": {Type: apiv1.SecretTypeOpaque, Data: map[string][]byte{"filename": []byte("contents")}},
 	}
 
-	deployment := &v1beta1.Deployment{
-		Spec: v1beta1.DeploymentSpec{
+	deployment := &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
 			Template: apiv1.PodTemplateSpec{
 				Spec: apiv1.PodSpec{
 					Containers: []apiv1.Container{
@@ -226,7 +226,7 @@ func Test_UpdateSecrets_RemovesSecretsVolumeIfRequestSecretsIsEmptyOrNil(t *test
 	validateEmptySecretVolumesAndMounts(t, deployment)
 }
 
-func validateEmptySecretVolumesAndMounts(t *testing.T, deployment *v1beta1.Deployment) {
+func validateEmptySecretVolumesAndMounts(t *testing.T, deployment *appsv1.Deployment) {
 	numVolumes := len(deployment.Spec.Template.Spec.Volumes)
 	if numVolumes != 0 {
 		fmt.Printf("%+v", deployment.Spec.Template.Spec.Volumes)
@@ -240,7 +240,7 @@ func validateEmptySecretVolumesAndMounts(t *testing.T, deployment *v1beta1.Deplo
 	}
 }
 
-func validateNewSecretVolumesAndMounts(t *testing.T, deployment *v1beta1.Deployment) {
+func validateNewSecretVolumesAndMounts(t *testing.T, deployment *appsv1.Deployment) {
 	numVolumes := len(deployment.Spec.Template.Spec.Volumes)
 	if numVolumes != 1 {
 		t.Errorf("Incorrect number of volumes: expected 1, got %d", numVolumes)
@@ -0,0 +1,156 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/logging"
+	"github.com/Lambda-NIC/faas-netes/types"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// SmartNICHealthy is reported when the SmartNIC sent a recent heartbeat
+	SmartNICHealthy = "healthy"
+	// SmartNICUnhealthy is reported when the last heartbeat is too old
+	SmartNICUnhealthy = "unhealthy"
+	// SmartNICUnknown is reported when the SmartNIC never sent a heartbeat
+	SmartNICUnknown = "unknown"
+)
+
+// smartNICHeartbeatTimeout is how old a heartbeat can be before the
+// SmartNIC is considered unhealthy
+const smartNICHeartbeatTimeout = 10 * time.Second
+
+// smartNICHealth derives the health of a SmartNIC from its last heartbeat
+func smartNICHealth(lastHeartbeat *time.Time, now time.Time) string {
+	if lastHeartbeat == nil {
+		return SmartNICUnknown
+	}
+	if now.Sub(*lastHeartbeat) > smartNICHeartbeatTimeout {
+		return SmartNICUnhealthy
+	}
+	return SmartNICHealthy
+}
+
+// getSmartNICStatus collects the deployments, capacity, health and circuit
+// state of a SmartNIC
+func getSmartNICStatus(keysAPI Store, router *SmartNICRouter,
+	smartNIC string) (*types.SmartNICStatus, error) {
+	deployments, err := GetSmartNICDeployments(keysAPI, smartNIC)
+	if err != nil {
+		return nil, err
+	}
+
+	status := types.SmartNICStatus{
+		Address:   smartNIC,
+		Capacity:  GetSmartNICCapacity(keysAPI, smartNIC),
+		Functions: []types.FunctionReplicas{},
+	}
+	for funcName, numReps := range deployments {
+		status.CapacityUsed += numReps
+		status.Functions = append(status.Functions, types.FunctionReplicas{
+			Name:     funcName,
+			Replicas: numReps,
+		})
+	}
+	sort.Slice(status.Functions, func(i, j int) bool {
+		return status.Functions[i].Name < status.Functions[j].Name
+	})
+
+	status.LastHeartbeat = GetSmartNICHeartbeat(keysAPI, smartNIC)
+	status.Health = smartNICHealth(status.LastHeartbeat, time.Now())
+	status.Circuit = router.circuitState(smartNIC)
+	return &status, nil
+}
+
+// MakeSmartNICReader reports the functions and replica counts hosted on a SmartNIC
+func MakeSmartNICReader(keysAPI Store,
+	router *SmartNICRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keysAPI := traceKeysAPI(keysAPI, r.Context())
+		vars := mux.Vars(r)
+		smartNIC := vars["ip"]
+
+		if !EtcdSmartNICExists(keysAPI, smartNIC) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		status, err := getSmartNICStatus(keysAPI, router, smartNIC)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Could not read SmartNIC",
+				"smartnic", smartNIC, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		statusBytes, _ := json.Marshal(status)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(statusBytes)
+	}
+}
+
+// MakePlacementReader reports the SmartNICs and replica counts of a function
+func MakePlacementReader(keysAPI Store,
+	router *SmartNICRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keysAPI := traceKeysAPI(keysAPI, r.Context())
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+
+		if !EtcdFunctionExists(keysAPI, functionName) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		logger := logging.FromContext(r.Context())
+		placement, err := GetFunctionPlacement(keysAPI, functionName)
+		if err != nil {
+			logger.Error("Could not read placement",
+				"function_name", functionName, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		functionPlacement := types.FunctionPlacement{
+			Name:      functionName,
+			SmartNICs: []types.SmartNICPlacement{},
+		}
+		for smartNIC, numReps := range placement {
+			status, statusErr := getSmartNICStatus(keysAPI, router, smartNIC)
+			if statusErr != nil {
+				logger.Warn("Could not read SmartNIC",
+					"smartnic", smartNIC, "error", statusErr)
+				continue
+			}
+			functionPlacement.Replicas += numReps
+			functionPlacement.SmartNICs = append(functionPlacement.SmartNICs,
+				types.SmartNICPlacement{
+					Address:       smartNIC,
+					Replicas:      numReps,
+					Health:        status.Health,
+					Circuit:       status.Circuit,
+					LastHeartbeat: status.LastHeartbeat,
+					Capacity:      status.Capacity,
+					CapacityUsed:  status.CapacityUsed,
+				})
+		}
+		sort.Slice(functionPlacement.SmartNICs, func(i, j int) bool {
+			return functionPlacement.SmartNICs[i].Address <
+				functionPlacement.SmartNICs[j].Address
+		})
+
+		placementBytes, _ := json.Marshal(functionPlacement)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(placementBytes)
+	}
+}
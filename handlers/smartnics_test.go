@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_smartNICHealth(t *testing.T) {
+	now := time.Now()
+	recent := now.Add(-time.Second)
+	stale := now.Add(-2 * smartNICHeartbeatTimeout)
+
+	cases := []struct {
+		scenario      string
+		lastHeartbeat *time.Time
+		want          string
+	}{
+		{"no heartbeat", nil, SmartNICUnknown},
+		{"recent heartbeat", &recent, SmartNICHealthy},
+		{"stale heartbeat", &stale, SmartNICUnhealthy},
+	}
+
+	for _, testCase := range cases {
+		got := smartNICHealth(testCase.lastHeartbeat, now)
+		if got != testCase.want {
+			t.Errorf("Scenario: %s want: %s, got: %s", testCase.scenario, testCase.want, got)
+		}
+	}
+}
+
+func Test_makeSmartNICPool(t *testing.T) {
+	pool := makeSmartNICPool(newTestRoutingTable(map[string]string{
+		"10.0.0.1": SmartNICHealthy,
+		"10.0.0.2": SmartNICHealthy,
+		"10.0.0.3": SmartNICUnhealthy,
+		"10.0.0.4": SmartNICUnknown,
+	}))
+	if pool.Size != 4 || pool.Healthy != 2 || pool.Unhealthy != 1 || pool.Unknown != 1 {
+		t.Errorf("want: 4 SmartNICs, 2 healthy, 1 unhealthy and 1 unknown, got: %v", pool)
+	}
+}
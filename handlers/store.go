@@ -0,0 +1,162 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package handlers
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.etcd.io/etcd/client"
+)
+
+// Store is the part of the etcd keys API used by the provider. It is
+// satisfied by client.KeysAPI and by MemoryStore.
+type Store interface {
+	Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error)
+	Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error)
+	Delete(ctx context.Context, key string, opts *client.DeleteOptions) (*client.Response, error)
+}
+
+// MemoryStore keeps keys in memory with the semantics of etcd which the
+// provider relies on, so that the handlers run without etcd in tests.
+type MemoryStore struct {
+	mutex  sync.Mutex
+	values map[string]string
+	dirs   map[string]bool
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{values: map[string]string{}, dirs: map[string]bool{}}
+}
+
+func keyNotFound(key string) error {
+	return client.Error{Code: client.ErrorCodeKeyNotFound, Message: "Key not found", Cause: key}
+}
+
+func compareFailed(key string, prevValue string) error {
+	return client.Error{Code: client.ErrorCodeTestFailed, Message: "Compare failed",
+		Cause: "[" + prevValue + "] " + key}
+}
+
+// Get returns the node of a key, directories list their children and
+// every descendant when recursive
+func (m *MemoryStore) Get(ctx context.Context, key string,
+	opts *client.GetOptions) (*client.Response, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	recursive := opts != nil && opts.Recursive
+	node := m.node(strings.TrimSuffix(key, "/"), recursive, true)
+	if node == nil {
+		return nil, keyNotFound(key)
+	}
+	return &client.Response{Action: "get", Node: node}, nil
+}
+
+// Set writes a value or creates a directory. It fails when the key exists
+// and opts require it not to, when it does not hold the previous value opts
+// require, or when a directory is set again.
+func (m *MemoryStore) Set(ctx context.Context, key, value string,
+	opts *client.SetOptions) (*client.Response, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key = strings.TrimSuffix(key, "/")
+	exists := m.node(key, false, false) != nil
+	if opts != nil && opts.PrevExist == client.PrevNoExist && exists {
+		return nil, client.Error{Code: client.ErrorCodeNodeExist, Message: "Key already exists", Cause: key}
+	}
+	if opts != nil && len(opts.PrevValue) > 0 {
+		value, ok := m.values[key]
+		if !ok {
+			return nil, keyNotFound(key)
+		}
+		if value != opts.PrevValue {
+			return nil, compareFailed(key, opts.PrevValue)
+		}
+	}
+	if opts != nil && opts.Dir {
+		if exists {
+			return nil, client.Error{Code: client.ErrorCodeNotFile, Message: "Not a file", Cause: key}
+		}
+		m.dirs[key] = true
+		return &client.Response{Action: "set", Node: &client.Node{Key: key, Dir: true}}, nil
+	}
+	m.values[key] = value
+	return &client.Response{Action: "set", Node: &client.Node{Key: key, Value: value}}, nil
+}
+
+// Delete removes a key, or a directory with everything below it. A key is
+// only removed when it holds the previous value opts require.
+func (m *MemoryStore) Delete(ctx context.Context, key string,
+	opts *client.DeleteOptions) (*client.Response, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key = strings.TrimSuffix(key, "/")
+	if value, ok := m.values[key]; ok {
+		if opts != nil && len(opts.PrevValue) > 0 && value != opts.PrevValue {
+			return nil, compareFailed(key, opts.PrevValue)
+		}
+		delete(m.values, key)
+		return &client.Response{Action: "delete", Node: &client.Node{Key: key}}, nil
+	}
+	found := m.dirs[key]
+	delete(m.dirs, key)
+	for k := range m.values {
+		if strings.HasPrefix(k, key+"/") {
+			delete(m.values, k)
+			found = true
+		}
+	}
+	for k := range m.dirs {
+		if strings.HasPrefix(k, key+"/") {
+			delete(m.dirs, k)
+			found = true
+		}
+	}
+	if !found {
+		return nil, keyNotFound(key)
+	}
+	return &client.Response{Action: "delete", Node: &client.Node{Key: key, Dir: true}}, nil
+}
+
+// node builds the node of a key, expanding directories one level or fully
+// when recursive
+func (m *MemoryStore) node(key string, recursive bool, expand bool) *client.Node {
+	if value, ok := m.values[key]; ok {
+		return &client.Node{Key: key, Value: value}
+	}
+	children := map[string]bool{}
+	for _, keys := range []map[string]bool{m.dirs, m.keySet()} {
+		for k := range keys {
+			if strings.HasPrefix(k, key+"/") {
+				children[key+"/"+strings.SplitN(k[len(key)+1:], "/", 2)[0]] = true
+			}
+		}
+	}
+	if len(children) == 0 && !m.dirs[key] {
+		return nil
+	}
+	node := &client.Node{Key: key, Dir: true}
+	if !expand {
+		return node
+	}
+	keys := []string{}
+	for child := range children {
+		keys = append(keys, child)
+	}
+	sort.Strings(keys)
+	for _, child := range keys {
+		node.Nodes = append(node.Nodes, m.node(child, recursive, recursive))
+	}
+	return node
+}
+
+func (m *MemoryStore) keySet() map[string]bool {
+	keys := make(map[string]bool, len(m.values))
+	for k := range m.values {
+		keys[k] = true
+	}
+	return keys
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"go.etcd.io/etcd/client"
+)
+
+func Test_MemoryStore_Directories(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	dir := &client.SetOptions{Dir: true}
+
+	if _, err := store.Set(ctx, "/deployments/smartnic/10.0.0.1", "", dir); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := store.Get(ctx, "/deployments/smartnic/10.0.0.1", nil)
+	if err != nil || !resp.Node.Dir || len(resp.Node.Nodes) != 0 {
+		t.Fatalf("want an empty directory, got: %v %v", resp, err)
+	}
+	if _, err = store.Set(ctx, "/deployments/smartnic/10.0.0.1", "", dir); err == nil {
+		t.Errorf("want an error setting an existing directory")
+	}
+	_, err = store.Set(ctx, "/deployments/smartnic/10.0.0.1", "",
+		&client.SetOptions{Dir: true, PrevExist: client.PrevNoExist})
+	if etcdErr, ok := err.(client.Error); !ok || etcdErr.Code != client.ErrorCodeNodeExist {
+		t.Errorf("want node exists, got: %v", err)
+	}
+
+	store.Set(ctx, CreateDepKey("10.0.0.1", "echo-lambdanic"), "2", nil)
+	resp, err = store.Get(ctx, "/deployments", &client.GetOptions{Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nic := resp.Node.Nodes[0].Nodes[0]
+	if nic.Key != "/deployments/smartnic/10.0.0.1" || nic.Nodes[0].Value != "2" {
+		t.Errorf("want the deployments listed recursively, got: %v", nic)
+	}
+
+	_, err = store.Delete(ctx, "/deployments", &client.DeleteOptions{Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Get(ctx, CreateDepKey("10.0.0.1", "echo-lambdanic"), nil); !client.IsKeyNotFound(err) {
+		t.Errorf("want the deployments deleted, got: %v", err)
+	}
+}
+
+func Test_MemoryStore_CompareAndSwap(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	key := CreateInvocationKey("echo-lambdanic", "provider-0")
+
+	_, err := store.Set(ctx, key, "2", &client.SetOptions{PrevValue: "1"})
+	if !client.IsKeyNotFound(err) {
+		t.Errorf("want key not found, got: %v", err)
+	}
+	store.Set(ctx, key, "1", nil)
+	_, err = store.Set(ctx, key, "3", &client.SetOptions{PrevValue: "2"})
+	if etcdErr, ok := err.(client.Error); !ok || etcdErr.Code != client.ErrorCodeTestFailed {
+		t.Errorf("want compare failed, got: %v", err)
+	}
+	if _, err = store.Set(ctx, key, "2", &client.SetOptions{PrevValue: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Delete(ctx, key, &client.DeleteOptions{PrevValue: "1"})
+	if etcdErr, ok := err.(client.Error); !ok || etcdErr.Code != client.ErrorCodeTestFailed {
+		t.Errorf("want compare failed, got: %v", err)
+	}
+	if _, err = store.Delete(ctx, key, &client.DeleteOptions{PrevValue: "2"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,22 +4,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"strings"
 	"time"
 
+	"github.com/Lambda-NIC/faas-netes/logging"
 	"github.com/Lambda-NIC/faas/gateway/requests"
-	"go.etcd.io/etcd/client"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 // MakeUpdateHandler update specified function
 func MakeUpdateHandler(functionNamespace string,
-	keysAPI client.KeysAPI,
-	clientset *kubernetes.Clientset) http.HandlerFunc {
+	keysAPI Store,
+	clientset kubernetes.Interface,
+	config *DeployHandlerConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		keysAPI := traceKeysAPI(keysAPI, r.Context())
 
 		defer r.Body.Close()
 
@@ -27,9 +27,6 @@ func MakeUpdateHandler(functionNamespace string,
 
 		request := requests.CreateFunctionRequest{}
 
-		// Make sure the deployment only occurs at nodes with smartnics
-		request.Constraints = append(request.Constraints, "smartnic=enabled")
-
 		err := json.Unmarshal(body, &request)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
@@ -37,15 +34,62 @@ func MakeUpdateHandler(functionNamespace string,
 		}
 
 		// LambdaNIC: Update a function
-		if strings.Contains(request.Service, "lambdanic") ||
-			strings.Contains(request.Service, "baremetal") {
-			// TODO: Need to do this.
-			w.Write([]byte("Updated!"))
+		if isSmartNICFunction(request.Service) {
+			if !EtcdFunctionExists(keysAPI, request.Service) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("Not found: " + request.Service))
+				return
+			}
+			logger := logging.FromContext(r.Context())
+			wasHybrid := false
+			if previous, metaErr := EtcdGetFunctionMeta(keysAPI, request.Service); metaErr == nil {
+				wasHybrid = isHybridFunction(previous.Annotations)
+			}
+			meta := makeFunctionMeta(request)
+			hybrid := isHybridFunction(meta.Annotations)
+
+			// LambdaNIC: The container of a hybrid function is created,
+			// updated or deleted before the metadata is stored, so that the
+			// proxy only overflows to a container which exists.
+			var status int
+			switch {
+			case hybrid && !wasHybrid:
+				status, err = createFunction(functionNamespace, clientset,
+					request, config, logger)
+			case !hybrid && wasHybrid:
+				status, err = deleteContainer(functionNamespace, clientset,
+					request.Service)
+			case hybrid:
+				annotations := buildAnnotations(request)
+				status, err = updateDeploymentSpec(functionNamespace,
+					clientset, request, annotations, config)
+				if err == nil {
+					status, err = updateService(functionNamespace, clientset,
+						request, annotations)
+				}
+			}
+			if err != nil {
+				w.WriteHeader(status)
+				w.Write([]byte(err.Error()))
+				return
+			}
+
+			if err := EtcdSetFunctionMeta(keysAPI, request.Service, meta); err != nil {
+				logger.Error("Could not store function metadata",
+					"function_name", request.Service, "error", err)
+				if hybrid && !wasHybrid {
+					rollbackFunction(functionNamespace, clientset, request.Service,
+						true, logger)
+				}
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
 		} else {
 			annotations := buildAnnotations(request)
 			if status, err := updateDeploymentSpec(functionNamespace,
 				clientset, request,
-				annotations); err != nil {
+				annotations, config); err != nil {
 				w.WriteHeader(status)
 				w.Write([]byte(err.Error()))
 			}
@@ -63,19 +107,27 @@ func MakeUpdateHandler(functionNamespace string,
 
 func updateDeploymentSpec(
 	functionNamespace string,
-	clientset *kubernetes.Clientset,
+	clientset kubernetes.Interface,
 	request requests.CreateFunctionRequest,
-	annotations map[string]string) (httpStatus int, err error) {
-	getOpts := metav1.GetOptions{}
-
-	deployment, findDeployErr := clientset.ExtensionsV1beta1().
-		Deployments(functionNamespace).
-		Get(request.Service, getOpts)
-
+	annotations map[string]string,
+	config *DeployHandlerConfig) (httpStatus int, err error) {
+	deployment, legacy, findDeployErr := getDeployment(clientset,
+		functionNamespace, request.Service)
 	if findDeployErr != nil {
 		return http.StatusNotFound, findDeployErr
 	}
 
+	constraints, constraintsErr := mergeConstraints(
+		config.NodePools.constraints(request.Service), request.Constraints)
+	if constraintsErr != nil {
+		return http.StatusBadRequest, constraintsErr
+	}
+	request.Constraints = constraints
+	scheduling, schedulingErr := makeScheduling(request)
+	if schedulingErr != nil {
+		return http.StatusBadRequest, schedulingErr
+	}
+
 	if len(deployment.Spec.Template.Spec.Containers) > 0 {
 		deployment.Spec.Template.Spec.Containers[0].Image = request.Image
 
@@ -88,7 +140,7 @@ func updateDeploymentSpec(
 
 		configureReadOnlyRootFilesystem(request, deployment)
 
-		deployment.Spec.Template.Spec.NodeSelector = createSelector(request.Constraints)
+		scheduling.apply(&deployment.Spec.Template.Spec)
 
 		labels := map[string]string{
 			"faas_function": request.Service,
@@ -105,9 +157,13 @@ func updateDeploymentSpec(
 			}
 		}
 
+		// LambdaNIC: The selector of an apps/v1 Deployment is immutable.
+		preserveSelector(deployment.Spec.Selector, labels)
+
 		deployment.Labels = labels
 		deployment.Spec.Template.ObjectMeta.Labels = labels
 
+		annotations = withConstraints(annotations, constraints)
 		deployment.Annotations = annotations
 		deployment.Spec.Template.Annotations = annotations
 		deployment.Spec.Template.ObjectMeta.Annotations = annotations
@@ -126,42 +182,62 @@ func updateDeploymentSpec(
 
 		err = UpdateSecrets(request, deployment, existingSecrets)
 		if err != nil {
-			log.Println(err)
+			logging.Warn("Could not update secrets",
+				"function_name", request.Service, "error", err)
 			return http.StatusBadRequest, err
 		}
 	}
 
-	if _, updateErr := clientset.ExtensionsV1beta1().
-		Deployments(functionNamespace).
-		Update(deployment); updateErr != nil {
+	hpa, hpaErr := makeHPASpec(request.Service, deployment.Labels, legacy)
+	if hpaErr != nil {
+		return http.StatusBadRequest, hpaErr
+	}
+
+	updateErr := updateDeployment(clientset, functionNamespace, deployment, legacy)
+	if updateErr != nil {
 
 		return http.StatusInternalServerError, updateErr
 	}
 
+	if hpaErr = syncHPA(clientset, functionNamespace, request.Service, hpa); hpaErr != nil {
+		return http.StatusInternalServerError, hpaErr
+	}
+
 	return http.StatusAccepted, nil
 }
 
 func updateService(
 	functionNamespace string,
-	clientset *kubernetes.Clientset,
+	clientset kubernetes.Interface,
 	request requests.CreateFunctionRequest,
 	annotations map[string]string) (httpStatus int, err error) {
 
 	getOpts := metav1.GetOptions{}
 
+	started := time.Now()
 	service, findServiceErr := clientset.CoreV1().
 		Services(functionNamespace).
 		Get(request.Service, getOpts)
+	observeKubernetesCall("get_service", started, findServiceErr)
 
 	if findServiceErr != nil {
 		return http.StatusNotFound, findServiceErr
 	}
 
 	service.Annotations = annotations
+	// Services created before they were labelled are labelled so that the
+	// function cache follows them.
+	if service.Labels == nil {
+		service.Labels = map[string]string{}
+	}
+	service.Labels[functionSelector] = request.Service
 
-	if _, updateErr := clientset.CoreV1().
+	started = time.Now()
+	_, updateErr := clientset.CoreV1().
 		Services(functionNamespace).
-		Update(service); updateErr != nil {
+		Update(service)
+	observeKubernetesCall("update_service", started, updateErr)
+	if updateErr != nil {
 
 		return http.StatusInternalServerError, updateErr
 	}
@@ -0,0 +1,63 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// CallIDHeader carries the ID of a request, it is set by the gateway and
+// returned to the caller
+const CallIDHeader = "X-Call-Id"
+
+// StatusRecorder keeps the status code written by a handler
+type StatusRecorder struct {
+	http.ResponseWriter
+	// Status is the status code written, http.StatusOK until one is
+	Status int
+}
+
+// NewStatusRecorder creates a recorder of the status code written to w
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+// WriteHeader records the status code and writes it
+func (s *StatusRecorder) WriteHeader(status int) {
+	s.Status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// NewCallID returns a random call ID
+func NewCallID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Handler gives every request a call ID, taken from the X-Call-Id header or
+// generated, which is returned in the response and added to every line
+// logged with the logger of the request context.
+func Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		callID := r.Header.Get(CallIDHeader)
+		if len(callID) == 0 {
+			callID = NewCallID()
+			r.Header.Set(CallIDHeader, callID)
+		}
+		w.Header().Set(CallIDHeader, callID)
+
+		logger := root.With("call_id", callID)
+		recorder := NewStatusRecorder(w)
+		started := time.Now()
+		next(recorder, r.WithContext(NewContext(r.Context(), logger)))
+		logger.Debug("request served",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.Status,
+			"duration", time.Since(started))
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+// Package logging writes leveled, structured log lines as logfmt or JSON.
+// Fields are given as alternating keys and values, and values of sensitive
+// keys such as env vars and secrets are redacted.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log line
+type Level int
+
+const (
+	// LevelDebug is for detail only needed when diagnosing a problem
+	LevelDebug Level = iota
+	// LevelInfo is for the normal operation of the provider
+	LevelInfo
+	// LevelWarn is for failures the provider recovers from
+	LevelWarn
+	// LevelError is for failures of a request or the provider
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// String returns the name of the level
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "unknown"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level of a name such as info
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %s", name)
+}
+
+const (
+	// FormatLogfmt writes key=value pairs
+	FormatLogfmt = "logfmt"
+	// FormatJSON writes a JSON object per line
+	FormatJSON = "json"
+)
+
+// output is where every logger writes to
+type output struct {
+	mutex  sync.Mutex
+	level  Level
+	format string
+	writer io.Writer
+}
+
+var out = &output{level: LevelInfo, format: FormatLogfmt, writer: os.Stderr}
+
+// Configure sets the lowest level written, the format and the writer of
+// every logger.
+func Configure(level Level, format string, writer io.Writer) {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+	out.level = level
+	out.format = format
+	out.writer = writer
+}
+
+// Logger writes log lines carrying its fields
+type Logger struct {
+	fields []interface{}
+}
+
+var root = &Logger{}
+
+// With returns a logger which adds the given keys and values to every line
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(append(fields, l.fields...), keyvals...)
+	return &Logger{fields: fields}
+}
+
+// Debug logs at debug level
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+// Info logs at info level
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+// Warn logs at warn level
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+// Error logs at error level
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+// Enabled returns true when lines of the level are written
+func Enabled(level Level) bool {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+	return level >= out.level
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+	if level < out.level {
+		return
+	}
+
+	fields := []interface{}{
+		"time", time.Now().UTC().Format(time.RFC3339Nano),
+		"level", level.String(),
+		"msg", msg,
+	}
+	fields = append(append(fields, l.fields...), keyvals...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(missing)")
+	}
+
+	buf := &bytes.Buffer{}
+	if out.format == FormatJSON {
+		writeJSON(buf, fields)
+	} else {
+		writeLogfmt(buf, fields)
+	}
+	buf.WriteByte('\n')
+	out.writer.Write(buf.Bytes())
+}
+
+func writeLogfmt(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		key := fmt.Sprint(fields[i])
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		value := formatValue(key, fields[i+1])
+		if strings.ContainsAny(value, " =\"\t\n") || len(value) == 0 {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+	}
+}
+
+func writeJSON(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key := fmt.Sprint(fields[i])
+		keyBytes, _ := json.Marshal(key)
+		buf.Write(keyBytes)
+		buf.WriteByte(':')
+		valueBytes, _ := json.Marshal(formatValue(key, fields[i+1]))
+		buf.Write(valueBytes)
+	}
+	buf.WriteByte('}')
+}
+
+// formatValue turns a value into text, redacting it when the key is
+// sensitive
+func formatValue(key string, value interface{}) string {
+	value = Redact(key, value)
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(v))
+		for _, k := range keys {
+			pairs = append(pairs, k+":"+v[k])
+		}
+		return "{" + strings.Join(pairs, ",") + "}"
+	}
+	return fmt.Sprint(value)
+}
+
+// Debug logs at debug level without request fields
+func Debug(msg string, keyvals ...interface{}) { root.log(LevelDebug, msg, keyvals) }
+
+// Info logs at info level without request fields
+func Info(msg string, keyvals ...interface{}) { root.log(LevelInfo, msg, keyvals) }
+
+// Warn logs at warn level without request fields
+func Warn(msg string, keyvals ...interface{}) { root.log(LevelWarn, msg, keyvals) }
+
+// Error logs at error level without request fields
+func Error(msg string, keyvals ...interface{}) { root.log(LevelError, msg, keyvals) }
+
+// Fatal logs at error level and exits
+func Fatal(msg string, keyvals ...interface{}) {
+	root.log(LevelError, msg, keyvals)
+	os.Exit(1)
+}
+
+type loggerKey struct{}
+
+// NewContext returns a context carrying the logger
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by the context, or one without
+// fields when there is none
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return logger
+	}
+	return root
+}
+
+// stdWriter turns the lines of the standard library logger into info lines
+type stdWriter struct{}
+
+// StdWriter returns a writer for log.SetOutput so that the lines logged by
+// dependencies are structured too
+func StdWriter() io.Writer {
+	return stdWriter{}
+}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	root.log(LevelInfo, strings.TrimRight(string(p), "\n"), nil)
+	return len(p), nil
+}
@@ -0,0 +1,160 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func configureBuffer(level Level, format string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	Configure(level, format, buf)
+	return buf
+}
+
+func restore() {
+	Configure(LevelInfo, FormatLogfmt, os.Stderr)
+}
+
+func Test_ParseLevel(t *testing.T) {
+	for name, want := range map[string]Level{
+		"debug": LevelDebug, "INFO": LevelInfo, "warn": LevelWarn, "error": LevelError,
+	} {
+		level, err := ParseLevel(name)
+		if err != nil || level != want {
+			t.Errorf("ParseLevel(%s) want %s, got %s %v", name, want, level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("want error for unknown level")
+	}
+}
+
+func Test_Logger_Levels(t *testing.T) {
+	buf := configureBuffer(LevelWarn, FormatLogfmt)
+	defer restore()
+
+	Debug("debug line")
+	Info("info line")
+	Warn("warn line")
+	Error("error line")
+
+	out := buf.String()
+	if strings.Contains(out, "debug line") || strings.Contains(out, "info line") {
+		t.Errorf("want lines below warn dropped, got: %s", out)
+	}
+	if !strings.Contains(out, "level=warn msg=\"warn line\"") ||
+		!strings.Contains(out, "level=error msg=\"error line\"") {
+		t.Errorf("want warn and error lines, got: %s", out)
+	}
+}
+
+func Test_Logger_Logfmt(t *testing.T) {
+	buf := configureBuffer(LevelDebug, FormatLogfmt)
+	defer restore()
+
+	root.With("call_id", "abc").Info("served", "status", 200, "path", "/a b")
+
+	line := buf.String()
+	if !strings.HasPrefix(line, "time=") || !strings.HasSuffix(line, "\n") {
+		t.Errorf("want a time prefixed line, got: %s", line)
+	}
+	want := `level=info msg=served call_id=abc status=200 path="/a b"`
+	if !strings.Contains(line, want) {
+		t.Errorf("want %s in line, got: %s", want, line)
+	}
+}
+
+func Test_Logger_JSON(t *testing.T) {
+	buf := configureBuffer(LevelDebug, FormatJSON)
+	defer restore()
+
+	Error("failed", "function_name", "figlet", "replicas", 2, "dangling")
+
+	fields := map[string]string{}
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatalf("want a JSON line, got: %s %v", buf.String(), err)
+	}
+	if fields["level"] != "error" || fields["msg"] != "failed" ||
+		fields["function_name"] != "figlet" || fields["replicas"] != "2" ||
+		fields["dangling"] != "(missing)" {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+	if !strings.HasPrefix(buf.String(), `{"time":`) {
+		t.Errorf("want time first, got: %s", buf.String())
+	}
+}
+
+func Test_Redact(t *testing.T) {
+	buf := configureBuffer(LevelDebug, FormatLogfmt)
+	defer restore()
+
+	Info("deploying",
+		"service", "figlet",
+		"envVars", map[string]string{"db_password": "hunter2", "mode": "fast"},
+		"secrets", []string{"api-key"},
+		"authorization", "Bearer abc",
+		"env", "staging-key",
+		"inventory", "nic-4",
+		"environment_name", "staging")
+
+	line := buf.String()
+	for _, leaked := range []string{"hunter2", "fast", "api-key", "Bearer", "staging-key"} {
+		if strings.Contains(line, leaked) {
+			t.Errorf("want %s redacted, got: %s", leaked, line)
+		}
+	}
+	if !strings.Contains(line, "service=figlet") ||
+		!strings.Contains(line, "db_password:[REDACTED]") {
+		t.Errorf("want service and env var names kept, got: %s", line)
+	}
+	if !strings.Contains(line, "inventory=nic-4") || !strings.Contains(line, "environment_name=staging") {
+		t.Errorf("want fields which only contain env kept, got: %s", line)
+	}
+}
+
+func Test_Handler_GeneratesCallID(t *testing.T) {
+	buf := configureBuffer(LevelDebug, FormatLogfmt)
+	defer restore()
+
+	var seen string
+	handler := Handler(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get(CallIDHeader)
+		FromContext(r.Context()).Info("inside")
+		w.WriteHeader(http.StatusAccepted)
+	})
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/system/functions", nil))
+
+	callID := rr.Header().Get(CallIDHeader)
+	if len(callID) != 32 || callID != seen {
+		t.Errorf("want a generated call ID passed on, got: %q %q", callID, seen)
+	}
+	if !strings.Contains(buf.String(), "msg=inside call_id="+callID) {
+		t.Errorf("want call_id on request lines, got: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "status=202") {
+		t.Errorf("want the request logged with its status, got: %s", buf.String())
+	}
+}
+
+func Test_Handler_ReusesCallID(t *testing.T) {
+	configureBuffer(LevelInfo, FormatLogfmt)
+	defer restore()
+
+	handler := Handler(func(w http.ResponseWriter, r *http.Request) {})
+	req := httptest.NewRequest(http.MethodGet, "/function/figlet", nil)
+	req.Header.Set(CallIDHeader, "gateway-id")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	if got := rr.Header().Get(CallIDHeader); got != "gateway-id" {
+		t.Errorf("want the gateway call ID returned, got: %s", got)
+	}
+}
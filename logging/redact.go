@@ -0,0 +1,53 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package logging
+
+import "strings"
+
+// Redacted replaces the value of a sensitive field
+const Redacted = "[REDACTED]"
+
+// sensitiveFields are the field names whose values are redacted, env vars
+// are matched by their exact name so that fields such as environment_name
+// are kept
+var sensitiveFields = map[string]bool{"envvars": true, "env": true}
+
+// sensitiveKeys are the parts of field names whose values are redacted
+var sensitiveKeys = []string{"secret", "password", "token", "authorization", "credential"}
+
+// IsSensitive returns true when the values of a field must not be logged
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	if sensitiveFields[key] {
+		return true
+	}
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns the value to log for a field. The values of sensitive
+// fields are replaced, keeping the keys of maps such as env vars so that it
+// remains visible which were set.
+func Redact(key string, value interface{}) interface{} {
+	if !IsSensitive(key) {
+		return value
+	}
+	switch v := value.(type) {
+	case map[string]string:
+		redacted := make(map[string]string, len(v))
+		for k := range v {
+			redacted[k] = Redacted
+		}
+		return redacted
+	case *map[string]string:
+		if v == nil {
+			return value
+		}
+		return Redact(key, *v)
+	}
+	return Redacted
+}
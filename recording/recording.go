@@ -0,0 +1,168 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+// Package recording keeps a trace of the invocations served by the proxy as
+// JSON lines, and replays such a trace against a provider.
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record is an invocation served by the proxy
+type Record struct {
+	// Time is when the invocation was received
+	Time time.Time `json:"time"`
+	// CallID is the X-Call-Id of the invocation
+	CallID   string `json:"call_id,omitempty"`
+	Function string `json:"function"`
+	Method   string `json:"method"`
+	// PayloadBytes is the size of the request body
+	PayloadBytes int64 `json:"payload_bytes"`
+	// JobID is the job ID sent to the SmartNIC or bare-metal server
+	JobID *int `json:"job_id,omitempty"`
+	// Backend is container, smartnic or baremetal
+	Backend string `json:"backend"`
+	// SmartNIC is the SmartNIC or bare-metal server which replied
+	SmartNIC string `json:"smartnic,omitempty"`
+	// OverflowReason is why a hybrid function overflowed to its container
+	OverflowReason string `json:"overflow_reason,omitempty"`
+	// Latency is how long the proxy took to serve the invocation in seconds
+	Latency float64 `json:"latency_seconds"`
+	// Status is the HTTP status code returned to the caller
+	Status int `json:"status"`
+}
+
+// RecorderConfig specify where a Recorder writes and when it rotates
+type RecorderConfig struct {
+	// Path is the file records are appended to
+	Path string
+	// MaxBytes rotates the file once it grows past that size, zero never
+	// rotates it
+	MaxBytes int64
+	// MaxFiles is how many rotated files are kept as Path.1 to Path.N, the
+	// oldest being removed
+	MaxFiles int
+}
+
+// Recorder appends records to a JSON lines file which is rotated by size
+type Recorder struct {
+	config RecorderConfig
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// NewRecorder opens the file of the recorder, appending to it when it
+// already exists
+func NewRecorder(config RecorderConfig) (*Recorder, error) {
+	r := &Recorder{config: config}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Recorder) open() error {
+	file, err := os.OpenFile(r.config.Path,
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// Record appends a record, rotating the file first when it is full
+func (r *Recorder) Record(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		return fmt.Errorf("recorder is closed")
+	}
+	if r.config.MaxBytes > 0 && r.size > 0 &&
+		r.size+int64(len(line)) > r.config.MaxBytes {
+		if err = r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.Write(line)
+	r.size += int64(n)
+	return err
+}
+
+// rotate shifts Path.i to Path.i+1, dropping the oldest, moves the current
+// file to Path.1 and opens a new one
+func (r *Recorder) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	if r.config.MaxFiles > 0 {
+		os.Remove(rotatedPath(r.config.Path, r.config.MaxFiles))
+		for i := r.config.MaxFiles - 1; i > 0; i-- {
+			os.Rename(rotatedPath(r.config.Path, i),
+				rotatedPath(r.config.Path, i+1))
+		}
+		if err := os.Rename(r.config.Path, rotatedPath(r.config.Path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.config.Path); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func rotatedPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// Close closes the file of the recorder
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// ReadRecords reads the records of a trace, one JSON object per line
+func ReadRecords(reader io.Reader) ([]Record, error) {
+	records := []Record{}
+	scanner := bufio.NewScanner(reader)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
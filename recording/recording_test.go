@@ -0,0 +1,148 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package recording
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tempPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "trace.jsonl"), func() { os.RemoveAll(dir) }
+}
+
+func readFile(t *testing.T, path string) []Record {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := ReadRecords(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func Test_Recorder_AppendsRecords(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	jobID := 7
+	recorder, err := NewRecorder(RecorderConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.Record(Record{Function: "figlet", Backend: "container", Status: 200})
+	recorder.Record(Record{Function: "echo-lambdanic", JobID: &jobID, SmartNIC: "10.0.0.1"})
+	recorder.Close()
+
+	// Reopening carries on appending to the same file.
+	recorder, err = NewRecorder(RecorderConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.Record(Record{Function: "figlet"})
+	recorder.Close()
+
+	records := readFile(t, path)
+	if len(records) != 3 {
+		t.Fatalf("want 3 records, got: %d", len(records))
+	}
+	if records[1].JobID == nil || *records[1].JobID != 7 || records[1].SmartNIC != "10.0.0.1" {
+		t.Errorf("unexpected record: %+v", records[1])
+	}
+	if records[0].JobID != nil {
+		t.Errorf("want no job ID for container records, got: %d", *records[0].JobID)
+	}
+}
+
+func Test_Recorder_Rotates(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	recorder, err := NewRecorder(RecorderConfig{Path: path, MaxBytes: 150, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+	for i := 0; i < 10; i++ {
+		if err := recorder.Record(Record{Function: "figlet"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("want %s kept: %v", p, err)
+		}
+		if info.Size() > 150 {
+			t.Errorf("want %s rotated at 150 bytes, got: %d", p, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("want only 2 rotated files kept")
+	}
+}
+
+func Test_ReadRecords_ReportsLine(t *testing.T) {
+	_, err := ReadRecords(strings.NewReader("{\"function\":\"a\"}\n\nnot json\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("want error on line 3, got: %v", err)
+	}
+}
+
+func Test_Replay_KeepsSpacing(t *testing.T) {
+	start := time.Now()
+	records := []Record{
+		{Time: start.Add(200 * time.Millisecond), Function: "b"},
+		{Time: start, Function: "a"},
+	}
+
+	mutex := sync.Mutex{}
+	order := []string{}
+	replayStarted := time.Now()
+	results := Replay(records, 2, func(record Record) (int, error) {
+		mutex.Lock()
+		order = append(order, record.Function)
+		mutex.Unlock()
+		return 200, nil
+	})
+
+	if len(order) != 2 || order[0] != "a" || order[1] != "b" {
+		t.Errorf("want invocations in trace order, got: %v", order)
+	}
+	if took := time.Since(replayStarted); took < 90*time.Millisecond || took > 190*time.Millisecond {
+		t.Errorf("want the 200ms spacing halved, took: %s", took)
+	}
+	if results[0].Record.Function != "b" || results[0].Status != 200 {
+		t.Errorf("want results in record order, got: %+v", results[0])
+	}
+}
+
+func Test_Percentile(t *testing.T) {
+	latencies := []time.Duration{}
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	for percentile, want := range map[float64]time.Duration{
+		50: 50 * time.Millisecond, 99: 99 * time.Millisecond, 100: 100 * time.Millisecond,
+	} {
+		if got := Percentile(latencies, percentile); got != want {
+			t.Errorf("want p%g %s, got: %s", percentile, want, got)
+		}
+	}
+	if Percentile(nil, 50) != 0 {
+		t.Errorf("want zero for no latencies")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package recording
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Invoker sends the invocation of a record and returns the status code
+type Invoker func(record Record) (int, error)
+
+// Result is the outcome of a replayed invocation
+type Result struct {
+	Record  Record
+	Status  int
+	Latency time.Duration
+	Err     error
+}
+
+// Replay sends the invocations of the records with the same spacing as in
+// the trace, divided by speed, and returns their results in the order of
+// the records. A speed of 2 replays the trace twice as fast and a speed of
+// zero sends every invocation at once.
+func Replay(records []Record, speed float64, invoke Invoker) []Result {
+	results := make([]Result, len(records))
+	if len(records) == 0 {
+		return results
+	}
+
+	sorted := make([]int, len(records))
+	for i := range sorted {
+		sorted[i] = i
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return records[sorted[i]].Time.Before(records[sorted[j]].Time)
+	})
+	first := records[sorted[0]].Time
+
+	wg := sync.WaitGroup{}
+	started := time.Now()
+	for _, i := range sorted {
+		if speed > 0 {
+			offset := time.Duration(float64(records[i].Time.Sub(first)) / speed)
+			if wait := offset - time.Since(started); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sent := time.Now()
+			status, err := invoke(records[i])
+			results[i] = Result{
+				Record:  records[i],
+				Status:  status,
+				Latency: time.Since(sent),
+				Err:     err,
+			}
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// Percentile returns the latency below which the given percentage of the
+// latencies fall
+func Percentile(latencies []time.Duration, percentile float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(math.Ceil(percentile/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	} else if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
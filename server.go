@@ -7,13 +7,18 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 
 	"github.com/Lambda-NIC/faas-netes/handlers"
+	"github.com/Lambda-NIC/faas-netes/logging"
+	"github.com/Lambda-NIC/faas-netes/recording"
+	"github.com/Lambda-NIC/faas-netes/tracing"
 	"github.com/Lambda-NIC/faas-netes/types"
 	"github.com/Lambda-NIC/faas-netes/version"
 	"github.com/Lambda-NIC/faas-provider"
 	bootTypes "github.com/Lambda-NIC/faas-provider/types"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.etcd.io/etcd/client"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -27,73 +32,30 @@ const etcdPort string = "2379"
 var smartNICs = []string{"10.10.101.101", "10.10.102.101",
 	"10.10.103.101", "10.10.104.101"}
 
-func initializeEtcd(keysAPI client.KeysAPI) {
+func initializeEtcd(keysAPI handlers.Store) {
 	opts := client.SetOptions{Dir: true}
-	resp, err := keysAPI.Set(context.Background(),
-		"/smartnics",
-		"", &opts)
-	if err != nil {
-		log.Println("SmartNIC directory already exists, cleaning...")
-		delopts := client.DeleteOptions{Recursive: true}
-		_, err = keysAPI.Delete(context.Background(), "/smartnics", &delopts)
-		if err != nil {
-			log.Fatal("Error in cleaning smartnic.")
-		}
-		log.Printf("Deleted smartnic, recreating")
-		_, err = keysAPI.Set(context.Background(),
-			"/smartnics",
-			"", &opts)
-		if err != nil {
-			log.Fatal("Could not recreate smartNIC")
-		}
-	} else {
-		// print common key info
-		log.Printf("Added SmartNIC directory to ETCD. Metadata is %q\n",
-			resp)
-	}
-	resp, err = keysAPI.Set(context.Background(),
-		"/deployments",
-		"", &opts)
-	if err != nil {
-		log.Println("Deployments directory already exists, cleaning...")
-		delopts := client.DeleteOptions{Recursive: true}
-		_, err = keysAPI.Delete(context.Background(), "/deployments", &delopts)
+	var resp *client.Response
+	var err error
+	for _, dir := range []string{"/smartnics", "/deployments",
+		"/functions", "/metadata", "/acks"} {
+		resp, err = keysAPI.Set(context.Background(), dir, "", &opts)
 		if err != nil {
-			log.Fatal("Error in cleaning deployments.")
-		}
-		log.Printf("Deleted deployments, recreating")
-		_, err = keysAPI.Set(context.Background(),
-			"/deployments",
-			"", &opts)
-		if err != nil {
-			log.Fatal("Could not recreate deployments")
-		}
-	} else {
-		// print common key info
-		log.Printf("Added Deployments directory to ETCD. Metadata is %q\n",
-			resp)
-	}
-	resp, err = keysAPI.Set(context.Background(),
-		"/functions",
-		"", &opts)
-	if err != nil {
-		log.Println("Functions directory already exists, cleaning...")
-		delopts := client.DeleteOptions{Recursive: true}
-		_, err = keysAPI.Delete(context.Background(), "/functions", &delopts)
-		if err != nil {
-			log.Fatal("Error in cleaning functions.")
-		}
-		log.Printf("Deleted functions, recreating")
-		_, err = keysAPI.Set(context.Background(),
-			"/functions",
-			"", &opts)
-		if err != nil {
-			log.Fatal("Could not recreate functions")
+			logging.Info("Directory already exists, cleaning", "key", dir)
+			delopts := client.DeleteOptions{Recursive: true}
+			_, err = keysAPI.Delete(context.Background(), dir, &delopts)
+			if err != nil {
+				logging.Fatal("Could not clean directory", "key", dir, "error", err)
+			}
+			logging.Info("Deleted directory, recreating", "key", dir)
+			_, err = keysAPI.Set(context.Background(), dir, "", &opts)
+			if err != nil {
+				logging.Fatal("Could not recreate directory", "key", dir, "error", err)
+			}
+		} else {
+			// print common key info
+			logging.Info("Added directory to etcd", "key", dir,
+				"etcd_index", resp.Index)
 		}
-	} else {
-		// print common key info
-		log.Printf("Added Functions directory to ETCD. Metadata is %q\n",
-			resp)
 	}
 
 	for _, smartNIC := range smartNICs {
@@ -102,26 +64,32 @@ func initializeEtcd(keysAPI client.KeysAPI) {
 			fmt.Sprintf("/smartnics/%s", smartNIC),
 			smartNIC, nil)
 		if err != nil {
-			log.Fatal(err)
+			logging.Fatal("Could not add SmartNIC", "smartnic", smartNIC, "error", err)
 		} else {
 			// print common key info
-			log.Printf("Added SmartNIC: %s to ETCD. Metadata is %q\n",
-				smartNIC, resp)
+			logging.Info("Added SmartNIC to etcd", "smartnic", smartNIC,
+				"etcd_index", resp.Index)
 		}
 		// Create the deployment directory for each smartnic.
 		resp, err = keysAPI.Set(context.Background(),
 			fmt.Sprintf("/deployments/smartnic/%s", smartNIC),
 			"", &opts)
 		if err != nil {
-			log.Fatal(err)
+			logging.Fatal("Could not add SmartNIC deployments directory",
+				"smartnic", smartNIC, "error", err)
 		} else {
 			// print common key info
-			log.Printf("Added SmartNIC %s Deployments directory to ETCD. "+
-				"Metadata is %q\n", smartNIC, resp)
+			logging.Info("Added SmartNIC deployments directory to etcd",
+				"smartnic", smartNIC, "etcd_index", resp.Index)
 		}
 	}
 }
 
+// instrument gives a control-plane handler a call ID and traces it
+func instrument(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return logging.Handler(tracing.Handler(name, handler))
+}
+
 func main() {
 	// creates the in-cluster config
 	config, err := rest.InClusterConfig()
@@ -144,11 +112,103 @@ func main() {
 	readConfig := types.ReadConfig{}
 	osEnv := types.OsEnv{}
 	cfg := readConfig.Read(osEnv)
+
+	logLevel, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		logging.Warn("Using info log level", "error", err)
+	}
+	logging.Configure(logLevel, cfg.LogFormat, os.Stderr)
+	// Lines logged by dependencies are written as structured lines too.
+	log.SetFlags(0)
+	log.SetOutput(logging.StdWriter())
+
+	// LambdaNIC: Serve function reads from a cache of the API server.
+	functionCache := handlers.NewFunctionCache(clientset, functionNamespace)
+	functionCache.Run()
+
 	keysAPI := handlers.CreateEtcdClient(etcdMasterIP, etcdPort)
 	initializeEtcd(keysAPI)
 
-	log.Printf("HTTP Read Timeout: %s\n", cfg.ReadTimeout)
-	log.Printf("HTTP Write Timeout: %s\n", cfg.WriteTimeout)
+	traceExporter, err := tracing.NewExporter(cfg.TraceExporter, cfg.TraceFile)
+	if err != nil {
+		logging.Fatal("Could not create trace exporter", "error", err)
+	}
+	tracing.SetExporter(traceExporter)
+
+	logging.Info("HTTP timeouts", "read_timeout", cfg.ReadTimeout,
+		"write_timeout", cfg.WriteTimeout)
+
+	routingTable := handlers.NewRoutingTable(keysAPI)
+	if err = routingTable.Refresh(); err != nil {
+		logging.Warn("Could not load routing table", "error", err)
+	}
+	go routingTable.Run(cfg.RoutingRefreshInterval)
+
+	// LambdaNIC: Every provider replica counts invocations in its own shard.
+	replica := cfg.ReplicaID
+	if len(replica) == 0 {
+		if replica, err = os.Hostname(); err != nil {
+			panic(err.Error())
+		}
+	}
+	invocationCounter := handlers.NewInvocationCounter(keysAPI, replica)
+	if err = invocationCounter.Load(); err != nil {
+		logging.Warn("Could not load invocation counts", "error", err)
+	}
+	go invocationCounter.Run(cfg.InvocationFlushInterval)
+
+	router := handlers.NewSmartNICRouter(cfg.NICRoutingMode,
+		&handlers.CircuitBreakerConfig{
+			ConsecutiveFailures: cfg.NICBreakerFailures,
+			ErrorRate:           cfg.NICBreakerErrorRate,
+			OpenDuration:        cfg.NICBreakerOpenDuration,
+		})
+
+	// LambdaNIC: Autoscale SmartNIC functions from their invocations.
+	if cfg.NICAutoscaleInterval > 0 {
+		autoscaler := handlers.NewNICAutoscaler(keysAPI, router, invocationCounter,
+			handlers.NICAutoscalerConfig{
+				TargetRPS:         cfg.NICAutoscaleTargetRPS,
+				TargetLatency:     cfg.NICAutoscaleTargetLatency,
+				LatencyPercentile: cfg.NICAutoscaleLatencyPercentile,
+				MaxReplicas:       uint64(cfg.NICAutoscaleMaxReplicas),
+				ScaleUpCooldown:   cfg.NICAutoscaleUpCooldown,
+				ScaleDownCooldown: cfg.NICAutoscaleDownCooldown,
+				ScaleUpWindow:     cfg.NICAutoscaleUpWindow,
+				ScaleDownWindow:   cfg.NICAutoscaleDownWindow,
+			})
+		go autoscaler.Run(cfg.NICAutoscaleInterval)
+	}
+
+	// LambdaNIC: Record invocations for offline replay.
+	var invocationRecorder *recording.Recorder
+	if len(cfg.InvocationTraceFile) > 0 {
+		invocationRecorder, err = recording.NewRecorder(recording.RecorderConfig{
+			Path:     cfg.InvocationTraceFile,
+			MaxBytes: int64(cfg.InvocationTraceMaxBytes),
+			MaxFiles: cfg.InvocationTraceMaxFiles,
+		})
+		if err != nil {
+			logging.Fatal("Could not open invocation trace", "error", err)
+		}
+	}
+
+	// LambdaNIC: Scale idle container functions to zero and back up.
+	functionScaler := handlers.NewFunctionScaler(functionNamespace, clientset,
+		functionCache, invocationCounter, handlers.ScalingConfig{
+			IdleDuration:         cfg.ScaleZeroDuration,
+			ScaleFromZeroTimeout: cfg.ScaleFromZeroTimeout,
+		})
+	if cfg.ScaleZeroInterval > 0 {
+		go functionScaler.Run(cfg.ScaleZeroInterval)
+	}
+
+	// LambdaNIC: Schedule containers on the node pool of their function.
+	nodePools, err := handlers.NewNodePoolPolicy(cfg.NICNodeConstraints,
+		cfg.ContainerNodeConstraints)
+	if err != nil {
+		logging.Fatal("Invalid node-pool policy", "error", err)
+	}
 
 	deployConfig := &handlers.DeployHandlerConfig{
 		HTTPProbe: cfg.HTTPProbe,
@@ -163,37 +223,87 @@ func main() {
 			PeriodSeconds:       int32(cfg.LivenessProbePeriodSeconds),
 		},
 		ImagePullPolicy: cfg.ImagePullPolicy,
+		NodePools:       nodePools,
+		WaitTimeout:     cfg.DeployWaitTimeout,
+		WriteTimeout:    cfg.WriteTimeout,
 	}
 
 	bootstrapHandlers := bootTypes.FaaSHandlers{
-		FunctionProxy: handlers.MakeProxy(functionNamespace,
-			keysAPI,
+		FunctionProxy: logging.Handler(handlers.MakeProxy(functionNamespace,
+			routingTable,
+			router,
 			cfg.ReadTimeout,
-			smartNICs),
-		DeleteHandler: handlers.MakeDeleteHandler(functionNamespace,
+			&handlers.ProxyConfig{
+				NICTimeout:        cfg.NICTimeout,
+				MaxInflightPerNIC: cfg.NICMaxInflight,
+				HedgeDelay:        cfg.NICHedgeDelay,
+				Recorder:          invocationRecorder,
+				SmartNICPort:      cfg.NICPort,
+				BareMetalPort:     cfg.BareMetalPort,
+				Scaler:            functionScaler,
+				WriteTimeout:      cfg.WriteTimeout,
+			})),
+		DeleteHandler: instrument("delete", handlers.MakeDeleteHandler(functionNamespace,
 			keysAPI,
-			clientset),
-		DeployHandler: handlers.MakeDeployHandler(functionNamespace,
+			clientset,
+			invocationCounter)),
+		DeployHandler: instrument("deploy", handlers.MakeDeployHandler(functionNamespace,
 			keysAPI,
 			clientset,
-			deployConfig),
-		FunctionReader: handlers.MakeFunctionReader(functionNamespace,
+			deployConfig)),
+		FunctionReader: instrument("list functions", handlers.MakeFunctionReader(functionNamespace,
 			keysAPI,
-			clientset),
-		ReplicaReader: handlers.MakeReplicaReader(functionNamespace,
+			clientset,
+			functionCache,
+			invocationCounter)),
+		ReplicaReader: instrument("read replicas", handlers.MakeReplicaReader(functionNamespace,
 			keysAPI,
-			clientset),
-		ReplicaUpdater: handlers.MakeReplicaUpdater(functionNamespace,
+			clientset,
+			functionCache,
+			invocationCounter)),
+		ReplicaUpdater: instrument("scale", handlers.MakeReplicaUpdater(functionNamespace,
 			keysAPI,
-			clientset),
-		UpdateHandler: handlers.MakeUpdateHandler(functionNamespace,
+			clientset)),
+		UpdateHandler: instrument("update", handlers.MakeUpdateHandler(functionNamespace,
 			keysAPI,
-			clientset),
+			clientset,
+			deployConfig)),
 		Health: handlers.MakeHealthHandler(),
 		InfoHandler: handlers.MakeInfoHandler(version.BuildVersion(),
-			version.GitCommit),
+			version.GitCommit,
+			routingTable,
+			map[string]bool{
+				"hybrid":         true,
+				"hedging":        true,
+				"maxInflight":    cfg.NICMaxInflight > 0,
+				"circuitBreaker": cfg.NICBreakerFailures > 0 || cfg.NICBreakerErrorRate > 0,
+				"scaleToZero":    cfg.ScaleZeroInterval > 0,
+				"nicAutoscaler":  cfg.NICAutoscaleInterval > 0,
+			}),
 	}
 
+	// LambdaNIC: Inspection endpoints for SmartNIC deployments.
+	bootstrap.Router().HandleFunc("/system/smartnics/{ip}",
+		logging.Handler(handlers.MakeSmartNICReader(keysAPI, router))).Methods("GET")
+	bootstrap.Router().HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}/placement",
+		logging.Handler(handlers.MakePlacementReader(keysAPI, router))).Methods("GET")
+	bootstrap.Router().HandleFunc("/system/alert", instrument("alert",
+		handlers.MakeAlertHandler(functionNamespace, keysAPI, clientset,
+			uint64(cfg.AlertScaleStep)))).Methods("POST")
+	bootstrap.Router().Handle("/metrics", promhttp.Handler()).Methods("GET")
+	if memoryExporter, ok := traceExporter.(*tracing.MemoryExporter); ok {
+		bootstrap.Router().HandleFunc("/system/traces",
+			tracing.MakeSpansHandler(memoryExporter)).Methods("GET")
+	}
+	bootstrap.Router().HandleFunc("/readyz", handlers.MakeReadinessHandler(
+		map[string]handlers.ReadinessCheck{
+			handlers.ReadinessEtcd:       handlers.EtcdReadinessCheck(keysAPI),
+			handlers.ReadinessKubernetes: handlers.KubernetesReadinessCheck(clientset),
+			handlers.ReadinessSmartNIC:   handlers.SmartNICReadinessCheck(routingTable),
+			handlers.ReadinessCache:      handlers.FunctionCacheReadinessCheck(functionCache),
+		},
+		cfg.ReadinessChecks)).Methods("GET")
+
 	var port int
 	port = cfg.Port
 
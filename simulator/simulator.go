@@ -0,0 +1,339 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+// Package simulator replays an invocation trace on a simulated SmartNIC
+// inventory with the placement and routing code of the provider, without
+// etcd or network.
+package simulator
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/handlers"
+	"github.com/Lambda-NIC/faas-netes/recording"
+	"github.com/Lambda-NIC/faas-netes/types"
+)
+
+// NIC is a SmartNIC of the simulated inventory
+type NIC struct {
+	Address string `json:"address"`
+	// Capacity is how many invocations the SmartNIC serves at once, those
+	// arriving while it is full are dropped
+	Capacity int `json:"capacity"`
+	// Speed divides the service times of the functions, a SmartNIC with a
+	// speed of 2 serves invocations twice as fast. Defaults to 1.
+	Speed float64 `json:"speed,omitempty"`
+	// Health is the health the router sees, healthy when empty. Unhealthy
+	// SmartNICs drop every invocation.
+	Health string `json:"health,omitempty"`
+}
+
+// Function is a function of the simulated function set
+type Function struct {
+	Name     string `json:"name"`
+	Replicas uint64 `json:"replicas"`
+	// ServiceTime is the mean time a SmartNIC with a speed of 1 takes to
+	// serve an invocation, service times are exponentially distributed
+	ServiceTime Duration          `json:"serviceTime"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Duration is read from JSON as text such as 250us or as nanoseconds
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON reads a duration
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		nanos, numErr := strconv.ParseInt(string(data), 10, 64)
+		if numErr != nil {
+			return fmt.Errorf("invalid duration: %s", data)
+		}
+		d.Duration = time.Duration(nanos)
+		return nil
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// MarshalJSON writes a duration as text
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
+}
+
+// Config is a simulation of one placement strategy and routing mode
+type Config struct {
+	NICs      []NIC
+	Functions []Function
+	// Placement is the placement strategy of every function
+	Placement string
+	// Routing is the routing mode of functions which do not select one
+	Routing string
+	// MaxInflight is the nic_max_inflight of the provider
+	MaxInflight int
+	// Timeout is how long the proxy waits for a dropped invocation
+	Timeout time.Duration
+	// Breaker configures the circuit breakers, nil never opens them
+	Breaker *handlers.CircuitBreakerConfig
+	// Seed makes the random choices of a simulation repeatable
+	Seed int64
+}
+
+// Counts are the outcomes of the invocations of a simulation
+type Counts struct {
+	Invocations int
+	// Served invocations were answered by a SmartNIC
+	Served int
+	// Rejected invocations were dropped by a SmartNIC serving at capacity
+	Rejected int
+	// Failed invocations were dropped by an unhealthy SmartNIC
+	Failed int
+	// Unrouted invocations found no SmartNIC whose circuit was not open
+	Unrouted int
+	// Overflowed invocations of hybrid functions went to their container
+	Overflowed int
+	// Latencies of the served invocations
+	Latencies []time.Duration
+}
+
+// Latency returns the latency below which the given percentage of the
+// served invocations completed
+func (c *Counts) Latency(percentile float64) time.Duration {
+	return recording.Percentile(c.Latencies, percentile)
+}
+
+// NICReport is how a SmartNIC was used during a simulation
+type NICReport struct {
+	Address  string
+	Replicas uint64
+	Counts
+	// Utilization is the share of the capacity of the SmartNIC which was
+	// busy serving invocations
+	Utilization float64
+}
+
+// Report is the outcome of a simulation
+type Report struct {
+	Placement string
+	Routing   string
+	Counts
+	// Skipped records are of functions missing from the function set
+	Skipped   int
+	Functions map[string]*Counts
+	NICs      []*NICReport
+	// Duration is the simulated time from the first invocation to the
+	// last completion
+	Duration time.Duration
+}
+
+// hybridAnnotation marks the functions which overflow to their container, as
+// read by the proxy
+const hybridAnnotation = "com.lambdanic.hybrid"
+
+// errDropped is seen by the router when a SmartNIC dropped an invocation
+var errDropped = errors.New("invocation dropped by SmartNIC")
+
+// completion is an invocation finishing on a SmartNIC
+type completion struct {
+	at       time.Time
+	function string
+	smartNIC string
+	probe    bool
+	latency  time.Duration
+	err      error
+}
+
+type completions []completion
+
+func (c completions) Len() int            { return len(c) }
+func (c completions) Less(i, j int) bool  { return c[i].at.Before(c[j].at) }
+func (c completions) Swap(i, j int)       { c[i], c[j] = c[j], c[i] }
+func (c *completions) Push(x interface{}) { *c = append(*c, x.(completion)) }
+func (c *completions) Pop() interface{} {
+	old := *c
+	last := old[len(old)-1]
+	*c = old[:len(old)-1]
+	return last
+}
+
+// Run replays the records of a trace on the SmartNICs of the config. The
+// invocations arrive at their recorded times, are routed by the router of
+// the provider and complete on simulated time.
+func Run(config Config, records []recording.Record) (*Report, error) {
+	if len(config.NICs) == 0 {
+		return nil, fmt.Errorf("no SmartNICs in the inventory")
+	}
+	rnd := rand.New(rand.NewSource(config.Seed))
+
+	report := &Report{
+		Placement: config.Placement,
+		Routing:   config.Routing,
+		Functions: map[string]*Counts{},
+	}
+	addresses := []string{}
+	health := map[string]string{}
+	nics := map[string]*NIC{}
+	nicReports := map[string]*NICReport{}
+	for i := range config.NICs {
+		nic := config.NICs[i]
+		if nic.Capacity <= 0 {
+			return nil, fmt.Errorf("SmartNIC %s has no capacity", nic.Address)
+		}
+		if nic.Speed <= 0 {
+			nic.Speed = 1
+		}
+		if len(nic.Health) == 0 {
+			nic.Health = handlers.SmartNICHealthy
+		}
+		addresses = append(addresses, nic.Address)
+		health[nic.Address] = nic.Health
+		nics[nic.Address] = &nic
+		nicReports[nic.Address] = &NICReport{Address: nic.Address}
+		report.NICs = append(report.NICs, nicReports[nic.Address])
+	}
+	sort.Strings(addresses)
+
+	functions := map[string]Function{}
+	placements := map[string]handlers.Placement{}
+	metas := map[string]types.FunctionMeta{}
+	for _, function := range config.Functions {
+		placement, err := handlers.PlaceReplicas(config.Placement, addresses,
+			function.Replicas, rnd)
+		if err != nil {
+			return nil, err
+		}
+		for smartNIC, numDeps := range placement {
+			nicReports[smartNIC].Replicas += numDeps
+		}
+		functions[function.Name] = function
+		placements[function.Name] = placement
+		metas[function.Name] = types.FunctionMeta{Annotations: function.Annotations}
+		report.Functions[function.Name] = &Counts{}
+	}
+
+	routingTable := handlers.NewRoutingTable(nil)
+	routingTable.Load(addresses, health, placements, metas)
+	router := handlers.NewSmartNICRouter(config.Routing, config.Breaker)
+	clock := time.Time{}
+	router.SetClock(func() time.Time { return clock })
+	router.SetRand(rnd)
+
+	sorted := make([]recording.Record, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+
+	pending := &completions{}
+	serving := map[string]int{}
+	busy := map[string]time.Duration{}
+	// complete releases the invocations which finished by until
+	complete := func(until time.Time) {
+		for pending.Len() > 0 && !(*pending)[0].at.After(until) {
+			done := heap.Pop(pending).(completion)
+			clock = done.at
+			if done.err == nil {
+				serving[done.smartNIC]--
+			}
+			router.Release(done.function, done.smartNIC, done.probe, done.latency, done.err)
+		}
+	}
+
+	var started time.Time
+	for _, record := range sorted {
+		function, ok := functions[record.Function]
+		if !ok {
+			report.Skipped++
+			continue
+		}
+		complete(record.Time)
+		clock = record.Time
+		if started.IsZero() {
+			started = clock
+		}
+
+		counts := []*Counts{&report.Counts, report.Functions[function.Name]}
+		smartNIC, _, probe := router.Select(function.Name, routingTable, config.MaxInflight)
+		if len(smartNIC) > 0 {
+			counts = append(counts, &nicReports[smartNIC].Counts)
+		}
+		for _, c := range counts {
+			c.Invocations++
+		}
+
+		nic := nics[smartNIC]
+		switch {
+		case len(smartNIC) == 0 && function.Annotations[hybridAnnotation] == "true":
+			for _, c := range counts {
+				c.Overflowed++
+			}
+		case len(smartNIC) == 0:
+			for _, c := range counts {
+				c.Unrouted++
+			}
+		case nic.Health == handlers.SmartNICUnhealthy || serving[smartNIC] >= nic.Capacity:
+			for _, c := range counts {
+				if nic.Health == handlers.SmartNICUnhealthy {
+					c.Failed++
+				} else {
+					c.Rejected++
+				}
+			}
+			heap.Push(pending, completion{
+				at:       clock.Add(config.Timeout),
+				function: function.Name,
+				smartNIC: smartNIC,
+				probe:    probe,
+				latency:  config.Timeout,
+				err:      errDropped,
+			})
+		default:
+			mean := float64(function.ServiceTime.Duration) / nic.Speed
+			latency := time.Duration(rnd.ExpFloat64() * mean)
+			serving[smartNIC]++
+			busy[smartNIC] += latency
+			for _, c := range counts {
+				c.Served++
+				c.Latencies = append(c.Latencies, latency)
+			}
+			heap.Push(pending, completion{
+				at:       clock.Add(latency),
+				function: function.Name,
+				smartNIC: smartNIC,
+				probe:    probe,
+				latency:  latency,
+			})
+		}
+	}
+	if pending.Len() > 0 {
+		last := (*pending)[0].at
+		for _, c := range *pending {
+			if c.at.After(last) {
+				last = c.at
+			}
+		}
+		complete(last)
+	}
+
+	report.Duration = clock.Sub(started)
+	for _, nicReport := range report.NICs {
+		capacity := float64(nics[nicReport.Address].Capacity)
+		if report.Duration > 0 {
+			nicReport.Utilization = float64(busy[nicReport.Address]) /
+				(capacity * float64(report.Duration))
+		}
+	}
+	return report, nil
+}
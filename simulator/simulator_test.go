@@ -0,0 +1,135 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package simulator
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/handlers"
+	"github.com/Lambda-NIC/faas-netes/recording"
+)
+
+// trace returns n invocations of function spaced by interval
+func trace(function string, n int, interval time.Duration) []recording.Record {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []recording.Record{}
+	for i := 0; i < n; i++ {
+		records = append(records, recording.Record{
+			Time:     start.Add(time.Duration(i) * interval),
+			Function: function,
+		})
+	}
+	return records
+}
+
+func Test_Run_ServesWithinCapacity(t *testing.T) {
+	config := Config{
+		NICs: []NIC{{Address: "10.0.0.1", Capacity: 1}, {Address: "10.0.0.2", Capacity: 1}},
+		Functions: []Function{{Name: "echo-lambdanic", Replicas: 2,
+			ServiceTime: Duration{time.Millisecond}}},
+		Placement: handlers.PlacementSpread,
+		Routing:   handlers.RoutingRandom,
+		Timeout:   time.Second,
+	}
+	records := append(trace("echo-lambdanic", 100, time.Second), trace("figlet", 3, time.Second)...)
+
+	report, err := Run(config, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Invocations != 100 || report.Served != 100 || report.Skipped != 3 {
+		t.Errorf("want every invocation served and others skipped, got: %d %d %d",
+			report.Invocations, report.Served, report.Skipped)
+	}
+	if report.NICs[0].Replicas != 1 || report.NICs[1].Replicas != 1 {
+		t.Errorf("want a replica on each SmartNIC")
+	}
+	if report.NICs[0].Served+report.NICs[1].Served != 100 || report.NICs[0].Served == 0 {
+		t.Errorf("want invocations routed over both SmartNICs, got: %d %d",
+			report.NICs[0].Served, report.NICs[1].Served)
+	}
+	if report.NICs[0].Utilization <= 0 || report.NICs[0].Utilization > 0.01 {
+		t.Errorf("want a low utilization, got: %f", report.NICs[0].Utilization)
+	}
+	if report.Latency(50) <= 0 {
+		t.Errorf("want latencies of the served invocations")
+	}
+}
+
+func Test_Run_RejectsOverCapacityAndOverflowsHybrid(t *testing.T) {
+	config := Config{
+		NICs: []NIC{{Address: "10.0.0.1", Capacity: 1}},
+		Functions: []Function{
+			{Name: "slow-lambdanic", Replicas: 1, ServiceTime: Duration{time.Second}},
+			{Name: "hybrid-lambdanic", Replicas: 1, ServiceTime: Duration{time.Second},
+				Annotations: map[string]string{hybridAnnotation: "true"}},
+		},
+		Placement:   handlers.PlacementSpread,
+		Routing:     handlers.RoutingRandom,
+		MaxInflight: 1,
+		Timeout:     time.Second,
+	}
+	records := append(trace("slow-lambdanic", 10, time.Microsecond),
+		trace("hybrid-lambdanic", 10, time.Microsecond)...)
+
+	report, err := Run(config, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rejected == 0 {
+		t.Errorf("want invocations beyond the capacity rejected")
+	}
+	if report.Functions["hybrid-lambdanic"].Overflowed == 0 {
+		t.Errorf("want the saturated hybrid function to overflow")
+	}
+	if report.Functions["slow-lambdanic"].Overflowed != 0 {
+		t.Errorf("want only hybrid functions to overflow")
+	}
+	total := report.Served + report.Rejected + report.Failed + report.Unrouted + report.Overflowed
+	if total != report.Invocations {
+		t.Errorf("want every invocation accounted for, got %d of %d", total, report.Invocations)
+	}
+}
+
+func Test_Run_IsRepeatable(t *testing.T) {
+	config := Config{
+		NICs: []NIC{{Address: "10.0.0.1", Capacity: 2}, {Address: "10.0.0.2", Capacity: 2}},
+		Functions: []Function{{Name: "echo-lambdanic", Replicas: 3,
+			ServiceTime: Duration{5 * time.Millisecond}}},
+		Placement: handlers.PlacementRandom,
+		Routing:   handlers.RoutingAdaptive,
+		Timeout:   100 * time.Millisecond,
+		Seed:      3,
+	}
+	records := trace("echo-lambdanic", 500, time.Millisecond)
+
+	first, err := Run(config, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The simulation does not depend on the default source.
+	rand.Seed(time.Now().UnixNano())
+	rand.Int()
+	second, _ := Run(config, records)
+	if first.Served != second.Served || first.Latency(99) != second.Latency(99) ||
+		!reflect.DeepEqual(first.NICs, second.NICs) {
+		t.Errorf("want the same report for the same seed")
+	}
+}
+
+func Test_Duration_UnmarshalJSON(t *testing.T) {
+	functions := []Function{}
+	err := json.Unmarshal([]byte(`[{"name":"a","serviceTime":"250us"},{"name":"b","serviceTime":1000}]`),
+		&functions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if functions[0].ServiceTime.Duration != 250*time.Microsecond ||
+		functions[1].ServiceTime.Duration != time.Microsecond {
+		t.Errorf("unexpected service times: %v", functions)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Lambda-NIC/faas-netes/test/harness"
+)
+
+// alert returns the body Alertmanager posts for an alert of a function
+func alert(status string, functionName string) string {
+	return fmt.Sprintf(`{"status":%q,"receiver":"scale-up","alerts":[{"status":%q,"labels":{"alertname":"APIHighInvocationRate","function_name":%q}}]}`,
+		status, status, functionName)
+}
+
+func Test_Flow_AlertScaling(t *testing.T) {
+	h := startHarness(t, harness.Config{SmartNICs: 2, AlertScaleStep: 2})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest","labels":{"com.openfaas.scale.min":"2","com.openfaas.scale.max":"5"}}`,
+		http.StatusAccepted)
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo-lambdanic","image":"smartnic","labels":{"com.openfaas.scale.max":"4"}}`,
+		http.StatusAccepted)
+
+	steps := []struct {
+		status        string
+		wantContainer float64
+		wantNIC       uint64
+	}{
+		{status: "firing", wantContainer: 4, wantNIC: 3},
+		{status: "firing", wantContainer: 5, wantNIC: 4},
+		{status: "resolved", wantContainer: 3, wantNIC: 2},
+		{status: "resolved", wantContainer: 2, wantNIC: 1},
+	}
+	for i, step := range steps {
+		do(t, h, http.MethodPost, "/system/alert", alert(step.status, "echo"), http.StatusOK)
+		do(t, h, http.MethodPost, "/system/alert", alert(step.status, "echo-lambdanic"), http.StatusOK)
+
+		deployment := h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "echo")
+		if replicas := specReplicas(deployment); replicas != step.wantContainer {
+			t.Errorf("%d %s: want the container scaled to %v, got: %v", i, step.status,
+				step.wantContainer, replicas)
+		}
+		if function := readReplicas(t, h, "echo-lambdanic"); function.Replicas != step.wantNIC {
+			t.Errorf("%d %s: want the SmartNIC function scaled to %d, got: %d", i, step.status,
+				step.wantNIC, function.Replicas)
+		}
+	}
+
+	// Alerts of functions which do not exist are acknowledged.
+	do(t, h, http.MethodPost, "/system/alert", alert("firing", "missing"), http.StatusOK)
+	do(t, h, http.MethodPost, "/system/alert", alert("firing", "missing-lambdanic"), http.StatusOK)
+	do(t, h, http.MethodPost, "/system/alert", "{", http.StatusBadRequest)
+}
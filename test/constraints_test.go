@@ -0,0 +1,136 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Lambda-NIC/faas-netes/handlers"
+	"github.com/Lambda-NIC/faas-netes/test/harness"
+)
+
+// podSpec returns the pod template spec of the deployment of a function
+func podSpec(h *harness.Harness, name string) map[string]interface{} {
+	deployment := h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", name)
+	spec, _ := deployment["spec"].(map[string]interface{})
+	template, _ := spec["template"].(map[string]interface{})
+	podSpec, _ := template["spec"].(map[string]interface{})
+	return podSpec
+}
+
+func Test_Flow_SchedulingConstraints(t *testing.T) {
+	h := startHarness(t, harness.Config{})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest","constraints":["zone=west","tier in (fast,gpu)","toleration:dedicated=nic:NoSchedule"],"annotations":{"com.lambdanic.spread":"required"}}`,
+		http.StatusAccepted)
+	spec := podSpec(h, "echo")
+	if selector, _ := spec["nodeSelector"].(map[string]interface{}); selector["zone"] != "west" {
+		t.Errorf("want the equality kept as a node selector, got: %v", spec["nodeSelector"])
+	}
+	affinity, _ := spec["affinity"].(map[string]interface{})
+	if affinity["nodeAffinity"] == nil || affinity["podAntiAffinity"] == nil {
+		t.Errorf("want a node affinity and a pod anti-affinity, got: %v", affinity)
+	}
+	if tolerations, _ := spec["tolerations"].([]interface{}); len(tolerations) != 1 {
+		t.Errorf("want the toleration, got: %v", spec["tolerations"])
+	}
+
+	do(t, h, http.MethodPut, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest","constraints":["tier notin (spot"]}`,
+		http.StatusBadRequest)
+	if spec = podSpec(h, "echo"); spec["affinity"] == nil {
+		t.Errorf("want the scheduling left alone by an invalid update")
+	}
+
+	do(t, h, http.MethodPut, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest","constraints":["zone=east"]}`,
+		http.StatusAccepted)
+	spec = podSpec(h, "echo")
+	if selector, _ := spec["nodeSelector"].(map[string]interface{}); selector["zone"] != "east" {
+		t.Errorf("want the node selector updated, got: %v", spec["nodeSelector"])
+	}
+	if spec["affinity"] != nil || spec["tolerations"] != nil {
+		t.Errorf("want the affinity and tolerations removed, got: %v %v", spec["affinity"], spec["tolerations"])
+	}
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"other","image":"functions/echo:latest","constraints":["toleration:dedicated:Never"]}`,
+		http.StatusBadRequest)
+	if h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "other") != nil {
+		t.Errorf("want no deployment for invalid constraints")
+	}
+}
+
+// readConstraints returns the constraints shown in the read output of a
+// function
+func readConstraints(t *testing.T, h *harness.Harness, name string) []string {
+	function := readReplicas(t, h, name)
+	if function.Annotations == nil {
+		return nil
+	}
+	var constraints []string
+	if value, ok := (*function.Annotations)["com.lambdanic.constraints"]; ok {
+		if err := json.Unmarshal([]byte(value), &constraints); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return constraints
+}
+
+func Test_Flow_NodePoolPolicy(t *testing.T) {
+	policy, err := handlers.NewNodePoolPolicy("smartnic=enabled", "pool=general")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := startHarness(t, harness.Config{Deploy: handlers.DeployHandlerConfig{NodePools: policy}})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest","constraints":["zone=west","pool=general"]}`,
+		http.StatusAccepted)
+	selector, _ := podSpec(h, "echo")["nodeSelector"].(map[string]interface{})
+	if len(selector) != 2 || selector["pool"] != "general" || selector["zone"] != "west" {
+		t.Errorf("want the policy merged with the constraints, got: %v", selector)
+	}
+	var constraints []string
+	if !eventually(func() bool {
+		constraints = readConstraints(t, h, "echo")
+		return reflect.DeepEqual(constraints, []string{"pool=general", "zone=west"})
+	}) {
+		t.Errorf("want the merged constraints read, got: %v", constraints)
+	}
+
+	// An update without constraints keeps the policy.
+	do(t, h, http.MethodPut, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest"}`, http.StatusAccepted)
+	selector, _ = podSpec(h, "echo")["nodeSelector"].(map[string]interface{})
+	if len(selector) != 1 || selector["pool"] != "general" {
+		t.Errorf("want only the policy left, got: %v", selector)
+	}
+	do(t, h, http.MethodPut, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest","constraints":["pool=nic"]}`,
+		http.StatusBadRequest)
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"other","image":"functions/echo:latest","constraints":["pool!=general"]}`,
+		http.StatusBadRequest)
+
+	// The containers of hybrid functions go to the SmartNIC node pool.
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo-lambdanic","image":"functions/echo:latest","annotations":{"com.lambdanic.hybrid":"true"}}`,
+		http.StatusAccepted)
+	selector, _ = podSpec(h, "echo-lambdanic")["nodeSelector"].(map[string]interface{})
+	if len(selector) != 1 || selector["smartnic"] != "enabled" {
+		t.Errorf("want the SmartNIC node pool, got: %v", selector)
+	}
+	if !eventually(func() bool {
+		constraints = readConstraints(t, h, "echo-lambdanic")
+		return reflect.DeepEqual(constraints, []string{"smartnic=enabled"})
+	}) {
+		t.Errorf("want the constraints of the container read, got: %v", constraints)
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/handlers"
+	"github.com/Lambda-NIC/faas-netes/test/harness"
+	"github.com/Lambda-NIC/faas/gateway/requests"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func startHarness(t *testing.T, config harness.Config) *harness.Harness {
+	h, err := harness.New(config)
+	if err != nil {
+		t.Skipf("cannot start harness: %v", err)
+	}
+	return h
+}
+
+func do(t *testing.T, h *harness.Harness, method, path, body string, want int) string {
+	status, resBody, err := h.Do(method, path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != want {
+		t.Fatalf("%s %s: want status %d, got: %d %q", method, path, want, status, resBody)
+	}
+	return resBody
+}
+
+func readReplicas(t *testing.T, h *harness.Harness, name string) requests.Function {
+	function := requests.Function{}
+	body := do(t, h, http.MethodGet, "/system/function/"+name, "", http.StatusOK)
+	if err := json.Unmarshal([]byte(body), &function); err != nil {
+		t.Fatal(err)
+	}
+	return function
+}
+
+func listFunctions(t *testing.T, h *harness.Harness) []requests.Function {
+	functions := []requests.Function{}
+	body := do(t, h, http.MethodGet, "/system/functions", "", http.StatusOK)
+	if err := json.Unmarshal([]byte(body), &functions); err != nil {
+		t.Fatal(err)
+	}
+	return functions
+}
+
+// eventually retries condition until it holds, as container functions are
+// read from a cache which follows the API server
+func eventually(condition func() bool) bool {
+	for started := time.Now(); time.Since(started) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_Flow_Container(t *testing.T) {
+	h := startHarness(t, harness.Config{})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest","labels":{"team":"a"}}`,
+		http.StatusAccepted)
+	deployment := h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "echo")
+	if deployment == nil {
+		t.Fatalf("want a deployment for the function")
+	}
+	if h.APIServer.Object("/api/v1", "default", "services", "echo") == nil {
+		t.Errorf("want a service for the function")
+	}
+
+	var functions []requests.Function
+	listed := eventually(func() bool {
+		functions = listFunctions(t, h)
+		return len(functions) == 1
+	})
+	if !listed || functions[0].Name != "echo" || functions[0].Image != "functions/echo:latest" {
+		t.Errorf("want the function listed, got: %+v", functions)
+	}
+
+	if reply := do(t, h, http.MethodPost, "/function/echo", "hello", http.StatusOK); reply != "hello" {
+		t.Errorf("want the reply of the container, got: %q", reply)
+	}
+
+	do(t, h, http.MethodPut, "/system/functions",
+		`{"service":"echo","image":"functions/echo:0.2","labels":{"team":"b"}}`,
+		http.StatusAccepted)
+	if image := containerImage(h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "echo")); image != "functions/echo:0.2" {
+		t.Errorf("want the image updated, got: %q", image)
+	}
+
+	do(t, h, http.MethodPost, "/system/scale-function/echo",
+		`{"serviceName":"echo","replicas":3}`, http.StatusAccepted)
+	var function requests.Function
+	if !eventually(func() bool {
+		function = readReplicas(t, h, "echo")
+		return function.Replicas == 3 && function.AvailableReplicas == 3
+	}) {
+		t.Errorf("want 3 replicas, got: %d available of %d", function.AvailableReplicas, function.Replicas)
+	}
+
+	do(t, h, http.MethodDelete, "/system/functions", `{"functionName":"echo"}`, http.StatusAccepted)
+	if h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "echo") != nil {
+		t.Errorf("want the deployment deleted")
+	}
+	do(t, h, http.MethodPost, "/function/echo", "hello", http.StatusInternalServerError)
+	if !eventually(func() bool {
+		status, _, _ := h.Do(http.MethodGet, "/system/function/echo", "")
+		return status == http.StatusNotFound
+	}) {
+		t.Errorf("want the deleted function not found")
+	}
+}
+
+// containerImage returns the image of the first container of a deployment
+func containerImage(deployment map[string]interface{}) string {
+	spec, _ := deployment["spec"].(map[string]interface{})
+	template, _ := spec["template"].(map[string]interface{})
+	podSpec, _ := template["spec"].(map[string]interface{})
+	containers, _ := podSpec["containers"].([]interface{})
+	if len(containers) == 0 {
+		return ""
+	}
+	image, _ := containers[0].(map[string]interface{})["image"].(string)
+	return image
+}
+
+// Test_Flow_LegacyDeployment runs a function created under
+// extensions/v1beta1, which selects every label its pods had at the time.
+func Test_Flow_LegacyDeployment(t *testing.T) {
+	h := startHarness(t, harness.Config{})
+	defer h.Close()
+	h.APIServer.Put(harness.LegacyDeploymentPrefix, "default", "deployments", map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "legacy"},
+		"spec": map[string]interface{}{
+			"replicas": 1,
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"labels": map[string]interface{}{"faas_function": "legacy", "uid": "1", "team": "a"},
+				},
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "legacy", "image": "functions/legacy:0.1"},
+					},
+				},
+			},
+		},
+	})
+	h.APIServer.Put("/api/v1", "default", "services", map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "legacy"},
+	})
+
+	var functions []requests.Function
+	listed := eventually(func() bool {
+		functions = listFunctions(t, h)
+		return len(functions) == 1
+	})
+	if !listed || functions[0].Name != "legacy" {
+		t.Errorf("want the legacy function listed, got: %+v", functions)
+	}
+
+	do(t, h, http.MethodPut, "/system/functions",
+		`{"service":"legacy","image":"functions/legacy:0.2","labels":{"team":"b"}}`,
+		http.StatusAccepted)
+	deployment := h.APIServer.Object(harness.LegacyDeploymentPrefix, "default", "deployments", "legacy")
+	if image := containerImage(deployment); image != "functions/legacy:0.2" {
+		t.Errorf("want the legacy deployment updated, got: %q", image)
+	}
+	if h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "legacy") != nil {
+		t.Errorf("want no apps/v1 deployment created for the legacy function")
+	}
+
+	do(t, h, http.MethodPost, "/system/scale-function/legacy",
+		`{"serviceName":"legacy","replicas":2}`, http.StatusAccepted)
+	var function requests.Function
+	if !eventually(func() bool {
+		function = readReplicas(t, h, "legacy")
+		return function.Replicas == 2
+	}) {
+		t.Errorf("want 2 replicas, got: %d", function.Replicas)
+	}
+	if reply := do(t, h, http.MethodPost, "/function/legacy", "hello", http.StatusOK); reply != "hello" {
+		t.Errorf("want the reply of the container, got: %q", reply)
+	}
+
+	do(t, h, http.MethodDelete, "/system/functions", `{"functionName":"legacy"}`, http.StatusAccepted)
+	if h.APIServer.Object(harness.LegacyDeploymentPrefix, "default", "deployments", "legacy") != nil {
+		t.Errorf("want the legacy deployment deleted")
+	}
+}
+
+func Test_Flow_SmartNIC(t *testing.T) {
+	h := startHarness(t, harness.Config{SmartNICs: 2})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo-lambdanic","image":"smartnic"}`, http.StatusAccepted)
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo-lambdanic","image":"smartnic"}`, http.StatusBadRequest)
+	if function := readReplicas(t, h, "echo-lambdanic"); function.Replicas != 1 {
+		t.Errorf("want 1 replica, got: %d", function.Replicas)
+	}
+
+	// The emulated SmartNICs reply with the job ID.
+	if reply := do(t, h, http.MethodPost, "/function/echo-lambdanic", "42", http.StatusOK); reply != "42" {
+		t.Errorf("want the reply of the SmartNIC, got: %q", reply)
+	}
+
+	do(t, h, http.MethodPost, "/system/scale-function/echo-lambdanic",
+		`{"serviceName":"echo-lambdanic","replicas":4}`, http.StatusAccepted)
+	if function := readReplicas(t, h, "echo-lambdanic"); function.Replicas != 4 {
+		t.Errorf("want 4 replicas, got: %d", function.Replicas)
+	}
+	placement, err := handlers.GetFunctionPlacement(h.Store, "echo-lambdanic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if placement["127.0.0.1"] != 2 || placement["127.0.0.2"] != 2 {
+		t.Errorf("want the replicas spread over both SmartNICs, got: %v", placement)
+	}
+
+	do(t, h, http.MethodDelete, "/system/functions", `{"functionName":"echo-lambdanic"}`, http.StatusAccepted)
+	if handlers.EtcdFunctionExists(h.Store, "echo-lambdanic") {
+		t.Errorf("want the function deleted from the store")
+	}
+	do(t, h, http.MethodGet, "/system/function/echo-lambdanic", "", http.StatusNotFound)
+	if placement, _ := handlers.GetFunctionPlacement(h.Store, "echo-lambdanic"); len(placement) != 0 {
+		t.Errorf("want no replicas left, got: %v", placement)
+	}
+}
+
+func Test_Flow_BareMetal(t *testing.T) {
+	h := startHarness(t, harness.Config{})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo-baremetal","image":"baremetal"}`, http.StatusAccepted)
+	if reply := do(t, h, http.MethodPost, "/function/echo-baremetal", "7", http.StatusOK); reply != "7" {
+		t.Errorf("want the reply of the bare-metal server, got: %q", reply)
+	}
+	do(t, h, http.MethodDelete, "/system/functions", `{"functionName":"echo-baremetal"}`, http.StatusAccepted)
+	if handlers.EtcdFunctionExists(h.Store, "echo-baremetal") {
+		t.Errorf("want the function deleted from the store")
+	}
+}
+
+func Test_Flow_SmartNICTimeout(t *testing.T) {
+	h := startHarness(t, harness.Config{
+		Proxy: handlers.ProxyConfig{NICTimeout: 100 * time.Millisecond},
+	})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo-lambdanic","image":"smartnic"}`, http.StatusAccepted)
+	// Functions which are not hybrid have no container to overflow to.
+	h.SmartNICs[0].Close()
+	do(t, h, http.MethodPost, "/function/echo-lambdanic", "42", http.StatusInternalServerError)
+}
+
+func Test_Flow_HybridOverflow(t *testing.T) {
+	h := startHarness(t, harness.Config{})
+	defer h.Close()
+	h.HandleContainer("echo-lambdanic", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("container"))
+	}))
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo-lambdanic","image":"functions/echo:latest","annotations":{"com.lambdanic.hybrid":"true"}}`,
+		http.StatusAccepted)
+	if h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "echo-lambdanic") == nil {
+		t.Fatalf("want a container for the hybrid function")
+	}
+
+	// Invocations overflow to the container once the SmartNIC is gone.
+	h.SmartNICs[0].Close()
+	if reply := do(t, h, http.MethodPost, "/function/echo-lambdanic", "42", http.StatusOK); reply != "container" {
+		t.Errorf("want the reply of the container, got: %q", reply)
+	}
+
+	do(t, h, http.MethodDelete, "/system/functions", `{"functionName":"echo-lambdanic"}`, http.StatusAccepted)
+	if h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "echo-lambdanic") != nil {
+		t.Errorf("want the container of the hybrid function deleted")
+	}
+}
+
+func Test_Flow_HybridRollback(t *testing.T) {
+	h := startHarness(t, harness.Config{})
+	defer h.Close()
+	// A Service left behind keeps the container from being created.
+	h.APIServer.Put("/api/v1", "default", "services", map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "echo-lambdanic"},
+	})
+
+	body := `{"service":"echo-lambdanic","image":"functions/echo:latest","annotations":{"com.lambdanic.hybrid":"true"}}`
+	do(t, h, http.MethodPost, "/system/functions", body, http.StatusInternalServerError)
+	if handlers.EtcdFunctionExists(h.Store, "echo-lambdanic") {
+		t.Errorf("want the function deleted from the store")
+	}
+	if h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "echo-lambdanic") != nil {
+		t.Errorf("want the deployment rolled back")
+	}
+	if h.APIServer.Object("/api/v1", "default", "services", "echo-lambdanic") == nil {
+		t.Errorf("want the service which already existed kept")
+	}
+
+	err := h.Clientset.CoreV1().Services("default").Delete("echo-lambdanic", &metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	do(t, h, http.MethodPost, "/system/functions", body, http.StatusAccepted)
+	if h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "echo-lambdanic") == nil {
+		t.Errorf("want a container for the hybrid function")
+	}
+}
+
+func Test_Flow_HybridToggledByUpdate(t *testing.T) {
+	h := startHarness(t, harness.Config{})
+	defer h.Close()
+	plain := `{"service":"echo-lambdanic","image":"functions/echo:latest"}`
+	hybrid := `{"service":"echo-lambdanic","image":"functions/echo:latest","annotations":{"com.lambdanic.hybrid":"true"}}`
+	isHybrid := func() bool {
+		meta, err := handlers.EtcdGetFunctionMeta(h.Store, "echo-lambdanic")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return meta.Annotations["com.lambdanic.hybrid"] == "true"
+	}
+	do(t, h, http.MethodPost, "/system/functions", plain, http.StatusAccepted)
+
+	// The metadata is kept when the container cannot be created.
+	h.APIServer.Put("/api/v1", "default", "services", map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "echo-lambdanic"},
+	})
+	do(t, h, http.MethodPut, "/system/functions", hybrid, http.StatusInternalServerError)
+	if isHybrid() {
+		t.Errorf("want the metadata kept after the container failed")
+	}
+	err := h.Clientset.CoreV1().Services("default").Delete("echo-lambdanic", &metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do(t, h, http.MethodPut, "/system/functions", hybrid, http.StatusAccepted)
+	if !isHybrid() {
+		t.Errorf("want the function stored as hybrid")
+	}
+	if h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "echo-lambdanic") == nil {
+		t.Errorf("want a container created for the hybrid function")
+	}
+
+	do(t, h, http.MethodPut, "/system/functions", plain, http.StatusAccepted)
+	if isHybrid() {
+		t.Errorf("want the function no longer stored as hybrid")
+	}
+	if h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "echo-lambdanic") != nil ||
+		h.APIServer.Object("/api/v1", "default", "services", "echo-lambdanic") != nil {
+		t.Errorf("want the container of the function deleted")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Lambda-NIC/faas-netes/handlers"
+	"github.com/Lambda-NIC/faas-netes/test/harness"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+func Test_FunctionCache_FollowsAPIServer(t *testing.T) {
+	apiServer := harness.NewAPIServer()
+	defer apiServer.Close()
+	clientset, err := apiServer.Clientset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := handlers.NewFunctionCache(clientset, "default")
+	check := handlers.FunctionCacheReadinessCheck(cache)
+	if err = check(context.Background()); err == nil {
+		t.Errorf("want the readiness check to fail before the cache is synced")
+	}
+
+	cache.Run()
+	defer cache.Close()
+	if !eventually(cache.HasSynced) {
+		t.Fatalf("want the cache synced")
+	}
+	if err = check(context.Background()); err != nil {
+		t.Errorf("want the readiness check to pass once synced, got: %v", err)
+	}
+
+	deployment := func(name string, labels map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"metadata": map[string]interface{}{"name": name, "labels": labels},
+			"spec": map[string]interface{}{
+				"selector": map[string]interface{}{"matchLabels": labels},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{"labels": labels},
+				},
+			},
+		}
+	}
+	apiServer.Put(harness.DeploymentPrefix, "default", "deployments",
+		deployment("echo", map[string]interface{}{"faas_function": "echo"}))
+	apiServer.Put(harness.DeploymentPrefix, "default", "deployments",
+		deployment("gateway", map[string]interface{}{"app": "gateway"}))
+	apiServer.Put(harness.LegacyDeploymentPrefix, "default", "deployments",
+		deployment("legacy", map[string]interface{}{"faas_function": "legacy"}))
+	apiServer.Put("/api/v1", "default", "services", map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "echo", "labels": map[string]interface{}{"faas_function": "echo"}},
+	})
+	apiServer.Put("/api/v1", "default", "services", map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "gateway"},
+	})
+
+	if !eventually(func() bool {
+		deployments, _ := cache.Deployments()
+		return len(deployments) == 2
+	}) {
+		deployments, _ := cache.Deployments()
+		t.Fatalf("want the function deployments cached, got: %d", len(deployments))
+	}
+	deployments, _ := cache.Deployments()
+	if deployments[0].Name != "echo" || deployments[1].Name != "legacy" {
+		t.Errorf("want the functions of both APIs ordered by name, got: %s, %s",
+			deployments[0].Name, deployments[1].Name)
+	}
+	if !eventually(func() bool {
+		_, serviceErr := cache.Service("echo")
+		return serviceErr == nil
+	}) {
+		t.Errorf("want the function service cached")
+	}
+	if _, serviceErr := cache.Service("gateway"); !errors.IsNotFound(serviceErr) {
+		t.Errorf("want services which are not of functions left out, got: %v", serviceErr)
+	}
+
+	if err = clientset.AppsV1().Deployments("default").Delete("echo", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(func() bool {
+		_, getErr := cache.Deployment("echo")
+		return errors.IsNotFound(getErr)
+	}) {
+		t.Errorf("want the deleted deployment removed from the cache")
+	}
+}
+
+func Test_FunctionReader_ServesFromCache(t *testing.T) {
+	h := startHarness(t, harness.Config{})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest"}`, http.StatusAccepted)
+	if !eventually(func() bool { return len(listFunctions(t, h)) == 1 }) {
+		t.Fatalf("want the function listed")
+	}
+
+	lists := h.APIServer.Lists()
+	for i := 0; i < 5; i++ {
+		listFunctions(t, h)
+		readReplicas(t, h, "echo")
+	}
+	if served := h.APIServer.Lists() - lists; served != 0 {
+		t.Errorf("want the functions read from the cache, got %d lists", served)
+	}
+}
+
+func Test_FunctionCache_FollowsDeployedServices(t *testing.T) {
+	h := startHarness(t, harness.Config{})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest"}`, http.StatusAccepted)
+	if !eventually(func() bool {
+		_, err := h.Cache.Service("echo")
+		return err == nil
+	}) {
+		t.Errorf("want the service of a deployed function cached")
+	}
+
+	// Services deployed before they were labelled are labelled on update.
+	if err := h.Clientset.CoreV1().Services("default").Delete("echo", nil); err != nil {
+		t.Fatal(err)
+	}
+	h.APIServer.Put("/api/v1", "default", "services", map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "echo"},
+	})
+	if !eventually(func() bool {
+		_, err := h.Cache.Service("echo")
+		return errors.IsNotFound(err)
+	}) {
+		t.Fatalf("want the unlabelled service left out of the cache")
+	}
+	do(t, h, http.MethodPut, "/system/functions",
+		`{"service":"echo","image":"functions/echo:0.2"}`, http.StatusAccepted)
+	if !eventually(func() bool {
+		_, err := h.Cache.Service("echo")
+		return err == nil
+	}) {
+		t.Errorf("want the service cached once the function was updated")
+	}
+}
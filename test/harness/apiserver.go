@@ -0,0 +1,542 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// endpoint is a collection served by the API server along with its kind and
+// the verbs the handlers use on it
+type endpoint struct {
+	kind  string
+	verbs []string
+}
+
+// endpoints are the collections served, by API group path and resource. They
+// are only those the handlers call in the tests, every other request is
+// answered with 404 so that a handler calling another endpoint fails rather
+// than being tested against a made up API.
+var endpoints = map[string]endpoint{
+	"/apis/apps/v1/deployments": {
+		kind:  "Deployment",
+		verbs: []string{"list", "watch", "get", "create", "update", "delete"},
+	},
+	// Deployments are never created under extensions/v1beta1, legacy
+	// functions are put there by the tests.
+	"/apis/extensions/v1beta1/deployments": {
+		kind:  "Deployment",
+		verbs: []string{"list", "watch", "get", "update", "delete"},
+	},
+	"/api/v1/services": {
+		kind:  "Service",
+		verbs: []string{"list", "watch", "get", "create", "update", "delete"},
+	},
+	"/api/v1/pods": {
+		kind:  "Pod",
+		verbs: []string{"list"},
+	},
+	"/apis/autoscaling/v1/horizontalpodautoscalers": {
+		kind:  "HorizontalPodAutoscaler",
+		verbs: []string{"get", "create", "update", "delete"},
+	},
+}
+
+// APIServer serves the parts of the Kubernetes API used by the handlers
+// from memory. The vendored client-go has no fake clientset, and the
+// k8s.io/client-go/kubernetes/fake package of its release depends on
+// packages which are not vendored either, so the real clientset is pointed
+// at it instead. It serves the endpoints and nothing more.
+//
+// Objects are kept as JSON documents by API group, namespace and resource,
+// so objects put under extensions/v1beta1 stand for functions created
+// before the apps/v1 migration on a cluster which only serves them there.
+// Deployments report every replica of their spec available, unless
+// rollouts are held, and, under
+// extensions/v1beta1, take the labels and selector of their pods when they
+// have none. Deployments are rejected when their selector does not match
+// their pods or, under apps/v1, when it is changed. Every change is kept as
+// a watch event.
+type APIServer struct {
+	server *httptest.Server
+
+	mutex   sync.Mutex
+	objects map[string]map[string]map[string]interface{}
+	version int
+	lists   int
+	held    bool
+	events  []event
+	// changed is closed and replaced whenever an event is added
+	changed chan struct{}
+	closed  chan struct{}
+}
+
+// event is a change to an object, as sent to watches
+type event struct {
+	collection string
+	version    int
+	eventType  string
+	object     map[string]interface{}
+}
+
+// NewAPIServer starts an empty API server
+func NewAPIServer() *APIServer {
+	a := &APIServer{
+		objects: map[string]map[string]map[string]interface{}{},
+		changed: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	a.server = httptest.NewServer(http.HandlerFunc(a.serve))
+	return a
+}
+
+// URL is where the API server listens
+func (a *APIServer) URL() string {
+	return a.server.URL
+}
+
+// Clientset creates a clientset talking to the API server
+func (a *APIServer) Clientset() (kubernetes.Interface, error) {
+	return kubernetes.NewForConfig(&rest.Config{Host: a.server.URL})
+}
+
+// Close ends the watches and stops the API server
+func (a *APIServer) Close() {
+	close(a.closed)
+	a.server.Close()
+}
+
+// Object returns a copy of an object, as it would be served, or nil if it
+// does not exist. The prefix is the API group path, such as
+// /apis/extensions/v1beta1 or /api/v1.
+func (a *APIServer) Object(prefix, namespace, resource, name string) map[string]interface{} {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	object, ok := a.objects[collectionKey(prefix, namespace, resource)][name]
+	if !ok {
+		return nil
+	}
+	return copyObject(object)
+}
+
+// Lists returns how many lists were served, watches excluded
+func (a *APIServer) Lists() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.lists
+}
+
+// Put stores an object as if it was created, for objects the handlers
+// only read such as secrets
+func (a *APIServer) Put(prefix, namespace, resource string,
+	object map[string]interface{}) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.store(prefix, namespace, resource, copyObject(object))
+}
+
+// HoldRollouts keeps the available replicas of deployments from growing,
+// as if their pods were starting, until ReleaseRollouts is called
+func (a *APIServer) HoldRollouts() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.held = true
+}
+
+// ReleaseRollouts makes every replica of the deployments available
+func (a *APIServer) ReleaseRollouts() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.held = false
+	for key, objects := range a.objects {
+		if !strings.HasSuffix(key, "/deployments") {
+			continue
+		}
+		for _, object := range objects {
+			prefix := key[:strings.Index(key, "/namespaces/")]
+			namespace, _ := field(object, "metadata")["namespace"].(string)
+			a.store(prefix, namespace, "deployments", copyObject(object))
+		}
+	}
+}
+
+func collectionKey(prefix, namespace, resource string) string {
+	return fmt.Sprintf("%s/namespaces/%s/%s", prefix, namespace, resource)
+}
+
+func copyObject(object map[string]interface{}) map[string]interface{} {
+	data, _ := json.Marshal(object)
+	copied := map[string]interface{}{}
+	json.Unmarshal(data, &copied)
+	return copied
+}
+
+// store saves an object, filling in the fields set by Kubernetes
+func (a *APIServer) store(prefix, namespace, resource string,
+	object map[string]interface{}) {
+	key := collectionKey(prefix, namespace, resource)
+	if a.objects[key] == nil {
+		a.objects[key] = map[string]map[string]interface{}{}
+	}
+	metadata := field(object, "metadata")
+	name, _ := metadata["name"].(string)
+	a.version++
+	metadata["namespace"] = namespace
+	metadata["resourceVersion"] = strconv.Itoa(a.version)
+	if previous, ok := a.objects[key][name]; ok {
+		previousMeta := field(previous, "metadata")
+		metadata["uid"] = previousMeta["uid"]
+		metadata["creationTimestamp"] = previousMeta["creationTimestamp"]
+	} else {
+		metadata["uid"] = fmt.Sprintf("%s-%d", name, a.version)
+		metadata["creationTimestamp"] = time.Now().UTC().Format(time.RFC3339)
+	}
+	if _, ok := object["kind"]; !ok {
+		object["kind"] = endpoints[prefix+"/"+resource].kind
+	}
+	if _, ok := object["apiVersion"]; !ok {
+		object["apiVersion"] = strings.TrimPrefix(strings.TrimPrefix(prefix, "/apis/"), "/api/")
+	}
+	if resource == "deployments" {
+		// extensions/v1beta1 defaults the labels of a deployment to those
+		// of its pods.
+		if _, ok := metadata["labels"]; !ok && strings.HasPrefix(prefix, "/apis/extensions/") {
+			template := field(field(object, "spec"), "template")
+			if labels, ok := field(template, "metadata")["labels"]; ok {
+				metadata["labels"] = labels
+			}
+		}
+		if _, ok := field(object, "spec")["selector"]; !ok && strings.HasPrefix(prefix, "/apis/extensions/") {
+			template := field(field(object, "spec"), "template")
+			if labels, ok := field(template, "metadata")["labels"]; ok {
+				field(object, "spec")["selector"] = map[string]interface{}{"matchLabels": labels}
+			}
+		}
+		replicas := 1.0
+		if value, ok := field(object, "spec")["replicas"].(float64); ok {
+			replicas = value
+		}
+		available := replicas
+		if a.held {
+			available = 0.0
+			if previous, ok := a.objects[key][name]; ok {
+				available, _ = field(previous, "status")["availableReplicas"].(float64)
+			}
+			if available > replicas {
+				available = replicas
+			}
+		}
+		object["status"] = map[string]interface{}{
+			"observedGeneration": a.version,
+			"replicas":           replicas,
+			"updatedReplicas":    replicas,
+			"readyReplicas":      available,
+			"availableReplicas":  available,
+		}
+	}
+	eventType := "ADDED"
+	if _, ok := a.objects[key][name]; ok {
+		eventType = "MODIFIED"
+	}
+	a.objects[key][name] = object
+	a.notify(key, eventType, object)
+}
+
+// notify adds an event and wakes up the watches
+func (a *APIServer) notify(collection, eventType string, object map[string]interface{}) {
+	a.events = append(a.events, event{
+		collection: collection,
+		version:    a.version,
+		eventType:  eventType,
+		object:     copyObject(object),
+	})
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+// watch streams the events of a collection after the resource version
+// until the client or the API server goes away
+func (a *APIServer) watch(w http.ResponseWriter, r *http.Request, collection string) {
+	version, _ := strconv.Atoi(r.URL.Query().Get("resourceVersion"))
+	selector := r.URL.Query().Get("labelSelector")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	for {
+		a.mutex.Lock()
+		pending := []event{}
+		for _, e := range a.events {
+			if e.collection == collection && e.version > version {
+				pending = append(pending, e)
+			}
+		}
+		changed := a.changed
+		a.mutex.Unlock()
+
+		for _, e := range pending {
+			version = e.version
+			labels, _ := field(e.object, "metadata")["labels"].(map[string]interface{})
+			if !matchLabels(selector, labels) {
+				continue
+			}
+			if err := encoder.Encode(map[string]interface{}{"type": e.eventType, "object": e.object}); err != nil {
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-a.closed:
+			return
+		}
+	}
+}
+
+// field returns the object stored in a field, creating it when missing
+func field(object map[string]interface{}, name string) map[string]interface{} {
+	value, ok := object[name].(map[string]interface{})
+	if !ok {
+		value = map[string]interface{}{}
+		object[name] = value
+	}
+	return value
+}
+
+// verb returns the verb of a request on a collection, or an empty string
+func verb(r *http.Request, named bool) string {
+	switch {
+	case r.Method == http.MethodGet && !named && r.URL.Query().Get("watch") == "true":
+		return "watch"
+	case r.Method == http.MethodGet && !named:
+		return "list"
+	case r.Method == http.MethodGet:
+		return "get"
+	case r.Method == http.MethodPost && !named:
+		return "create"
+	case r.Method == http.MethodPut && named:
+		return "update"
+	case r.Method == http.MethodDelete && named:
+		return "delete"
+	}
+	return ""
+}
+
+// allows returns true when the endpoint supports the verb
+func (e endpoint) allows(verb string) bool {
+	for _, v := range e.verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *APIServer) serve(w http.ResponseWriter, r *http.Request) {
+	// Paths are <prefix>/namespaces/<namespace>/<resource>[/<name>]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	index := -1
+	for i, part := range parts {
+		if part == "namespaces" {
+			index = i
+			break
+		}
+	}
+	if index < 0 || len(parts) < index+3 || len(parts) > index+4 {
+		writeStatus(w, http.StatusNotFound, "NotFound",
+			fmt.Sprintf("the server could not find the requested resource %s", r.URL.Path))
+		return
+	}
+	prefix := "/" + strings.Join(parts[:index], "/")
+	namespace, resource := parts[index+1], parts[index+2]
+	name := ""
+	if len(parts) == index+4 {
+		name = parts[index+3]
+	}
+	endpoint, ok := endpoints[prefix+"/"+resource]
+	if !ok {
+		writeStatus(w, http.StatusNotFound, "NotFound",
+			fmt.Sprintf("the server could not find the requested resource %s", r.URL.Path))
+		return
+	}
+	requestVerb := verb(r, len(name) > 0)
+	if !endpoint.allows(requestVerb) {
+		writeStatus(w, http.StatusMethodNotAllowed, "MethodNotAllowed",
+			fmt.Sprintf("%s is not supported on %s", r.Method, r.URL.Path))
+		return
+	}
+
+	if requestVerb == "watch" {
+		a.watch(w, r, collectionKey(prefix, namespace, resource))
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	collection := a.objects[collectionKey(prefix, namespace, resource)]
+
+	switch requestVerb {
+	case "list":
+		a.lists++
+		items := []interface{}{}
+		names := []string{}
+		for itemName := range collection {
+			names = append(names, itemName)
+		}
+		sort.Strings(names)
+		for _, itemName := range names {
+			object := collection[itemName]
+			labels, _ := field(object, "metadata")["labels"].(map[string]interface{})
+			if matchLabels(r.URL.Query().Get("labelSelector"), labels) {
+				items = append(items, object)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"kind":       endpoint.kind + "List",
+			"apiVersion": strings.TrimPrefix(strings.TrimPrefix(prefix, "/apis/"), "/api/"),
+			"metadata":   map[string]interface{}{"resourceVersion": strconv.Itoa(a.version)},
+			"items":      items,
+		})
+	case "get":
+		object, ok := collection[name]
+		if !ok {
+			writeNotFound(w, resource, name)
+			return
+		}
+		writeJSON(w, http.StatusOK, object)
+	case "create", "update":
+		object := map[string]interface{}{}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &object); err != nil {
+			writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+			return
+		}
+		objectName, _ := field(object, "metadata")["name"].(string)
+		_, exists := collection[objectName]
+		if requestVerb == "create" && exists {
+			writeStatus(w, http.StatusConflict, "AlreadyExists",
+				fmt.Sprintf("%s %q already exists", resource, objectName))
+			return
+		}
+		if requestVerb == "update" && !exists {
+			writeNotFound(w, resource, name)
+			return
+		}
+		if resource == "deployments" {
+			if message := validateDeployment(prefix, object, collection[objectName]); len(message) > 0 {
+				writeStatus(w, http.StatusUnprocessableEntity, "Invalid",
+					fmt.Sprintf("Deployment %q is invalid: %s", objectName, message))
+				return
+			}
+		}
+		a.store(prefix, namespace, resource, object)
+		status := http.StatusOK
+		if requestVerb == "create" {
+			status = http.StatusCreated
+		}
+		writeJSON(w, status, a.objects[collectionKey(prefix, namespace, resource)][objectName])
+	case "delete":
+		if _, ok := collection[name]; !ok {
+			writeNotFound(w, resource, name)
+			return
+		}
+		deleted := collection[name]
+		delete(collection, name)
+		a.version++
+		field(deleted, "metadata")["resourceVersion"] = strconv.Itoa(a.version)
+		a.notify(collectionKey(prefix, namespace, resource), "DELETED", deleted)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"kind": "Status", "apiVersion": "v1", "status": "Success",
+		})
+	}
+}
+
+// validateDeployment returns why a Deployment is invalid, or an empty string
+func validateDeployment(prefix string, object map[string]interface{},
+	previous map[string]interface{}) string {
+	spec := field(object, "spec")
+	selector, hasSelector := spec["selector"].(map[string]interface{})
+	if !hasSelector {
+		if strings.HasPrefix(prefix, "/apis/extensions/") {
+			return ""
+		}
+		return "spec.selector: Required value"
+	}
+	matchLabels, _ := selector["matchLabels"].(map[string]interface{})
+	labels, _ := field(field(spec, "template"), "metadata")["labels"].(map[string]interface{})
+	for k, v := range matchLabels {
+		if labels[k] != v {
+			return "spec.template.metadata.labels: Invalid value: `selector` does not match template `labels`"
+		}
+	}
+	if previous != nil && strings.HasPrefix(prefix, "/apis/apps/") {
+		previousSelector, _ := json.Marshal(field(previous, "spec")["selector"])
+		newSelector, _ := json.Marshal(selector)
+		if string(previousSelector) != string(newSelector) {
+			return "spec.selector: Invalid value: field is immutable"
+		}
+	}
+	return ""
+}
+
+// matchLabels checks labels against a selector made of key and key=value
+// requirements, the only ones the handlers send
+func matchLabels(selector string, labels map[string]interface{}) bool {
+	for _, requirement := range strings.Split(selector, ",") {
+		requirement = strings.TrimSpace(requirement)
+		switch {
+		case len(requirement) == 0:
+		case strings.Contains(requirement, "="):
+			parts := strings.SplitN(requirement, "=", 2)
+			if labels[parts[0]] != parts[1] {
+				return false
+			}
+		default:
+			if _, ok := labels[requirement]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeNotFound(w http.ResponseWriter, resource, name string) {
+	writeStatus(w, http.StatusNotFound, "NotFound",
+		fmt.Sprintf("%s %q not found", resource, name))
+}
+
+// writeStatus answers with a failure status, which client-go turns into the
+// error checked by the handlers such as errors.IsNotFound
+func writeStatus(w http.ResponseWriter, code int, reason, message string) {
+	writeJSON(w, code, map[string]interface{}{
+		"kind":       "Status",
+		"apiVersion": "v1",
+		"metadata":   map[string]interface{}{},
+		"status":     "Failure",
+		"message":    message,
+		"reason":     reason,
+		"code":       code,
+	})
+}
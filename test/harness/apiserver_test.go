@@ -0,0 +1,53 @@
+package harness
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_APIServer_Clientset(t *testing.T) {
+	apiServer := NewAPIServer()
+	defer apiServer.Close()
+	clientset, err := apiServer.Clientset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the endpoints the handlers call are served.
+	_, err = clientset.CoreV1().Secrets("default").Get("credentials", metav1.GetOptions{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("want secrets not served, got: %v", err)
+	}
+
+	services := clientset.CoreV1().Services("default")
+	for _, name := range []string{"echo", "other"} {
+		_, err = services.Create(&corev1.Service{ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"faas_function": name},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = services.Create(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "echo"}})
+	if !errors.IsAlreadyExists(err) {
+		t.Errorf("want already exists, got: %v", err)
+	}
+
+	list, err := services.List(metav1.ListOptions{LabelSelector: "faas_function=echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "echo" || list.Items[0].Namespace != "default" {
+		t.Errorf("want the selected service, got: %+v", list.Items)
+	}
+
+	if err = services.Delete("echo", &metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = services.Get("echo", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("want not found, got: %v", err)
+	}
+}
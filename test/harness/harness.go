@@ -0,0 +1,277 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+// Package harness runs the provider handlers in-process, against an
+// in-memory store, a fake Kubernetes API server and emulated SmartNICs, so
+// that deploy, scale, invoke and delete flows can be tested end to end.
+package harness
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/emulator"
+	"github.com/Lambda-NIC/faas-netes/handlers"
+	"github.com/gorilla/mux"
+	"go.etcd.io/etcd/client"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DeploymentPrefix is the API group path deployments are kept under
+	DeploymentPrefix = "/apis/apps/v1"
+	// LegacyDeploymentPrefix is the API group path of deployments created
+	// before the apps/v1 migration
+	LegacyDeploymentPrefix = "/apis/extensions/v1beta1"
+)
+
+// Config specify the SmartNICs and handler options of a harness
+type Config struct {
+	// Namespace functions are deployed to, default by default
+	Namespace string
+	// SmartNICs is how many emulated SmartNICs are registered, from
+	// 127.0.0.1 upwards, one by default
+	SmartNICs int
+	// Capacity is the replica capacity registered by every SmartNIC
+	Capacity uint64
+	// Proxy configures the proxy, the ports and transport are set by the
+	// harness
+	Proxy handlers.ProxyConfig
+	// Deploy configures the deploy and update handlers, deploys asked to
+	// wait with wait=true wait two seconds by default
+	Deploy handlers.DeployHandlerConfig
+	// Scaling configures scaling to and from zero, functions are only
+	// scaled to zero when ScaleIdle is called
+	Scaling handlers.ScalingConfig
+	// AlertScaleStep is how many replicas alerts scale functions by, one by
+	// default
+	AlertScaleStep uint64
+}
+
+// Harness is a provider serving its API over HTTP with every dependency
+// in-process
+type Harness struct {
+	Namespace    string
+	Store        *handlers.MemoryStore
+	APIServer    *APIServer
+	Clientset    kubernetes.Interface
+	SmartNICs    []*emulator.Emulator
+	RoutingTable *handlers.RoutingTable
+	Router       *handlers.SmartNICRouter
+	// Cache serves the function and replica readers, like the provider
+	Cache *handlers.FunctionCache
+	// Scaler scales container functions to and from zero
+	Scaler *handlers.FunctionScaler
+	// Server serves the routes of the provider
+	Server *httptest.Server
+
+	mutex      sync.Mutex
+	containers map[string]http.Handler
+}
+
+// New starts a harness, Close must be called to stop it
+func New(config Config) (*Harness, error) {
+	if len(config.Namespace) == 0 {
+		config.Namespace = "default"
+	}
+	if config.SmartNICs <= 0 {
+		config.SmartNICs = 1
+	}
+	if config.Proxy.NICTimeout <= 0 {
+		config.Proxy.NICTimeout = time.Second
+	}
+	if config.AlertScaleStep == 0 {
+		config.AlertScaleStep = 1
+	}
+	if config.Scaling.ScaleFromZeroTimeout <= 0 {
+		config.Scaling.ScaleFromZeroTimeout = 2 * time.Second
+	}
+	h := &Harness{
+		Namespace:  config.Namespace,
+		Store:      handlers.NewMemoryStore(),
+		APIServer:  NewAPIServer(),
+		containers: map[string]http.Handler{},
+	}
+	clientset, err := h.APIServer.Clientset()
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	h.Clientset = clientset
+
+	for _, dir := range []string{"/smartnics", "/deployments", "/functions", "/metadata", "/acks"} {
+		_, err = h.Store.Set(context.Background(), dir, "", &client.SetOptions{Dir: true})
+		if err != nil {
+			h.Close()
+			return nil, err
+		}
+	}
+
+	// Every SmartNIC listens on the ports picked by the first one.
+	ports := []int{0, 0}
+	for i := 0; i < config.SmartNICs; i++ {
+		nic := emulator.New(emulator.Config{
+			Address: fmt.Sprintf("127.0.0.%d", i+1),
+			Ports:   ports,
+		})
+		if err = nic.Start(); err != nil {
+			h.Close()
+			return nil, err
+		}
+		h.SmartNICs = append(h.SmartNICs, nic)
+		if i == 0 {
+			addrs := nic.Addrs()
+			ports = []int{addrs[0].Port, addrs[1].Port}
+		}
+		if err = nic.Register(h.Store, config.Capacity, 100*time.Millisecond); err != nil {
+			h.Close()
+			return nil, err
+		}
+	}
+
+	h.Cache = handlers.NewFunctionCache(h.Clientset, h.Namespace)
+	h.Cache.Run()
+	for started := time.Now(); !h.Cache.HasSynced(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(started) > 5*time.Second {
+			h.Close()
+			return nil, fmt.Errorf("function cache did not sync")
+		}
+	}
+
+	h.RoutingTable = handlers.NewRoutingTable(h.Store)
+	h.Router = handlers.NewSmartNICRouter(handlers.RoutingRandom, nil)
+	invocationCounter := handlers.NewInvocationCounter(h.Store, "harness")
+	h.Scaler = handlers.NewFunctionScaler(h.Namespace, h.Clientset, h.Cache,
+		invocationCounter, config.Scaling)
+
+	proxyConfig := config.Proxy
+	proxyConfig.SmartNICPort, proxyConfig.BareMetalPort = ports[0], ports[1]
+	proxyConfig.Transport = containerTransport{harness: h}
+	proxyConfig.Scaler = h.Scaler
+	proxy := handlers.MakeProxy(h.Namespace, h.RoutingTable, h.Router,
+		time.Second, &proxyConfig)
+
+	deployConfig := config.Deploy
+	if deployConfig.FunctionReadinessProbeConfig == nil {
+		deployConfig.FunctionReadinessProbeConfig = &handlers.FunctionProbeConfig{}
+	}
+	if deployConfig.FunctionLivenessProbeConfig == nil {
+		deployConfig.FunctionLivenessProbeConfig = &handlers.FunctionProbeConfig{}
+	}
+	if deployConfig.WaitTimeout <= 0 {
+		deployConfig.WaitTimeout = 2 * time.Second
+	}
+
+	// The routes of the provider, as set by faas-provider.
+	r := mux.NewRouter()
+	r.HandleFunc("/system/functions", handlers.MakeFunctionReader(h.Namespace,
+		h.Store, h.Clientset, h.Cache, invocationCounter)).Methods("GET")
+	r.HandleFunc("/system/functions", handlers.MakeDeployHandler(h.Namespace,
+		h.Store, h.Clientset, &deployConfig)).Methods("POST")
+	r.HandleFunc("/system/functions", handlers.MakeDeleteHandler(h.Namespace,
+		h.Store, h.Clientset, invocationCounter)).Methods("DELETE")
+	r.HandleFunc("/system/functions", handlers.MakeUpdateHandler(h.Namespace,
+		h.Store, h.Clientset, &deployConfig)).Methods("PUT")
+	r.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}", handlers.MakeReplicaReader(h.Namespace,
+		h.Store, h.Clientset, h.Cache, invocationCounter)).Methods("GET")
+	r.HandleFunc("/system/scale-function/{name:[-a-zA-Z_0-9]+}", handlers.MakeReplicaUpdater(h.Namespace,
+		h.Store, h.Clientset)).Methods("POST")
+	r.HandleFunc("/system/alert", handlers.MakeAlertHandler(h.Namespace,
+		h.Store, h.Clientset, config.AlertScaleStep)).Methods("POST")
+	// The routing table is refreshed on every invocation rather than on an
+	// interval, so that invocations see the functions deployed before them.
+	r.HandleFunc("/function/{name:[-a-zA-Z_0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		h.RoutingTable.Refresh()
+		proxy(w, r)
+	})
+	h.Server = httptest.NewServer(r)
+	return h, nil
+}
+
+// HandleContainer serves the invocations of the container of a function
+// with handler, containers without one echo the request body
+func (h *Harness) HandleContainer(service string, handler http.Handler) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.containers[service] = handler
+}
+
+// Close stops the provider, the SmartNICs and the API server
+func (h *Harness) Close() {
+	if h.Server != nil {
+		h.Server.Close()
+	}
+	for _, nic := range h.SmartNICs {
+		nic.Close()
+	}
+	if h.Cache != nil {
+		h.Cache.Close()
+	}
+	h.APIServer.Close()
+}
+
+// URL returns the URL of a path on the provider
+func (h *Harness) URL(path string) string {
+	return h.Server.URL + path
+}
+
+// Do sends a request to the provider and returns its status and body
+func (h *Harness) Do(method, path, body string) (int, string, error) {
+	req, err := http.NewRequest(method, h.URL(path), strings.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	resBody, err := ioutil.ReadAll(res.Body)
+	return res.StatusCode, string(resBody), err
+}
+
+// echo replies with the request body
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	w.Write(body)
+})
+
+// containerTransport serves the invocations sent to function services with
+// the container handlers, as long as the deployment has available replicas
+type containerTransport struct {
+	harness *Harness
+}
+
+func (t containerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h := t.harness
+	service := strings.SplitN(req.URL.Hostname(), ".", 2)[0]
+	deployment := h.APIServer.Object(DeploymentPrefix, h.Namespace, "deployments", service)
+	if deployment == nil {
+		deployment = h.APIServer.Object(LegacyDeploymentPrefix, h.Namespace, "deployments", service)
+	}
+	if deployment == nil {
+		return nil, fmt.Errorf("no such host: %s", req.URL.Host)
+	}
+	status, _ := deployment["status"].(map[string]interface{})
+	if available, _ := status["availableReplicas"].(float64); available < 1 {
+		return nil, fmt.Errorf("no endpoints available for service %s", service)
+	}
+
+	h.mutex.Lock()
+	handler, ok := h.containers[service]
+	h.mutex.Unlock()
+	if !ok {
+		handler = echo
+	}
+	if req.Body == nil {
+		req.Body = ioutil.NopCloser(strings.NewReader(""))
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr.Result(), nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Lambda-NIC/faas-netes/test/harness"
+)
+
+const hpaPrefix = "/apis/autoscaling/v1"
+
+// hpaSpec returns the spec of the HorizontalPodAutoscaler of a function, or
+// nil if it has none
+func hpaSpec(h *harness.Harness, name string) map[string]interface{} {
+	hpa := h.APIServer.Object(hpaPrefix, "default", "horizontalpodautoscalers", name)
+	if hpa == nil {
+		return nil
+	}
+	spec, _ := hpa["spec"].(map[string]interface{})
+	return spec
+}
+
+func Test_Flow_HorizontalPodAutoscaler(t *testing.T) {
+	h := startHarness(t, harness.Config{})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest","labels":{"com.openfaas.scale.min":"2","com.openfaas.scale.max":"5","com.openfaas.scale.target":"50"}}`,
+		http.StatusAccepted)
+	spec := hpaSpec(h, "echo")
+	if spec == nil {
+		t.Fatalf("want an autoscaler for the function")
+	}
+	if spec["minReplicas"] != 2.0 || spec["maxReplicas"] != 5.0 || spec["targetCPUUtilizationPercentage"] != 50.0 {
+		t.Errorf("want 2 to 5 replicas at 50%%, got: %v", spec)
+	}
+	if ref, _ := spec["scaleTargetRef"].(map[string]interface{}); ref["name"] != "echo" || ref["kind"] != "Deployment" {
+		t.Errorf("want the deployment of the function scaled, got: %v", ref)
+	}
+
+	do(t, h, http.MethodPut, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest","labels":{"com.openfaas.scale.max":"8"}}`,
+		http.StatusAccepted)
+	if spec = hpaSpec(h, "echo"); spec["minReplicas"] != 1.0 || spec["maxReplicas"] != 8.0 ||
+		spec["targetCPUUtilizationPercentage"] != 80.0 {
+		t.Errorf("want the autoscaler updated to 1 to 8 replicas at 80%%, got: %v", spec)
+	}
+
+	do(t, h, http.MethodPut, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest","labels":{"com.openfaas.scale.max":"none"}}`,
+		http.StatusBadRequest)
+	if spec = hpaSpec(h, "echo"); spec["maxReplicas"] != 8.0 {
+		t.Errorf("want the autoscaler left alone by an invalid update, got: %v", spec)
+	}
+
+	do(t, h, http.MethodPut, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest"}`, http.StatusAccepted)
+	if hpaSpec(h, "echo") != nil {
+		t.Errorf("want the autoscaler deleted with its labels")
+	}
+
+	do(t, h, http.MethodPut, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest","labels":{"com.openfaas.scale.target":"70"}}`,
+		http.StatusAccepted)
+	if hpaSpec(h, "echo") == nil {
+		t.Fatalf("want the autoscaler created again")
+	}
+	do(t, h, http.MethodDelete, "/system/functions", `{"functionName":"echo"}`, http.StatusAccepted)
+	if hpaSpec(h, "echo") != nil {
+		t.Errorf("want the autoscaler deleted with the function")
+	}
+}
+
+func Test_Flow_HorizontalPodAutoscaler_InvalidLabels(t *testing.T) {
+	h := startHarness(t, harness.Config{})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest","labels":{"com.openfaas.scale.min":"4","com.openfaas.scale.max":"2"}}`,
+		http.StatusBadRequest)
+	if h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "echo") != nil {
+		t.Errorf("want no deployment for a function with invalid scaling labels")
+	}
+}
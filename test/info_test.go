@@ -8,7 +8,7 @@ import (
 	"testing"
 
 	"github.com/Lambda-NIC/faas-netes/handlers"
-	"github.com/Lambda-NIC/faas-provider/types"
+	netesTypes "github.com/Lambda-NIC/faas-netes/types"
 )
 
 const (
@@ -24,8 +24,9 @@ func TestMakeInfoHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	handler := handlers.MakeInfoHandler(infoTestVersion, infoTestSHA)
-	infoRequest := types.InfoRequest{}
+	handler := handlers.MakeInfoHandler(infoTestVersion, infoTestSHA,
+		handlers.NewRoutingTable(nil), map[string]bool{"hedging": true})
+	infoRequest := netesTypes.ProviderInfo{}
 
 	handler(rr, req)
 	body, err := ioutil.ReadAll(rr.Body)
@@ -57,4 +58,24 @@ func TestMakeInfoHandler(t *testing.T) {
 	if infoRequest.Version.SHA != infoTestSHA {
 		t.Errorf("handler returned wrong SHA string - want: %v, got: %v", infoTestSHA, infoRequest.Version.SHA)
 	}
+
+	if backends := infoRequest.Capabilities.Backends; len(backends) != 3 {
+		t.Errorf("handler returned wrong backends - want: 3, got: %v", backends)
+	}
+
+	// SmartNICs and bare-metal servers share a single inventory.
+	if pools := infoRequest.Capabilities.Pools; len(pools) != 1 || pools[0].Size != 0 ||
+		len(pools[0].Backends) != 2 {
+		t.Errorf("handler returned wrong pools - want: 1 shared empty pool, got: %v", pools)
+	}
+
+	for _, strategy := range infoRequest.Capabilities.PlacementStrategies {
+		if _, err := handlers.PlaceReplicas(strategy, []string{"10.0.0.1"}, 1, nil); err != nil {
+			t.Errorf("handler returned a placement strategy which is not implemented: %v", err)
+		}
+	}
+
+	if !infoRequest.Capabilities.Features["hedging"] {
+		t.Errorf("handler returned wrong features - want: hedging, got: %v", infoRequest.Capabilities.Features)
+	}
 }
@@ -165,3 +165,232 @@ func TestRead_ImagePullPolicy_empty(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestRead_NICRoutingMode(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := types.ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.NICRoutingMode != "random" {
+		t.Logf("NICRoutingMode default incorrect, got: %s\n", config.NICRoutingMode)
+		t.Fail()
+	}
+
+	defaults.Setenv("nic_routing_mode", "adaptive")
+	config = readConfig.Read(defaults)
+	if config.NICRoutingMode != "adaptive" {
+		t.Logf("NICRoutingMode incorrect, got: %s\n", config.NICRoutingMode)
+		t.Fail()
+	}
+}
+
+func TestRead_ReadinessChecks(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := types.ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if len(config.ReadinessChecks) != 3 || config.ReadinessChecks[0] != "etcd" ||
+		config.ReadinessChecks[1] != "kubernetes" || config.ReadinessChecks[2] != "cache" {
+		t.Logf("ReadinessChecks default incorrect, got: %v\n", config.ReadinessChecks)
+		t.Fail()
+	}
+
+	defaults.Setenv("readiness_checks", "etcd, smartnic")
+	config = readConfig.Read(defaults)
+	if len(config.ReadinessChecks) != 2 || config.ReadinessChecks[1] != "smartnic" {
+		t.Logf("ReadinessChecks incorrect, got: %v\n", config.ReadinessChecks)
+		t.Fail()
+	}
+}
+
+func TestRead_Logging(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := types.ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.LogLevel != "info" || config.LogFormat != "logfmt" {
+		t.Logf("Logging defaults incorrect, got: %s %s\n", config.LogLevel, config.LogFormat)
+		t.Fail()
+	}
+
+	defaults.Setenv("log_level", "debug")
+	defaults.Setenv("log_format", "json")
+	config = readConfig.Read(defaults)
+	if config.LogLevel != "debug" || config.LogFormat != "json" {
+		t.Logf("Logging incorrect, got: %s %s\n", config.LogLevel, config.LogFormat)
+		t.Fail()
+	}
+}
+
+func TestRead_InvocationTrace(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := types.ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if len(config.InvocationTraceFile) != 0 || config.InvocationTraceMaxBytes != 104857600 ||
+		config.InvocationTraceMaxFiles != 5 {
+		t.Logf("Invocation trace defaults incorrect, got: %q %d %d\n", config.InvocationTraceFile,
+			config.InvocationTraceMaxBytes, config.InvocationTraceMaxFiles)
+		t.Fail()
+	}
+
+	defaults.Setenv("invocation_trace_file", "/tmp/trace.jsonl")
+	defaults.Setenv("invocation_trace_max_bytes", "1024")
+	config = readConfig.Read(defaults)
+	if config.InvocationTraceFile != "/tmp/trace.jsonl" || config.InvocationTraceMaxBytes != 1024 {
+		t.Logf("Invocation trace incorrect, got: %q %d\n", config.InvocationTraceFile,
+			config.InvocationTraceMaxBytes)
+		t.Fail()
+	}
+}
+
+func TestRead_ReplicaID(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := types.ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if len(config.ReplicaID) != 0 {
+		t.Logf("Replica ID default incorrect, got: %q\n", config.ReplicaID)
+		t.Fail()
+	}
+
+	defaults.Setenv("replica_id", "faas-netes-0")
+	config = readConfig.Read(defaults)
+	if config.ReplicaID != "faas-netes-0" {
+		t.Logf("Replica ID incorrect, got: %q\n", config.ReplicaID)
+		t.Fail()
+	}
+}
+
+func TestRead_NICPorts(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := types.ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.NICPort != 4369 || config.BareMetalPort != 10000 {
+		t.Logf("NIC port defaults incorrect, got: %d %d\n", config.NICPort, config.BareMetalPort)
+		t.Fail()
+	}
+
+	defaults.Setenv("nic_port", "14369")
+	config = readConfig.Read(defaults)
+	if config.NICPort != 14369 {
+		t.Logf("NICPort incorrect, got: %d\n", config.NICPort)
+		t.Fail()
+	}
+}
+
+func TestRead_ScaleToZero(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := types.ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.ScaleZeroDuration != 15*time.Minute || config.ScaleZeroInterval != 30*time.Second ||
+		config.ScaleFromZeroTimeout != 30*time.Second {
+		t.Logf("Scale to zero defaults incorrect, got: %s %s %s\n", config.ScaleZeroDuration,
+			config.ScaleZeroInterval, config.ScaleFromZeroTimeout)
+		t.Fail()
+	}
+
+	defaults.Setenv("scale_zero_duration", "1h")
+	defaults.Setenv("scale_zero_interval", "0")
+	defaults.Setenv("scale_from_zero_timeout", "5")
+	config = readConfig.Read(defaults)
+	if config.ScaleZeroDuration != time.Hour || config.ScaleZeroInterval != 0 ||
+		config.ScaleFromZeroTimeout != 5*time.Second {
+		t.Logf("Scale to zero incorrect, got: %s %s %s\n", config.ScaleZeroDuration,
+			config.ScaleZeroInterval, config.ScaleFromZeroTimeout)
+		t.Fail()
+	}
+}
+
+func TestRead_NodeConstraints(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := types.ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.NICNodeConstraints != "smartnic=enabled" || config.ContainerNodeConstraints != "smartnic=enabled" {
+		t.Logf("Node constraint defaults incorrect, got: %q %q\n", config.NICNodeConstraints,
+			config.ContainerNodeConstraints)
+		t.Fail()
+	}
+
+	defaults.Setenv("nic_node_constraints", "smartnic=enabled,pool in (nic)")
+	defaults.Setenv("container_node_constraints", "none")
+	config = readConfig.Read(defaults)
+	if config.NICNodeConstraints != "smartnic=enabled,pool in (nic)" || config.ContainerNodeConstraints != "" {
+		t.Logf("Node constraints incorrect, got: %q %q\n", config.NICNodeConstraints,
+			config.ContainerNodeConstraints)
+		t.Fail()
+	}
+}
+
+func TestRead_DeployWaitTimeout(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := types.ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.DeployWaitTimeout != 8*time.Second || config.DeployWaitTimeout >= config.WriteTimeout {
+		t.Logf("DeployWaitTimeout default incorrect, got: %s\n", config.DeployWaitTimeout)
+		t.Fail()
+	}
+
+	defaults.Setenv("deploy_wait_timeout", "45")
+	config = readConfig.Read(defaults)
+	if config.DeployWaitTimeout != 45*time.Second {
+		t.Logf("DeployWaitTimeout incorrect, got: %s\n", config.DeployWaitTimeout)
+		t.Fail()
+	}
+}
+
+func TestRead_AlertScaleStep(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := types.ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.AlertScaleStep != 4 {
+		t.Logf("AlertScaleStep default incorrect, got: %d\n", config.AlertScaleStep)
+		t.Fail()
+	}
+
+	defaults.Setenv("alert_scale_step", "2")
+	config = readConfig.Read(defaults)
+	if config.AlertScaleStep != 2 {
+		t.Logf("AlertScaleStep incorrect, got: %d\n", config.AlertScaleStep)
+		t.Fail()
+	}
+}
+
+func TestRead_NICAutoscaler(t *testing.T) {
+	defaults := NewEnvBucket()
+	readConfig := types.ReadConfig{}
+
+	config := readConfig.Read(defaults)
+	if config.NICAutoscaleInterval != 0 || config.NICAutoscaleTargetRPS != 100 ||
+		config.NICAutoscaleTargetLatency != 0 || config.NICAutoscaleLatencyPercentile != 95 ||
+		config.NICAutoscaleMaxReplicas != 20 {
+		t.Logf("NIC autoscaler target defaults incorrect, got: %s %v %s %v %d\n",
+			config.NICAutoscaleInterval, config.NICAutoscaleTargetRPS, config.NICAutoscaleTargetLatency,
+			config.NICAutoscaleLatencyPercentile, config.NICAutoscaleMaxReplicas)
+		t.Fail()
+	}
+	if config.NICAutoscaleUpCooldown != 30*time.Second || config.NICAutoscaleDownCooldown != 2*time.Minute ||
+		config.NICAutoscaleUpWindow != 0 || config.NICAutoscaleDownWindow != 5*time.Minute {
+		t.Logf("NIC autoscaler pace defaults incorrect, got: %s %s %s %s\n",
+			config.NICAutoscaleUpCooldown, config.NICAutoscaleDownCooldown,
+			config.NICAutoscaleUpWindow, config.NICAutoscaleDownWindow)
+		t.Fail()
+	}
+
+	defaults.Setenv("nic_autoscale_interval", "15s")
+	defaults.Setenv("nic_autoscale_target_rps", "2.5")
+	defaults.Setenv("nic_autoscale_target_latency", "5ms")
+	defaults.Setenv("nic_autoscale_down_window", "0")
+	config = readConfig.Read(defaults)
+	if config.NICAutoscaleInterval != 15*time.Second || config.NICAutoscaleTargetRPS != 2.5 ||
+		config.NICAutoscaleTargetLatency != 5*time.Millisecond || config.NICAutoscaleDownWindow != 0 {
+		t.Logf("NIC autoscaler incorrect, got: %s %v %s %s\n", config.NICAutoscaleInterval,
+			config.NICAutoscaleTargetRPS, config.NICAutoscaleTargetLatency, config.NICAutoscaleDownWindow)
+		t.Fail()
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/handlers"
+	"github.com/Lambda-NIC/faas-netes/test/harness"
+)
+
+// deployAndWait deploys a function with the wait query parameter and
+// returns the status it answered with
+func deployAndWait(t *testing.T, h *harness.Harness, wait, body string, want int) handlers.DeployStatus {
+	reply := do(t, h, http.MethodPost, "/system/functions?wait="+wait, body, want)
+	status := handlers.DeployStatus{}
+	if err := json.Unmarshal([]byte(reply), &status); err != nil {
+		t.Fatalf("want a deploy status, got: %q", reply)
+	}
+	return status
+}
+
+func Test_Flow_DeployWait_Container(t *testing.T) {
+	h := startHarness(t, harness.Config{})
+	defer h.Close()
+
+	h.APIServer.HoldRollouts()
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		h.APIServer.ReleaseRollouts()
+	}()
+	status := deployAndWait(t, h, "true",
+		`{"service":"echo","image":"functions/echo:latest","labels":{"com.openfaas.scale.min":"2"}}`,
+		http.StatusOK)
+	if !status.Ready || status.Function != "echo" || status.Replicas != 2 || status.AvailableReplicas != 2 {
+		t.Errorf("want every replica available, got: %+v", status)
+	}
+
+	h.APIServer.HoldRollouts()
+	status = deployAndWait(t, h, "200ms",
+		`{"service":"slow","image":"functions/echo:latest"}`, http.StatusGatewayTimeout)
+	if status.Ready || status.Reason != handlers.RolloutTimeout || status.AvailableReplicas != 0 ||
+		status.Message != "0 of 1 replicas available after 200ms" {
+		t.Errorf("want the deploy timed out, got: %+v", status)
+	}
+
+	h.APIServer.Put("/api/v1", "default", "pods", map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":   "broken-1",
+			"labels": map[string]interface{}{"faas_function": "broken"},
+		},
+		"status": map[string]interface{}{
+			"containerStatuses": []interface{}{map[string]interface{}{
+				"name": "broken",
+				"state": map[string]interface{}{"waiting": map[string]interface{}{
+					"reason":  "ImagePullBackOff",
+					"message": "Back-off pulling image",
+				}},
+			}},
+		},
+	})
+	started := time.Now()
+	status = deployAndWait(t, h, "true",
+		`{"service":"broken","image":"functions/missing:latest"}`, http.StatusInternalServerError)
+	if status.Reason != "ImagePullBackOff" || status.Message != "pod broken-1: Back-off pulling image" {
+		t.Errorf("want the pod failure reported, got: %+v", status)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("want the failure reported before the timeout, took: %s", elapsed)
+	}
+
+	do(t, h, http.MethodPost, "/system/functions?wait=soon",
+		`{"service":"other","image":"functions/echo:latest"}`, http.StatusBadRequest)
+	if h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "other") != nil {
+		t.Errorf("want no deployment for an invalid wait")
+	}
+}
+
+func Test_Flow_DeployWait_BoundedByWriteTimeout(t *testing.T) {
+	h := startHarness(t, harness.Config{
+		Deploy: handlers.DeployHandlerConfig{WriteTimeout: 2 * time.Second},
+	})
+	defer h.Close()
+
+	h.APIServer.HoldRollouts()
+	status := deployAndWait(t, h, "30s",
+		`{"service":"slow","image":"functions/echo:latest"}`, http.StatusGatewayTimeout)
+	if status.Reason != handlers.RolloutTimeout || status.Message != "0 of 1 replicas available after 1s" {
+		t.Errorf("want the wait cut short before the write timeout, got: %+v", status)
+	}
+}
+
+func Test_Flow_DeployWait_SmartNIC(t *testing.T) {
+	h := startHarness(t, harness.Config{})
+	defer h.Close()
+
+	status := deployAndWait(t, h, "true",
+		`{"service":"echo-lambdanic","image":"smartnic"}`, http.StatusOK)
+	if !status.Ready || status.Replicas != 1 || status.AvailableReplicas != 1 {
+		t.Errorf("want the replica acknowledged, got: %+v", status)
+	}
+
+	// A SmartNIC which is gone does not acknowledge its replicas.
+	h.SmartNICs[0].Close()
+	status = deployAndWait(t, h, "300ms",
+		`{"service":"other-lambdanic","image":"smartnic"}`, http.StatusGatewayTimeout)
+	if status.Reason != handlers.RolloutTimeout ||
+		!reflect.DeepEqual(status.PendingSmartNICs, []string{"127.0.0.1"}) {
+		t.Errorf("want the SmartNIC reported as pending, got: %+v", status)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package test
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lambda-NIC/faas-netes/handlers"
+	"github.com/Lambda-NIC/faas-netes/test/harness"
+)
+
+// specReplicas returns the desired replicas of a deployment
+func specReplicas(deployment map[string]interface{}) float64 {
+	spec, _ := deployment["spec"].(map[string]interface{})
+	replicas, _ := spec["replicas"].(float64)
+	return replicas
+}
+
+// scaledTo waits for the function reader to report replicas, so that the
+// proxy reads the same state from the cache
+func scaledTo(t *testing.T, h *harness.Harness, name string, replicas uint64) bool {
+	return eventually(func() bool {
+		return readReplicas(t, h, name).Replicas == replicas
+	})
+}
+
+func Test_Flow_ScaleToZero(t *testing.T) {
+	h := startHarness(t, harness.Config{Scaling: handlers.ScalingConfig{IdleDuration: time.Hour}})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"idle","image":"functions/echo:latest","labels":{"com.openfaas.scale.zero":"true","com.openfaas.scale.zero-duration":"100ms","com.openfaas.scale.min":"2"}}`,
+		http.StatusAccepted)
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"busy","image":"functions/echo:latest","labels":{"com.openfaas.scale.zero-duration":"100ms"}}`,
+		http.StatusAccepted)
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"hourly","image":"functions/echo:latest","labels":{"com.openfaas.scale.zero":"true"}}`,
+		http.StatusAccepted)
+	if !eventually(func() bool { return len(listFunctions(t, h)) == 3 }) {
+		t.Fatalf("want the functions listed")
+	}
+	do(t, h, http.MethodPost, "/function/idle", "hello", http.StatusOK)
+
+	// Functions start being idle when they are first seen.
+	if err := h.Scaler.ScaleIdle(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if err := h.Scaler.ScaleIdle(); err != nil {
+		t.Fatal(err)
+	}
+	if replicas := specReplicas(h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "idle")); replicas != 0 {
+		t.Errorf("want the idle function scaled to zero, got: %v", replicas)
+	}
+	for _, name := range []string{"busy", "hourly"} {
+		if replicas := specReplicas(h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", name)); replicas != 1 {
+			t.Errorf("want %s left running, got: %v", name, replicas)
+		}
+	}
+
+	if !scaledTo(t, h, "idle", 0) {
+		t.Fatalf("want the function read with no replicas")
+	}
+	if reply := do(t, h, http.MethodPost, "/function/idle", "hello", http.StatusOK); reply != "hello" {
+		t.Errorf("want the reply of the container, got: %q", reply)
+	}
+	if replicas := specReplicas(h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "idle")); replicas != 2 {
+		t.Errorf("want the function scaled to its minimum, got: %v", replicas)
+	}
+
+	// An invoked function is not idle.
+	do(t, h, http.MethodPost, "/function/idle", "hello", http.StatusOK)
+	if err := h.Scaler.ScaleIdle(); err != nil {
+		t.Fatal(err)
+	}
+	if replicas := specReplicas(h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "idle")); replicas != 2 {
+		t.Errorf("want the invoked function left running, got: %v", replicas)
+	}
+}
+
+func Test_Flow_ScaleFromZero_HoldsInvocations(t *testing.T) {
+	h := startHarness(t, harness.Config{})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest"}`, http.StatusAccepted)
+	do(t, h, http.MethodPost, "/system/scale-function/echo",
+		`{"serviceName":"echo","replicas":0}`, http.StatusAccepted)
+	if !scaledTo(t, h, "echo", 0) {
+		t.Fatalf("want the function read with no replicas")
+	}
+
+	h.APIServer.HoldRollouts()
+	var wg sync.WaitGroup
+	replies := make(chan string, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, body, err := h.Do(http.MethodPost, "/function/echo", "hello")
+			if err != nil || status != http.StatusOK {
+				t.Errorf("want the invocation served, got: %d %q %v", status, body, err)
+			}
+			replies <- body
+		}()
+	}
+
+	if !eventually(func() bool {
+		return specReplicas(h.APIServer.Object(harness.DeploymentPrefix, "default", "deployments", "echo")) == 1
+	}) {
+		t.Errorf("want the function scaled to one replica")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(replies) != 0 {
+		t.Errorf("want the invocations held until a replica is available")
+	}
+	h.APIServer.ReleaseRollouts()
+	wg.Wait()
+	if len(replies) != 3 {
+		t.Errorf("want every invocation served, got: %d", len(replies))
+	}
+}
+
+func Test_Flow_ScaleFromZero_Timeout(t *testing.T) {
+	h := startHarness(t, harness.Config{
+		Scaling: handlers.ScalingConfig{ScaleFromZeroTimeout: 200 * time.Millisecond},
+	})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest"}`, http.StatusAccepted)
+	do(t, h, http.MethodPost, "/system/scale-function/echo",
+		`{"serviceName":"echo","replicas":0}`, http.StatusAccepted)
+	if !scaledTo(t, h, "echo", 0) {
+		t.Fatalf("want the function read with no replicas")
+	}
+
+	h.APIServer.HoldRollouts()
+	started := time.Now()
+	do(t, h, http.MethodPost, "/function/echo", "hello", http.StatusServiceUnavailable)
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("want the invocation to fail after the timeout, took: %s", elapsed)
+	}
+}
+
+func Test_Flow_ScaleFromZero_BoundedByWriteTimeout(t *testing.T) {
+	h := startHarness(t, harness.Config{
+		Scaling: handlers.ScalingConfig{ScaleFromZeroTimeout: 30 * time.Second},
+		Proxy:   handlers.ProxyConfig{WriteTimeout: time.Second},
+	})
+	defer h.Close()
+
+	do(t, h, http.MethodPost, "/system/functions",
+		`{"service":"echo","image":"functions/echo:latest"}`, http.StatusAccepted)
+	do(t, h, http.MethodPost, "/system/scale-function/echo",
+		`{"serviceName":"echo","replicas":0}`, http.StatusAccepted)
+	if !scaledTo(t, h, "echo", 0) {
+		t.Fatalf("want the function read with no replicas")
+	}
+
+	h.APIServer.HoldRollouts()
+	started := time.Now()
+	do(t, h, http.MethodPost, "/function/echo", "hello", http.StatusServiceUnavailable)
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("want the invocation to fail before the write timeout, took: %s", elapsed)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// ExporterNone disables tracing
+	ExporterNone = "none"
+	// ExporterMemory keeps the most recent spans in the process
+	ExporterMemory = "memory"
+	// ExporterStdout writes spans as JSON lines to stdout
+	ExporterStdout = "stdout"
+	// ExporterFile appends spans as JSON lines to a file
+	ExporterFile = "file"
+)
+
+// Exporter receives every span when it ends
+type Exporter interface {
+	Export(span *Span)
+}
+
+// NewExporter creates an exporter by name, path is only used by the file
+// exporter.
+func NewExporter(name string, path string) (Exporter, error) {
+	switch name {
+	case ExporterNone, "":
+		return nil, nil
+	case ExporterMemory:
+		return NewMemoryExporter(memoryExporterSize), nil
+	case ExporterStdout:
+		return NewWriterExporter(os.Stdout), nil
+	case ExporterFile:
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return NewWriterExporter(file), nil
+	}
+	return nil, fmt.Errorf("unknown trace exporter: %s", name)
+}
+
+var (
+	exporterMutex sync.RWMutex
+	exporter      Exporter
+)
+
+// SetExporter sets where spans are exported, nil disables tracing.
+func SetExporter(e Exporter) {
+	exporterMutex.Lock()
+	defer exporterMutex.Unlock()
+	exporter = e
+}
+
+// Enabled returns true when an exporter is set
+func Enabled() bool {
+	exporterMutex.RLock()
+	defer exporterMutex.RUnlock()
+	return exporter != nil
+}
+
+type noopExporter struct{}
+
+func (noopExporter) Export(span *Span) {}
+
+func currentExporter() Exporter {
+	exporterMutex.RLock()
+	defer exporterMutex.RUnlock()
+	if exporter == nil {
+		return noopExporter{}
+	}
+	return exporter
+}
+
+// memoryExporterSize is how many spans the memory exporter keeps
+const memoryExporterSize = 1024
+
+// MemoryExporter keeps the most recent spans in a fixed size ring
+type MemoryExporter struct {
+	mutex sync.Mutex
+	spans []*Span
+	next  int
+	full  bool
+}
+
+// NewMemoryExporter creates an exporter which keeps the last size spans
+func NewMemoryExporter(size int) *MemoryExporter {
+	return &MemoryExporter{spans: make([]*Span, size)}
+}
+
+// Export keeps the span, dropping the oldest one when full
+func (m *MemoryExporter) Export(span *Span) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.spans[m.next] = span
+	m.next = (m.next + 1) % len(m.spans)
+	if m.next == 0 {
+		m.full = true
+	}
+}
+
+// Spans returns the kept spans from the oldest to the most recent
+func (m *MemoryExporter) Spans() []*Span {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.full {
+		return append([]*Span{}, m.spans[:m.next]...)
+	}
+	return append(append([]*Span{}, m.spans[m.next:]...), m.spans[:m.next]...)
+}
+
+// WriterExporter writes every span as a line of JSON
+type WriterExporter struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewWriterExporter creates an exporter which writes to w
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{encoder: json.NewEncoder(w)}
+}
+
+// Export writes the span
+func (e *WriterExporter) Export(span *Span) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	e.encoder.Encode(span)
+}
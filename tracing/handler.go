@@ -0,0 +1,40 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package tracing
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Lambda-NIC/faas-netes/logging"
+)
+
+// Handler records a span named name for every request served by next. The
+// span continues the trace of the request and is carried by its context.
+func Handler(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !Enabled() {
+			next(w, r)
+			return
+		}
+		ctx, span := StartSpanFromRequest(r, name)
+		defer span.Finish()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.path", r.URL.Path)
+
+		recorder := logging.NewStatusRecorder(w)
+		next(recorder, r.WithContext(ctx))
+		span.SetAttribute("http.status_code", strconv.Itoa(recorder.Status))
+	}
+}
+
+// MakeSpansHandler serves the spans kept by a memory exporter as JSON
+func MakeSpansHandler(exporter *MemoryExporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		spansBytes, _ := json.Marshal(exporter.Spans())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(spansBytes)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader is the W3C trace context header
+const TraceparentHeader = "traceparent"
+
+// FormatTraceparent encodes a span context as a version 00 traceparent with
+// the sampled flag set
+func FormatTraceparent(c SpanContext) string {
+	return fmt.Sprintf("00-%s-%s-01", c.TraceID, c.SpanID)
+}
+
+// ParseTraceparent decodes a traceparent header, it returns false when the
+// header is missing or malformed.
+func ParseTraceparent(header string) (SpanContext, bool) {
+	c := SpanContext{}
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return c, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return c, false
+	}
+	if _, err := hex.Decode(c.TraceID[:], []byte(parts[1])); err != nil {
+		return c, false
+	}
+	if _, err := hex.Decode(c.SpanID[:], []byte(parts[2])); err != nil {
+		return c, false
+	}
+	return c, c.IsValid()
+}
+
+// StartSpanFromRequest starts a span which continues the trace of the
+// traceparent header of an incoming request.
+func StartSpanFromRequest(r *http.Request, name string) (context.Context, *Span) {
+	parent, _ := ParseTraceparent(r.Header.Get(TraceparentHeader))
+	return StartSpanWithParent(r.Context(), name, parent)
+}
+
+// Inject sets the traceparent header of an outgoing request to the span
+// carried by ctx.
+func Inject(ctx context.Context, header http.Header) {
+	if span := SpanFromContext(ctx); span != nil {
+		header.Set(TraceparentHeader, FormatTraceparent(span.Context()))
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) Sean Choi 2018. All rights reserved.
+
+// Package tracing records spans of the work done by the provider in the
+// style of OpenTelemetry. Spans are linked through the context and across
+// processes with the W3C traceparent header, and handed to the configured
+// exporter when they end.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// TraceID identifies every span of a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the trace ID as lowercase hex
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid returns false for the all zero trace ID
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// String returns the span ID as lowercase hex
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid returns false for the all zero span ID
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanContext is the part of a span which is propagated to its children
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid returns true when both IDs are set
+func (c SpanContext) IsValid() bool {
+	return c.TraceID.IsValid() && c.SpanID.IsValid()
+}
+
+// Span is a timed operation within a trace
+type Span struct {
+	Name       string            `json:"name"`
+	TraceID    string            `json:"traceId"`
+	SpanID     string            `json:"spanId"`
+	ParentID   string            `json:"parentId,omitempty"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+
+	context SpanContext
+	mutex   sync.Mutex
+	ended   bool
+}
+
+// Context returns the IDs to propagate to the children of the span
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttribute annotates the span with a key and value
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = map[string]string{}
+	}
+	s.Attributes[key] = value
+}
+
+// SetError marks the span as failed, a nil error is ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Error = err.Error()
+}
+
+// Finish ends the span and hands it to the exporter, only the first call
+// has an effect
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mutex.Unlock()
+
+	currentExporter().Export(s)
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a context carrying the span, spans started from it
+// become its children
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by the context or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// StartSpan starts a span which is a child of the span carried by ctx, or the
+// root of a new trace. It returns nil when tracing is disabled, every method
+// of a nil span does nothing.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	return StartSpanWithParent(ctx, name, SpanFromContext(ctx).Context())
+}
+
+// StartSpanWithParent starts a span which is a child of parent, such as one
+// received in a traceparent header, or the root of a new trace when parent is
+// not valid.
+func StartSpanWithParent(ctx context.Context, name string,
+	parent SpanContext) (context.Context, *Span) {
+	if !Enabled() {
+		return ctx, nil
+	}
+	span := &Span{Name: name, Start: time.Now()}
+	if parent.IsValid() {
+		span.context.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID.String()
+	} else {
+		rand.Read(span.context.TraceID[:])
+	}
+	rand.Read(span.context.SpanID[:])
+	span.TraceID = span.context.TraceID.String()
+	span.SpanID = span.context.SpanID.String()
+	return ContextWithSpan(ctx, span), span
+}
@@ -0,0 +1,136 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_ParseTraceparent(t *testing.T) {
+	cases := []struct {
+		scenario string
+		header   string
+		valid    bool
+	}{
+		{"valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"future version with extra field", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"empty", "", false},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"short span ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba9-01", false},
+		{"not hex", "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", false},
+	}
+
+	for _, testCase := range cases {
+		c, valid := ParseTraceparent(testCase.header)
+		if valid != testCase.valid {
+			t.Errorf("Scenario: %s want valid: %v, got: %v", testCase.scenario, testCase.valid, valid)
+		}
+		if valid && c.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Scenario: %s wrong trace ID: %s", testCase.scenario, c.TraceID)
+		}
+	}
+}
+
+func Test_FormatTraceparent_RoundTrip(t *testing.T) {
+	SetExporter(NewMemoryExporter(4))
+	defer SetExporter(nil)
+
+	_, span := StartSpan(context.Background(), "root")
+	header := FormatTraceparent(span.Context())
+	c, valid := ParseTraceparent(header)
+	if !valid || c != span.Context() {
+		t.Errorf("want: %v, got: %v from %s", span.Context(), c, header)
+	}
+}
+
+func Test_StartSpan_Disabled(t *testing.T) {
+	SetExporter(nil)
+	ctx, span := StartSpan(context.Background(), "root")
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Errorf("want no span when tracing is disabled")
+	}
+	span.SetAttribute("key", "value")
+	span.Finish()
+}
+
+func Test_StartSpan_ChildJoinsTrace(t *testing.T) {
+	exporter := NewMemoryExporter(4)
+	SetExporter(exporter)
+	defer SetExporter(nil)
+
+	ctx, root := StartSpan(context.Background(), "root")
+	_, child := StartSpan(ctx, "child")
+	child.Finish()
+	root.Finish()
+	root.Finish()
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("want: 2 spans exported once, got: %d", len(spans))
+	}
+	if spans[0].TraceID != root.TraceID || spans[0].ParentID != root.SpanID {
+		t.Errorf("want child of %s/%s, got: %s/%s", root.TraceID, root.SpanID,
+			spans[0].TraceID, spans[0].ParentID)
+	}
+}
+
+func Test_MemoryExporter_KeepsMostRecent(t *testing.T) {
+	exporter := NewMemoryExporter(2)
+	for _, name := range []string{"a", "b", "c"} {
+		exporter.Export(&Span{Name: name})
+	}
+	spans := exporter.Spans()
+	if len(spans) != 2 || spans[0].Name != "b" || spans[1].Name != "c" {
+		t.Errorf("want: [b c], got: %v", spans)
+	}
+}
+
+func Test_WriterExporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	NewWriterExporter(buf).Export(&Span{Name: "root", TraceID: "abc"})
+
+	span := Span{}
+	if err := json.Unmarshal(buf.Bytes(), &span); err != nil {
+		t.Fatal(err)
+	}
+	if span.Name != "root" || span.TraceID != "abc" {
+		t.Errorf("want span root/abc, got: %s/%s", span.Name, span.TraceID)
+	}
+}
+
+func Test_Handler_ContinuesTraceAndInjects(t *testing.T) {
+	exporter := NewMemoryExporter(4)
+	SetExporter(exporter)
+	defer SetExporter(nil)
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var injected http.Header
+	handler := Handler("deploy", func(w http.ResponseWriter, r *http.Request) {
+		injected = http.Header{}
+		Inject(r.Context(), injected)
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, "/system/functions", nil)
+	req.Header.Set(TraceparentHeader, parent)
+	handler(httptest.NewRecorder(), req)
+
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("want: 1 span, got: %d", len(spans))
+	}
+	span := spans[0]
+	if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("want trace continued from traceparent, got: %s/%s", span.TraceID, span.ParentID)
+	}
+	if span.Attributes["http.status_code"] != "202" {
+		t.Errorf("want status code 202, got: %v", span.Attributes)
+	}
+	if want := FormatTraceparent(span.Context()); injected.Get(TraceparentHeader) != want {
+		t.Errorf("want injected traceparent: %s, got: %s", want, injected.Get(TraceparentHeader))
+	}
+}
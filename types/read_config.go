@@ -5,6 +5,7 @@ package types
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -52,6 +53,29 @@ func parseIntOrDurationValue(val string, fallback time.Duration) time.Duration {
 	return duration
 }
 
+func parseFloatValue(val string, fallback float64) float64 {
+	if len(val) > 0 {
+		parsedVal, parseErr := strconv.ParseFloat(val, 64)
+		if parseErr == nil && parsedVal >= 0 {
+			return parsedVal
+		}
+	}
+	return fallback
+}
+
+func parseListValue(val string, fallback []string) []string {
+	if len(val) > 0 {
+		list := []string{}
+		for _, item := range strings.Split(val, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				list = append(list, item)
+			}
+		}
+		return list
+	}
+	return fallback
+}
+
 func parseBoolValue(val string, fallback bool) bool {
 	if len(val) > 0 {
 		return val == "true"
@@ -66,6 +90,15 @@ func parseString(val string, fallback string) string {
 	return fallback
 }
 
+// parseNodeConstraints reads a node label selector, none meaning no
+// constraints
+func parseNodeConstraints(val string, fallback string) string {
+	if val == "none" {
+		return ""
+	}
+	return parseString(val, fallback)
+}
+
 // Read fetches config from environmental variables.
 func (ReadConfig) Read(hasEnv HasEnv) BootstrapConfig {
 	cfg := BootstrapConfig{}
@@ -85,6 +118,43 @@ func (ReadConfig) Read(hasEnv HasEnv) BootstrapConfig {
 
 	imagePullPolicy := parseString(hasEnv.Getenv("image_pull_policy"), "Always")
 
+	nicTimeout := parseIntOrDurationValue(hasEnv.Getenv("nic_timeout"), time.Second*2)
+	nicMaxInflight := parseIntValue(hasEnv.Getenv("nic_max_inflight"), 0)
+	routingRefreshInterval := parseIntOrDurationValue(hasEnv.Getenv("routing_refresh_interval"), time.Second*1)
+	nicRoutingMode := parseString(hasEnv.Getenv("nic_routing_mode"), "random")
+	nicHedgeDelay := parseIntOrDurationValue(hasEnv.Getenv("nic_hedge_delay"), time.Millisecond*10)
+	nicBreakerFailures := parseIntValue(hasEnv.Getenv("nic_breaker_failures"), 5)
+	nicBreakerErrorRate := parseFloatValue(hasEnv.Getenv("nic_breaker_error_rate"), 0.5)
+	nicBreakerOpenDuration := parseIntOrDurationValue(hasEnv.Getenv("nic_breaker_open_duration"), time.Second*5)
+	invocationFlushInterval := parseIntOrDurationValue(hasEnv.Getenv("invocation_flush_interval"), time.Second*5)
+	replicaID := hasEnv.Getenv("replica_id")
+	traceExporter := parseString(hasEnv.Getenv("trace_exporter"), "none")
+	traceFile := parseString(hasEnv.Getenv("trace_file"), "faas-netes-traces.jsonl")
+	readinessChecks := parseListValue(hasEnv.Getenv("readiness_checks"), []string{"etcd", "kubernetes", "cache"})
+	logLevel := parseString(hasEnv.Getenv("log_level"), "info")
+	logFormat := parseString(hasEnv.Getenv("log_format"), "logfmt")
+	invocationTraceFile := hasEnv.Getenv("invocation_trace_file")
+	invocationTraceMaxBytes := parseIntValue(hasEnv.Getenv("invocation_trace_max_bytes"), 100*1024*1024)
+	invocationTraceMaxFiles := parseIntValue(hasEnv.Getenv("invocation_trace_max_files"), 5)
+	nicPort := parseIntValue(hasEnv.Getenv("nic_port"), 4369)
+	bareMetalPort := parseIntValue(hasEnv.Getenv("baremetal_port"), 10000)
+	scaleZeroDuration := parseIntOrDurationValue(hasEnv.Getenv("scale_zero_duration"), time.Minute*15)
+	scaleZeroInterval := parseIntOrDurationValue(hasEnv.Getenv("scale_zero_interval"), time.Second*30)
+	scaleFromZeroTimeout := parseIntOrDurationValue(hasEnv.Getenv("scale_from_zero_timeout"), time.Second*30)
+	nicNodeConstraints := parseNodeConstraints(hasEnv.Getenv("nic_node_constraints"), "smartnic=enabled")
+	containerNodeConstraints := parseNodeConstraints(hasEnv.Getenv("container_node_constraints"), "smartnic=enabled")
+	deployWaitTimeout := parseIntOrDurationValue(hasEnv.Getenv("deploy_wait_timeout"), time.Second*8)
+	alertScaleStep := parseIntValue(hasEnv.Getenv("alert_scale_step"), 4)
+	nicAutoscaleInterval := parseIntOrDurationValue(hasEnv.Getenv("nic_autoscale_interval"), 0)
+	nicAutoscaleTargetRPS := parseFloatValue(hasEnv.Getenv("nic_autoscale_target_rps"), 100)
+	nicAutoscaleTargetLatency := parseIntOrDurationValue(hasEnv.Getenv("nic_autoscale_target_latency"), 0)
+	nicAutoscaleLatencyPercentile := parseFloatValue(hasEnv.Getenv("nic_autoscale_latency_percentile"), 95)
+	nicAutoscaleMaxReplicas := parseIntValue(hasEnv.Getenv("nic_autoscale_max_replicas"), 20)
+	nicAutoscaleUpCooldown := parseIntOrDurationValue(hasEnv.Getenv("nic_autoscale_up_cooldown"), time.Second*30)
+	nicAutoscaleDownCooldown := parseIntOrDurationValue(hasEnv.Getenv("nic_autoscale_down_cooldown"), time.Minute*2)
+	nicAutoscaleUpWindow := parseIntOrDurationValue(hasEnv.Getenv("nic_autoscale_up_window"), 0)
+	nicAutoscaleDownWindow := parseIntOrDurationValue(hasEnv.Getenv("nic_autoscale_down_window"), time.Minute*5)
+
 	cfg.ReadTimeout = readTimeout
 	cfg.WriteTimeout = writeTimeout
 
@@ -100,6 +170,43 @@ func (ReadConfig) Read(hasEnv HasEnv) BootstrapConfig {
 
 	cfg.ImagePullPolicy = imagePullPolicy
 
+	cfg.NICTimeout = nicTimeout
+	cfg.NICMaxInflight = nicMaxInflight
+	cfg.RoutingRefreshInterval = routingRefreshInterval
+	cfg.NICRoutingMode = nicRoutingMode
+	cfg.NICHedgeDelay = nicHedgeDelay
+	cfg.NICBreakerFailures = nicBreakerFailures
+	cfg.NICBreakerErrorRate = nicBreakerErrorRate
+	cfg.NICBreakerOpenDuration = nicBreakerOpenDuration
+	cfg.InvocationFlushInterval = invocationFlushInterval
+	cfg.ReplicaID = replicaID
+	cfg.ReadinessChecks = readinessChecks
+	cfg.TraceExporter = traceExporter
+	cfg.TraceFile = traceFile
+	cfg.LogLevel = logLevel
+	cfg.LogFormat = logFormat
+	cfg.InvocationTraceFile = invocationTraceFile
+	cfg.InvocationTraceMaxBytes = invocationTraceMaxBytes
+	cfg.InvocationTraceMaxFiles = invocationTraceMaxFiles
+	cfg.NICPort = nicPort
+	cfg.BareMetalPort = bareMetalPort
+	cfg.ScaleZeroDuration = scaleZeroDuration
+	cfg.ScaleZeroInterval = scaleZeroInterval
+	cfg.ScaleFromZeroTimeout = scaleFromZeroTimeout
+	cfg.NICNodeConstraints = nicNodeConstraints
+	cfg.ContainerNodeConstraints = containerNodeConstraints
+	cfg.DeployWaitTimeout = deployWaitTimeout
+	cfg.AlertScaleStep = alertScaleStep
+	cfg.NICAutoscaleInterval = nicAutoscaleInterval
+	cfg.NICAutoscaleTargetRPS = nicAutoscaleTargetRPS
+	cfg.NICAutoscaleTargetLatency = nicAutoscaleTargetLatency
+	cfg.NICAutoscaleLatencyPercentile = nicAutoscaleLatencyPercentile
+	cfg.NICAutoscaleMaxReplicas = nicAutoscaleMaxReplicas
+	cfg.NICAutoscaleUpCooldown = nicAutoscaleUpCooldown
+	cfg.NICAutoscaleDownCooldown = nicAutoscaleDownCooldown
+	cfg.NICAutoscaleUpWindow = nicAutoscaleUpWindow
+	cfg.NICAutoscaleDownWindow = nicAutoscaleDownWindow
+
 	defaultTCPPort := 8080
 	cfg.Port = parseIntValue(hasEnv.Getenv("port"), defaultTCPPort)
 
@@ -121,4 +228,106 @@ type BootstrapConfig struct {
 	WriteTimeout                      time.Duration
 	ImagePullPolicy                   string
 	Port                              int
+	// NICTimeout is how long the proxy waits for a SmartNIC to reply.
+	NICTimeout time.Duration
+	// NICMaxInflight is how many invocations may be outstanding on a
+	// SmartNIC before hybrid functions overflow to their container.
+	NICMaxInflight int
+	// RoutingRefreshInterval is how often the proxy reloads SmartNIC
+	// placement and health from etcd.
+	RoutingRefreshInterval time.Duration
+	// NICRoutingMode is how the proxy picks a SmartNIC for functions which
+	// do not select a mode themselves, either random or adaptive.
+	NICRoutingMode string
+	// NICHedgeDelay is how long hedged functions wait for a SmartNIC before
+	// sending a duplicate, until enough latencies were seen to use the
+	// percentile.
+	NICHedgeDelay time.Duration
+	// NICBreakerFailures is how many failures in a row open the circuit of
+	// a SmartNIC, zero disables it.
+	NICBreakerFailures int
+	// NICBreakerErrorRate is the share of failed recent invocations which
+	// opens the circuit of a SmartNIC, zero disables it.
+	NICBreakerErrorRate float64
+	// NICBreakerOpenDuration is how long the circuit of a SmartNIC stays
+	// open before probe invocations are let through.
+	NICBreakerOpenDuration time.Duration
+	// InvocationFlushInterval is how often the invocations counted by this
+	// replica are written to etcd.
+	InvocationFlushInterval time.Duration
+	// ReplicaID names the shard the invocations counted by this replica are
+	// written to, the hostname when empty. It should outlive the pod, such
+	// as the name of a StatefulSet pod.
+	ReplicaID string
+	// ReadinessChecks are the checks which must pass for /readyz to report
+	// the provider ready, out of etcd, kubernetes, cache and smartnic.
+	ReadinessChecks []string
+	// TraceExporter is where spans are exported, one of none, memory,
+	// stdout and file.
+	TraceExporter string
+	// TraceFile is the file spans are appended to by the file exporter.
+	TraceFile string
+	// LogLevel is the lowest level logged, one of debug, info, warn and
+	// error.
+	LogLevel string
+	// LogFormat is how log lines are written, either logfmt or json.
+	LogFormat string
+	// InvocationTraceFile is the file every invocation is appended to, none
+	// are recorded when it is empty.
+	InvocationTraceFile string
+	// InvocationTraceMaxBytes is the size after which the invocation trace
+	// is rotated.
+	InvocationTraceMaxBytes int
+	// InvocationTraceMaxFiles is how many rotated invocation traces are
+	// kept.
+	InvocationTraceMaxFiles int
+	// NICPort is the UDP port invocations are sent to on SmartNICs.
+	NICPort int
+	// BareMetalPort is the UDP port invocations are sent to on bare-metal
+	// servers.
+	BareMetalPort int
+	// ScaleZeroDuration is how long container functions which opted in
+	// must be idle before they are scaled to zero, unless they set their
+	// own.
+	ScaleZeroDuration time.Duration
+	// ScaleZeroInterval is how often idle functions are looked for, zero
+	// disables scaling to zero.
+	ScaleZeroInterval time.Duration
+	// ScaleFromZeroTimeout is how long invocations of a function with no
+	// replicas wait for it to be scaled up.
+	ScaleFromZeroTimeout time.Duration
+	// NICNodeConstraints and ContainerNodeConstraints are the node label
+	// selectors the containers of SmartNIC and container functions are
+	// scheduled with, before their own constraints.
+	NICNodeConstraints       string
+	ContainerNodeConstraints string
+	// DeployWaitTimeout is how long deploys asked to wait with wait=true
+	// wait for the function to be ready. Every wait ends before
+	// WriteTimeout.
+	DeployWaitTimeout time.Duration
+	// AlertScaleStep is how many replicas a function is scaled up by while
+	// its alerts fire and down by once they are resolved.
+	AlertScaleStep int
+	// NICAutoscaleInterval is how often SmartNIC functions are autoscaled,
+	// zero disables the autoscaler.
+	NICAutoscaleInterval time.Duration
+	// NICAutoscaleTargetRPS is the invocations per second each replica of
+	// a SmartNIC function should serve, zero ignores the rate.
+	NICAutoscaleTargetRPS float64
+	// NICAutoscaleTargetLatency is the latency SmartNIC functions should
+	// stay under at NICAutoscaleLatencyPercentile, zero ignores it.
+	NICAutoscaleTargetLatency     time.Duration
+	NICAutoscaleLatencyPercentile float64
+	// NICAutoscaleMaxReplicas bounds the replicas of SmartNIC functions
+	// without a com.openfaas.scale.max label.
+	NICAutoscaleMaxReplicas int
+	// NICAutoscaleUpCooldown and NICAutoscaleDownCooldown are how long a
+	// SmartNIC function is left alone after it was autoscaled.
+	NICAutoscaleUpCooldown   time.Duration
+	NICAutoscaleDownCooldown time.Duration
+	// NICAutoscaleUpWindow and NICAutoscaleDownWindow are how far back
+	// the autoscaler looks for the lowest recommendation to scale up to
+	// and the highest to scale down to.
+	NICAutoscaleUpWindow   time.Duration
+	NICAutoscaleDownWindow time.Duration
 }
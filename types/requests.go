@@ -3,7 +3,98 @@
 
 package types
 
+import (
+	"time"
+
+	providerTypes "github.com/Lambda-NIC/faas-provider/types"
+)
+
 type ScaleServiceRequest struct {
 	ServiceName string `json:"serviceName"`
 	Replicas    uint64 `json:"replicas"`
 }
+
+// SmartNICStatus reports the state of a SmartNIC and the functions it hosts
+type SmartNICStatus struct {
+	Address       string             `json:"address"`
+	Health        string             `json:"health"`
+	Circuit       string             `json:"circuit"`
+	LastHeartbeat *time.Time         `json:"lastHeartbeat,omitempty"`
+	Capacity      uint64             `json:"capacity"`
+	CapacityUsed  uint64             `json:"capacityUsed"`
+	Functions     []FunctionReplicas `json:"functions"`
+}
+
+// FunctionReplicas is the number of replicas of a function on a SmartNIC
+type FunctionReplicas struct {
+	Name     string `json:"name"`
+	Replicas uint64 `json:"replicas"`
+}
+
+// FunctionPlacement reports the SmartNICs which host a function
+type FunctionPlacement struct {
+	Name      string              `json:"name"`
+	Replicas  uint64              `json:"replicas"`
+	SmartNICs []SmartNICPlacement `json:"smartnics"`
+}
+
+// SmartNICPlacement is the number of replicas of a function on a SmartNIC
+// along with the state of that SmartNIC
+type SmartNICPlacement struct {
+	Address       string     `json:"address"`
+	Replicas      uint64     `json:"replicas"`
+	Health        string     `json:"health"`
+	Circuit       string     `json:"circuit"`
+	LastHeartbeat *time.Time `json:"lastHeartbeat,omitempty"`
+	Capacity      uint64     `json:"capacity"`
+	CapacityUsed  uint64     `json:"capacityUsed"`
+}
+
+// FunctionMeta holds the labels and annotations of a SmartNIC function
+type FunctionMeta struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// ReadinessStatus reports whether the provider is ready to take traffic along
+// with the result of each readiness check
+type ReadinessStatus struct {
+	Ready  bool                   `json:"ready"`
+	Checks map[string]CheckStatus `json:"checks"`
+}
+
+// CheckStatus is the result of a readiness check
+type CheckStatus struct {
+	Healthy  bool   `json:"healthy"`
+	Required bool   `json:"required"`
+	Duration string `json:"duration,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ProviderInfo extends the provider information with the backends and
+// capabilities of the cluster
+type ProviderInfo struct {
+	providerTypes.InfoRequest
+	Capabilities ProviderCapabilities `json:"capabilities"`
+}
+
+// ProviderCapabilities lists what clients can rely on when deploying to and
+// invoking functions through the provider
+type ProviderCapabilities struct {
+	Backends            []string        `json:"backends"`
+	Pools               []BackendPool   `json:"pools"`
+	ProtocolVersions    []string        `json:"protocolVersions"`
+	RoutingModes        []string        `json:"routingModes"`
+	PlacementStrategies []string        `json:"placementStrategies"`
+	Features            map[string]bool `json:"features"`
+}
+
+// BackendPool is the size and health of the hosts serving the backends,
+// each host is counted once however many backends it serves
+type BackendPool struct {
+	Backends  []string `json:"backends"`
+	Size      int      `json:"size"`
+	Healthy   int      `json:"healthy"`
+	Unhealthy int      `json:"unhealthy"`
+	Unknown   int      `json:"unknown"`
+}